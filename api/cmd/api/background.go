@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"api/cmd/api/handlers"
+)
+
+// startBackgroundTasks starts the periodic jobs. They run until ctx is
+// cancelled, and serveHTTP waits for them through app.wg on shutdown.
+func (app *application) startBackgroundTasks(ctx context.Context) {
+	if app.config.purge.retention > 0 {
+		app.runPeriodically(ctx, "purge", app.config.purge.interval, app.purgeDeleted)
+	}
+	app.runPeriodically(ctx, "idempotency", app.config.idempotencyPurgeInterval, app.purgeIdempotencyKeys)
+	app.runPeriodically(ctx, "publish", app.config.publishInterval, app.publishScheduled)
+}
+
+// runPeriodically runs fn every interval, which must be positive, see
+// checkInterval.
+func (app *application) runPeriodically(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				app.runTask(ctx, name, fn)
+			}
+		}
+	}()
+}
+
+// runTask runs fn once for runPeriodically. A panic is logged like an
+// error, and the task runs again at the next tick.
+func (app *application) runTask(ctx context.Context, name string, fn func(context.Context) error) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			app.logger.Error(fmt.Sprintf("%s", err), "task", name)
+		}
+	}()
+
+	err := fn(ctx)
+	if err != nil && ctx.Err() == nil {
+		app.logger.Error(err.Error(), "task", name)
+	}
+}
+
+// purgeDeleted hard deletes the rows that were soft deleted longer ago than
+// the retention. Running it on several instances at once is harmless.
+func (app *application) purgeDeleted(ctx context.Context) error {
+	before := time.Now().Add(-app.config.purge.retention)
+
+	var users, posts int64
+	err := app.store.BeginTx(ctx, &sql.TxOptions{}, func(r *handlers.Repositories) error {
+		var err error
+		posts, err = r.Posts.Purge(before)
+		if err != nil {
+			return err
+		}
+		users, err = r.Users.Purge(before)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if users > 0 || posts > 0 {
+		app.logger.Info("purged deleted rows", "users", users, "posts", posts)
+	}
+	return nil
+}
+
+// purgeIdempotencyKeys deletes the Idempotency-Keys that expired.
+func (app *application) purgeIdempotencyKeys(ctx context.Context) error {
+	var keys int64
+	err := app.store.BeginTx(ctx, &sql.TxOptions{}, func(r *handlers.Repositories) error {
+		var err error
+		keys, err = r.Idempotency.Purge(time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if keys > 0 {
+		app.logger.Info("purged expired idempotency keys", "keys", keys)
+	}
+	return nil
+}
+
+// publishScheduled publishes the scheduled posts whose publish_at has come.
+// Running it on several instances at once publishes every post once, see
+// handlers.PostsPublishTx.
+func (app *application) publishScheduled(ctx context.Context) error {
+	var posts int64
+	err := app.store.BeginTx(ctx, &sql.TxOptions{}, func(r *handlers.Repositories) error {
+		var err error
+		posts, err = r.Posts.Publish(time.Now())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if posts > 0 {
+		app.logger.Info("published scheduled posts", "posts", posts)
+	}
+	return nil
+}
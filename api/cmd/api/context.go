@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"api/cmd/api/handlers"
+
+	"github.com/tomasen/realip"
+)
+
+type contextKey string
+
+const (
+	isAdminContextKey     = contextKey("isAdmin")
+	ifMatchContextKey     = contextKey("ifMatch")
+	contentTypeContextKey = contextKey("contentType")
+	requestIDContextKey   = contextKey("requestID")
+	queryContextKey       = contextKey("query")
+	contentViewContextKey = contextKey("contentView")
+)
+
+func contextSetAdmin(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), isAdminContextKey, true)
+	return r.WithContext(ctx)
+}
+
+func contextIsAdmin(ctx context.Context) bool {
+	isAdmin, ok := ctx.Value(isAdminContextKey).(bool)
+	return ok && isAdmin
+}
+
+// contextSetIfMatch passes the If-Match header on to the handler, which
+// checks it against the row it is about to change, see checkIfMatch.
+func contextSetIfMatch(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), ifMatchContextKey, r.Header.Get("If-Match"))
+	return r.WithContext(ctx)
+}
+
+func contextIfMatch(ctx context.Context) string {
+	ifMatch, _ := ctx.Value(ifMatchContextKey).(string)
+	return ifMatch
+}
+
+// contextSetContentType passes the Content-Type header on to mutations,
+// for PATCH routes, which take more than one kind of body.
+func contextSetContentType(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), contentTypeContextKey, r.Header.Get("Content-Type"))
+	return r.WithContext(ctx)
+}
+
+func contextContentType(ctx context.Context) string {
+	contentType, _ := ctx.Value(contentTypeContextKey).(string)
+	return contentType
+}
+
+// contextSetQuery passes the query string on to mutations, for flags such
+// as atomic on batches.
+func contextSetQuery(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), queryContextKey, r.URL.Query())
+	return r.WithContext(ctx)
+}
+
+func contextQuery(ctx context.Context) url.Values {
+	query, _ := ctx.Value(queryContextKey).(url.Values)
+	return query
+}
+
+// contextSetContentView passes the parts of the content of posts that the
+// request asked for on, for routes that return posts, see withContentView.
+func contextSetContentView(r *http.Request, view string) *http.Request {
+	ctx := context.WithValue(r.Context(), contentViewContextKey, view)
+	return r.WithContext(ctx)
+}
+
+func contextContentView(ctx context.Context) (string, bool) {
+	view, ok := ctx.Value(contentViewContextKey).(string)
+	return view, ok
+}
+
+func contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+func contextRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// contextActor names who is making the request in the audit log: the user
+// of X-User-Id, or else the admin or anonymous.
+func contextActor(ctx context.Context) string {
+	if userId, ok := handlers.ViewerFrom(ctx); ok {
+		return userId.String()
+	}
+	if contextIsAdmin(ctx) {
+		return "admin"
+	}
+	return "anonymous"
+}
+
+// contextSetAuditor has the store record the writes of the request in the
+// audit log.
+func contextSetAuditor(r *http.Request) *http.Request {
+	ctx := r.Context()
+	ctx = handlers.ContextWithAuditor(ctx, handlers.Auditor{
+		Actor:     contextActor(ctx),
+		RequestId: contextRequestID(ctx),
+		IP:        realip.FromRequest(r),
+	})
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestContextActor(t *testing.T) {
+	app := &application{config: config{adminToken: "secret"}}
+	userId := uuid.New()
+
+	tests := []struct {
+		admin    bool
+		userId   string
+		expected string
+	}{
+		{false, "", "anonymous"},
+		{true, "", "admin"},
+		{false, userId.String(), userId.String()},
+		{true, userId.String(), userId.String()},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/posts", nil)
+		if tc.admin {
+			r.Header.Set("Authorization", "Bearer secret")
+		}
+		if tc.userId != "" {
+			r.Header.Set("X-User-Id", tc.userId)
+		}
+
+		var actor string
+		app.authenticate(app.identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor = contextActor(r.Context())
+		}))).ServeHTTP(httptest.NewRecorder(), r)
+		if actor != tc.expected {
+			t.Errorf("Wrong actor with admin=%v userId=%q: %q, expected %q", tc.admin, tc.userId, actor, tc.expected)
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
 	"strings"
 
+	"api/cmd/api/handlers"
 	"api/internal/response"
 )
 
@@ -32,6 +34,57 @@ func (app *application) errorMessage(w http.ResponseWriter, r *http.Request, sta
 	}
 }
 
+func (app *application) failedValidation(w http.ResponseWriter, r *http.Request, status int, message string, fieldErrors map[string]string) {
+	message = strings.ToUpper(message[:1]) + message[1:]
+
+	err := response.JSON(w, status, map[string]any{"Error": message, "FieldErrors": fieldErrors})
+	if err != nil {
+		app.reportServerError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// handlerError writes the response for an error returned by a handler.
+// HTTPErrors are shown to the client, and so are constraint violations,
+// through handlers.TranslateError, and movedErrors are redirects. Anything
+// else is a server error.
+func (app *application) handlerError(w http.ResponseWriter, r *http.Request, err error) {
+	var moved *movedError
+	if errors.As(err, &moved) {
+		app.movedPermanently(w, r, moved.location)
+		return
+	}
+	httpErr, ok := handlers.TranslateError(err).(*handlers.HTTPError)
+	if !ok {
+		app.serverError(w, r, err)
+		return
+	}
+	if httpErr.FieldErrors != nil {
+		app.failedValidation(w, r, httpErr.Code, httpErr.Message.Error(), httpErr.FieldErrors)
+		return
+	}
+	app.errorMessage(w, r, httpErr.Code, httpErr.Message.Error(), nil)
+}
+
+// movedError is returned by lookups that found the resource by a name it
+// no longer has, like the old slug of a post.
+type movedError struct {
+	location string
+}
+
+func (e *movedError) Error() string {
+	return "moved to " + e.location
+}
+
+// movedPermanently redirects to location, with the query of the request.
+func (app *application) movedPermanently(w http.ResponseWriter, r *http.Request, location string) {
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	headers := http.Header{"Location": {location}}
+	app.errorMessage(w, r, http.StatusMovedPermanently, "The resource has moved to "+location, headers)
+}
+
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
 	app.reportServerError(r, err)
 
@@ -44,6 +97,13 @@ func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
 	app.errorMessage(w, r, http.StatusNotFound, message, nil)
 }
 
+func (app *application) invalidAuthenticationToken(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("WWW-Authenticate", "Bearer")
+
+	app.errorMessage(w, r, http.StatusUnauthorized, "Invalid authentication token", headers)
+}
+
 func (app *application) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("The %s method is not supported for this resource", r.Method)
 	app.errorMessage(w, r, http.StatusMethodNotAllowed, message, nil)
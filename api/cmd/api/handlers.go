@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"net/http"
 	"net/url"
 
@@ -15,6 +16,14 @@ func (app *application) health(ctx context.Context, _ httprouter.Params, _ url.V
 	}, nil
 }
 
+// dbStats shows the connection pool, to the admin only.
+func (app *application) dbStats(ctx context.Context, _ httprouter.Params, _ url.Values) (sql.DBStats, error) {
+	if !contextIsAdmin(ctx) {
+		return sql.DBStats{}, errDebugAdminOnly
+	}
+	return app.db.Stats(), nil
+}
+
 func (app *application) docs() httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		httpSwagger.WrapHandler(w, r)
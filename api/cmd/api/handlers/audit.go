@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditRecord is one write to a user, post, comment, follow or reaction:
+// who made it, from where, and the row before and after. Before is null for
+// creates, and After for the rows that deletes remove for good. Follows are
+// recorded under the followed user, and reactions under the post. Records
+// are never changed once written.
+type AuditRecord struct {
+	Id         uuid.UUID       `json:"id" db:"id"`
+	Actor      string          `json:"actor" db:"actor"`
+	RequestId  string          `json:"request_id" db:"request_id"`
+	IP         string          `json:"ip" db:"ip"`
+	EntityType string          `json:"entity_type" db:"entity_type"`
+	EntityId   uuid.UUID       `json:"entity_id" db:"entity_id"`
+	Action     string          `json:"action" db:"action"`
+	Before     json.RawMessage `json:"before" db:"before_state" swaggertype:"object"`
+	After      json.RawMessage `json:"after" db:"after_state" swaggertype:"object"`
+	CreatedAt  time.Time       `json:"createdAt" db:"created_at"`
+}
+
+const AUDIT_FIELDS = "id, actor, request_id, ip, entity_type, entity_id, action, before_state, after_state, created_at"
+
+// Entity types and actions of audit records.
+const (
+	AuditUser     = "user"
+	AuditPost     = "post"
+	AuditComment  = "comment"
+	AuditFollow   = "follow"
+	AuditReaction = "reaction"
+
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+// AuditListSpec declares the filters and sorts of GET /api/audit.
+var AuditListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"entity_type":    {Field{"entity_type", StringField}, "="},
+		"entity_id":      {Field{"entity_id", UUIDField}, "="},
+		"action":         {Field{"action", StringField}, "="},
+		"actor":          {Field{"actor", StringField}, "="},
+		"created_after":  {Field{"created_at", TimeField}, ">"},
+		"created_before": {Field{"created_at", TimeField}, "<"},
+	},
+	Sorts: map[string]Field{
+		"created_at": {"created_at", TimeField},
+	},
+}
+
+// dest returns the scan destinations for AUDIT_FIELDS.
+func (a *AuditRecord) dest() []any {
+	return []any{&a.Id, &a.Actor, &a.RequestId, &a.IP, &a.EntityType, &a.EntityId, &a.Action, jsonColumn{&a.Before}, jsonColumn{&a.After}, &a.CreatedAt}
+}
+
+// jsonColumn scans a snapshot, which Postgres returns as bytes and SQLite
+// as text.
+type jsonColumn struct {
+	v *json.RawMessage
+}
+
+func (c jsonColumn) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*c.v = nil
+	case string:
+		*c.v = json.RawMessage(src)
+	case []byte:
+		*c.v = bytes.Clone(src)
+	default:
+		return fmt.Errorf("cannot scan %T into a JSON snapshot", src)
+	}
+	return nil
+}
+
+// field returns the value of a column, for list queries.
+func (a *AuditRecord) field(column string) any {
+	switch column {
+	case "id":
+		return a.Id
+	case "entity_type":
+		return a.EntityType
+	case "entity_id":
+		return a.EntityId
+	case "action":
+		return a.Action
+	case "actor":
+		return a.Actor
+	case "created_at":
+		return a.CreatedAt
+	}
+	panic("unknown audit field " + column)
+}
+
+// AuditRecordTx adds the records to the log, with multi-row inserts.
+func AuditRecordTx(tx *sql.Tx, records ...*AuditRecord) error {
+	for chunk := range slices.Chunk(records, insertChunkRows) {
+		args := make([]any, 0, 10*len(chunk))
+		for _, r := range chunk {
+			args = append(args, r.Id, r.Actor, r.RequestId, r.IP, r.EntityType, r.EntityId, r.Action, nullJSON(r.Before), nullJSON(r.After), r.CreatedAt)
+		}
+		s := fmt.Sprintf(`INSERT INTO audit_log (%s) VALUES %s`, AUDIT_FIELDS, valuesList(len(chunk), 10))
+		_, err := tx.Exec(s, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nullJSON binds a snapshot as text, or NULL when there is none.
+func nullJSON(b json.RawMessage) any {
+	if b == nil {
+		return nil
+	}
+	return string(b)
+}
+
+// AuditGetAllTx returns one page of audit records, filtered and sorted by q.
+func AuditGetAllTx(tx *sql.Tx, q *ListQuery) (*List[AuditRecord], error) {
+	where, args, err := q.where(1)
+	if err != nil {
+		return nil, err
+	}
+	s := fmt.Sprintf(`SELECT %s FROM audit_log WHERE %s ORDER BY %s LIMIT %d`, AUDIT_FIELDS, where, q.orderBy(), q.limit()+1)
+	rows, err := tx.Query(s, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*AuditRecord{}
+	for rows.Next() {
+		record := AuditRecord{}
+		err := rows.Scan(record.dest()...)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return newList(records, q, (*AuditRecord).field), nil
+}
+
+// Auditor is who is behind the writes of a request. Stores record every
+// write made in a transaction whose context carries one, see
+// ContextWithAuditor.
+type Auditor struct {
+	Actor     string
+	RequestId string
+	IP        string
+}
+
+type auditorContextKey struct{}
+
+func ContextWithAuditor(ctx context.Context, a Auditor) context.Context {
+	return context.WithValue(ctx, auditorContextKey{}, a)
+}
+
+func auditorFrom(ctx context.Context) (Auditor, bool) {
+	a, ok := ctx.Value(auditorContextKey{}).(Auditor)
+	return a, ok
+}
+
+// withAudit wraps the repositories that write so that their writes are
+// recorded in the audit log of the same transaction, when ctx has an
+// Auditor.
+func withAudit(ctx context.Context, r *Repositories) *Repositories {
+	a, ok := auditorFrom(ctx)
+	if !ok {
+		return r
+	}
+	log := auditLog{a, r.Audit}
+	audited := *r
+	audited.Users = auditedUsers{r.Users, log}
+	audited.Posts = auditedPosts{r.Posts, log}
+	audited.Comments = auditedComments{r.Comments, log}
+	audited.Follows = auditedFollows{r.Follows, log}
+	audited.Reactions = auditedReactions{r.Reactions, log}
+	return &audited
+}
+
+type auditLog struct {
+	auditor Auditor
+	audit   AuditRepository
+}
+
+// newRecord is the record of a write to a row. before is nil for creates,
+// and after for rows that are gone.
+func newRecord[T any](l auditLog, entityType string, id uuid.UUID, action string, before, after *T) (*AuditRecord, error) {
+	rec := &AuditRecord{
+		Id:         uuid.New(),
+		Actor:      l.auditor.Actor,
+		RequestId:  l.auditor.RequestId,
+		IP:         l.auditor.IP,
+		EntityType: entityType,
+		EntityId:   id,
+		Action:     action,
+		CreatedAt:  now(),
+	}
+	var err error
+	if before != nil {
+		rec.Before, err = json.Marshal(before)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		rec.After, err = json.Marshal(after)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
+
+// record adds the write of a row to the audit log. before is nil for
+// creates, and after for rows that are gone.
+func record[T any](l auditLog, entityType string, id uuid.UUID, action string, before, after *T) error {
+	rec, err := newRecord(l, entityType, id, action, before, after)
+	if err != nil {
+		return err
+	}
+	return l.audit.Record(rec)
+}
+
+// recordCreated adds the creation of many rows to the audit log, in one
+// go.
+func recordCreated[T any](l auditLog, entityType string, rows []*T, id func(*T) uuid.UUID) error {
+	records := make([]*AuditRecord, len(rows))
+	for i, row := range rows {
+		rec, err := newRecord(l, entityType, id(row), AuditCreate, nil, row)
+		if err != nil {
+			return err
+		}
+		records[i] = rec
+	}
+	return l.audit.Record(records...)
+}
+
+// auditedUsers records the writes of a UserRepository. Deleting and
+// restoring a user is one record, the posts that go with them aren't
+// recorded one by one.
+type auditedUsers struct {
+	UserRepository
+	log auditLog
+}
+
+func (r auditedUsers) Create(input *UserInput) (*User, error) {
+	u, err := r.UserRepository.Create(input)
+	if err != nil {
+		return nil, err
+	}
+	return u, record(r.log, AuditUser, u.Id, AuditCreate, nil, u)
+}
+
+func (r auditedUsers) CreateMany(inputs []*UserInput) ([]*User, error) {
+	users, err := r.UserRepository.CreateMany(inputs)
+	if err != nil {
+		return nil, err
+	}
+	return users, recordCreated(r.log, AuditUser, users, func(u *User) uuid.UUID { return u.Id })
+}
+
+func (r auditedUsers) Update(id uuid.UUID, input *UserInput) (*User, error) {
+	return r.write(id, AuditUpdate, func() (*User, error) { return r.UserRepository.Update(id, input) })
+}
+
+func (r auditedUsers) Delete(id uuid.UUID) (*User, error) {
+	return r.write(id, AuditDelete, func() (*User, error) { return r.UserRepository.Delete(id) })
+}
+
+func (r auditedUsers) Restore(id uuid.UUID) (*User, error) {
+	return r.write(id, AuditRestore, func() (*User, error) { return r.UserRepository.Restore(id) })
+}
+
+// write records fn with the user as it was before. Writes that find no user,
+// or leave the version as it was, changed nothing and aren't recorded.
+func (r auditedUsers) write(id uuid.UUID, action string, fn func() (*User, error)) (*User, error) {
+	before, err := r.UserRepository.GetWithDeleted(id)
+	if err != nil {
+		return nil, err
+	}
+	u, err := fn()
+	if err != nil || u == nil || (before != nil && before.Version == u.Version) {
+		return u, err
+	}
+	return u, record(r.log, AuditUser, id, action, before, u)
+}
+
+// auditedPosts records the writes of a PostRepository.
+type auditedPosts struct {
+	PostRepository
+	log auditLog
+}
+
+func (r auditedPosts) Create(input *PostInput) (*Post, error) {
+	p, err := r.PostRepository.Create(input)
+	if err != nil {
+		return nil, err
+	}
+	return p, record(r.log, AuditPost, p.Id, AuditCreate, nil, p)
+}
+
+func (r auditedPosts) CreateMany(inputs []*PostInput) ([]*Post, error) {
+	posts, err := r.PostRepository.CreateMany(inputs)
+	if err != nil {
+		return nil, err
+	}
+	return posts, recordCreated(r.log, AuditPost, posts, func(p *Post) uuid.UUID { return p.Id })
+}
+
+func (r auditedPosts) Update(id uuid.UUID, input *PostInput) (*Post, error) {
+	return r.write(id, AuditUpdate, func() (*Post, error) { return r.PostRepository.Update(id, input) })
+}
+
+func (r auditedPosts) Delete(id uuid.UUID) (*Post, error) {
+	return r.write(id, AuditDelete, func() (*Post, error) { return r.PostRepository.Delete(id) })
+}
+
+func (r auditedPosts) Restore(id uuid.UUID) (*Post, error) {
+	return r.write(id, AuditRestore, func() (*Post, error) { return r.PostRepository.Restore(id) })
+}
+
+// write records fn with the post as it was before. Writes that find no post,
+// or leave the version as it was, changed nothing and aren't recorded.
+func (r auditedPosts) write(id uuid.UUID, action string, fn func() (*Post, error)) (*Post, error) {
+	before, err := r.PostRepository.GetWithDeleted(id)
+	if err != nil {
+		return nil, err
+	}
+	p, err := fn()
+	if err != nil || p == nil || (before != nil && before.Version == p.Version) {
+		return p, err
+	}
+	return p, record(r.log, AuditPost, id, action, before, p)
+}
+
+// auditedComments records the writes of a CommentRepository. Deleting a
+// comment is one record, its replies that go with it aren't recorded one by
+// one.
+type auditedComments struct {
+	CommentRepository
+	log auditLog
+}
+
+func (r auditedComments) Create(postId uuid.UUID, input *CommentInput) (*Comment, error) {
+	c, err := r.CommentRepository.Create(postId, input)
+	if err != nil {
+		return nil, err
+	}
+	return c, record(r.log, AuditComment, c.Id, AuditCreate, nil, c)
+}
+
+func (r auditedComments) Update(id uuid.UUID, input *CommentInput) (*Comment, error) {
+	before, err := r.CommentRepository.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	c, err := r.CommentRepository.Update(id, input)
+	if err != nil || c == nil || (before != nil && before.Version == c.Version) {
+		return c, err
+	}
+	return c, record(r.log, AuditComment, id, AuditUpdate, before, c)
+}
+
+func (r auditedComments) Delete(id uuid.UUID) (*Comment, error) {
+	c, err := r.CommentRepository.Delete(id)
+	if err != nil || c == nil {
+		return c, err
+	}
+	return c, record[Comment](r.log, AuditComment, id, AuditDelete, c, nil)
+}
+
+// auditedFollows records the writes of a FollowRepository. Following again
+// and unfollowing a user that isn't followed change nothing and aren't
+// recorded.
+type auditedFollows struct {
+	FollowRepository
+	log auditLog
+}
+
+func (r auditedFollows) Create(followeeId uuid.UUID, input *FollowInput) (*Follow, error) {
+	before, err := r.FollowRepository.Get(input.FollowerId, followeeId)
+	if err != nil {
+		return nil, err
+	}
+	f, err := r.FollowRepository.Create(followeeId, input)
+	if err != nil || before != nil {
+		return f, err
+	}
+	return f, record(r.log, AuditFollow, followeeId, AuditCreate, nil, f)
+}
+
+func (r auditedFollows) Delete(followerId, followeeId uuid.UUID) (*Follow, error) {
+	f, err := r.FollowRepository.Delete(followerId, followeeId)
+	if err != nil || f == nil {
+		return f, err
+	}
+	return f, record[Follow](r.log, AuditFollow, followeeId, AuditDelete, f, nil)
+}
+
+// auditedReactions records the writes of a ReactionRepository. Reacting
+// again and removing a reaction there isn't change nothing and aren't
+// recorded.
+type auditedReactions struct {
+	ReactionRepository
+	log auditLog
+}
+
+func (r auditedReactions) Create(postId, userId uuid.UUID, kind string) (*Reaction, error) {
+	post := &Post{Id: postId}
+	err := r.ReactionRepository.LoadReacted(userId, post)
+	if err != nil {
+		return nil, err
+	}
+	reaction, err := r.ReactionRepository.Create(postId, userId, kind)
+	if err != nil || slices.Contains(post.Reacted, kind) {
+		return reaction, err
+	}
+	return reaction, record(r.log, AuditReaction, postId, AuditCreate, nil, reaction)
+}
+
+func (r auditedReactions) Delete(postId, userId uuid.UUID, kind string) (*Reaction, error) {
+	reaction, err := r.ReactionRepository.Delete(postId, userId, kind)
+	if err != nil || reaction == nil {
+		return reaction, err
+	}
+	return reaction, record[Reaction](r.log, AuditReaction, postId, AuditDelete, reaction, nil)
+}
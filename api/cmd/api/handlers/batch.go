@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"api/internal/validator"
+)
+
+var (
+	errBatchMissingItem = errors.New("missing item")
+	errBatchNotCreated  = errors.New("not created, another item failed")
+)
+
+// BatchItem is the outcome of the item at Index in a batch: 201 with the
+// created row, or the status and errors of a single create.
+type BatchItem[T any] struct {
+	Index       int               `json:"index"`
+	Status      int               `json:"status"`
+	Data        *T                `json:"data,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	FieldErrors map[string]string `json:"field_errors,omitempty"`
+}
+
+// BatchResult is the outcome of every item of a batch, in request order.
+type BatchResult[T any] struct {
+	Atomic  bool           `json:"atomic"`
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Items   []BatchItem[T] `json:"items"`
+}
+
+// StatusCode is the status of the whole batch, for handleMutation: 200
+// when every item was created and 207 when only some were. An atomic batch
+// that failed answers with the status of its first failed item.
+func (b *BatchResult[T]) StatusCode() int {
+	if b.Failed == 0 {
+		return http.StatusOK
+	}
+	if !b.Atomic {
+		return http.StatusMultiStatus
+	}
+	for _, item := range b.Items {
+		if item.Status != http.StatusFailedDependency {
+			return item.Status
+		}
+	}
+	return http.StatusUnprocessableEntity
+}
+
+func (b *BatchResult[T]) created(i int, row *T) {
+	b.Items[i].Status = http.StatusCreated
+	b.Items[i].Data = row
+	b.Created++
+}
+
+func (b *BatchResult[T]) fail(i int, err *HTTPError) {
+	b.Items[i].Status = err.Code
+	b.Items[i].Error = err.Message.Error()
+	b.Items[i].FieldErrors = err.FieldErrors
+	b.Failed++
+}
+
+// skip fails the items of an atomic batch that were fine, but not created
+// because others weren't.
+func (b *BatchResult[T]) skip(indexes []int) {
+	for _, i := range indexes {
+		b.fail(i, NewHTTPError(http.StatusFailedDependency, errBatchNotCreated))
+	}
+}
+
+// Batch creates many rows of one kind. CreateMany writes the valid inputs
+// in bulk. Create writes one, it is only used to find the items the schema
+// refuses when a bulk write fails.
+type Batch[In, T any] struct {
+	Validate   func(input *In) validator.Validator
+	CreateMany func(r *Repositories, inputs []*In) ([]*T, error)
+	Create     func(r *Repositories, input *In) (*T, error)
+}
+
+// UserBatch creates users for POST /api/users/batch.
+var UserBatch = Batch[UserInput, User]{
+	Validate:   (*UserInput).Validate,
+	CreateMany: func(r *Repositories, inputs []*UserInput) ([]*User, error) { return r.Users.CreateMany(inputs) },
+	Create:     func(r *Repositories, input *UserInput) (*User, error) { return r.Users.Create(input) },
+}
+
+// PostBatch creates posts for POST /api/posts/batch.
+var PostBatch = Batch[PostInput, Post]{
+	Validate:   (*PostInput).Validate,
+	CreateMany: func(r *Repositories, inputs []*PostInput) ([]*Post, error) { return r.Posts.CreateMany(inputs) },
+	Create:     func(r *Repositories, input *PostInput) (*Post, error) { return r.Posts.Create(input) },
+}
+
+// Run creates the inputs in store. Atomic batches are all or nothing, a
+// single item failing and none is created. Otherwise every item that can
+// be is created. Items the schema refuses fail with the status
+// TranslateError gives them, any other error fails the whole batch.
+func (b Batch[In, T]) Run(ctx context.Context, store Store, inputs []*In, atomic bool) (*BatchResult[T], error) {
+	res := &BatchResult[T]{Atomic: atomic, Items: make([]BatchItem[T], len(inputs))}
+	valid := []int{}
+	for i, input := range inputs {
+		res.Items[i].Index = i
+		if input == nil {
+			res.fail(i, NewHTTPError(http.StatusBadRequest, errBatchMissingItem))
+			continue
+		}
+		if v := b.Validate(input); v.HasErrors() {
+			res.fail(i, NewUnprocessableError(v))
+			continue
+		}
+		valid = append(valid, i)
+	}
+	if atomic && res.Failed > 0 {
+		res.skip(valid)
+		return res, nil
+	}
+	if len(valid) == 0 {
+		return res, nil
+	}
+
+	var rows []*T
+	err := store.BeginTx(ctx, &sql.TxOptions{}, func(r *Repositories) error {
+		validInputs := make([]*In, len(valid))
+		for j, i := range valid {
+			validInputs[j] = inputs[i]
+		}
+		created, err := b.CreateMany(r, validInputs)
+		if err != nil {
+			return err
+		}
+		rows = created
+		return nil
+	})
+	if _, ok := AsConstraintError(err); ok {
+		// The bulk insert doesn't tell which item was refused, so the items
+		// are tried one by one to find out.
+		if atomic {
+			return b.runOneByOne(ctx, store, inputs, valid, res)
+		}
+		return b.runEach(ctx, store, inputs, valid, res)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range valid {
+		res.created(i, rows[j])
+	}
+	return res, nil
+}
+
+// runOneByOne creates the items of an atomic batch in one transaction,
+// stopping at the first one that fails.
+func (b Batch[In, T]) runOneByOne(ctx context.Context, store Store, inputs []*In, valid []int, res *BatchResult[T]) (*BatchResult[T], error) {
+	var rows []*T
+	failed := -1
+	err := store.BeginTx(ctx, &sql.TxOptions{}, func(r *Repositories) error {
+		rows = make([]*T, len(valid))
+		failed = -1
+		for j, i := range valid {
+			row, err := b.Create(r, inputs[i])
+			if err != nil {
+				if _, ok := AsConstraintError(err); ok {
+					failed = i
+				}
+				return err
+			}
+			rows[j] = row
+		}
+		return nil
+	})
+	if failed >= 0 {
+		res.fail(failed, TranslateError(err).(*HTTPError))
+		for _, i := range valid {
+			if i != failed {
+				res.skip([]int{i})
+			}
+		}
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range valid {
+		res.created(i, rows[j])
+	}
+	return res, nil
+}
+
+// runEach creates every item in a transaction of its own.
+func (b Batch[In, T]) runEach(ctx context.Context, store Store, inputs []*In, valid []int, res *BatchResult[T]) (*BatchResult[T], error) {
+	for _, i := range valid {
+		var row *T
+		err := store.BeginTx(ctx, &sql.TxOptions{}, func(r *Repositories) error {
+			created, err := b.Create(r, inputs[i])
+			if err != nil {
+				return err
+			}
+			row = created
+			return nil
+		})
+		if _, ok := AsConstraintError(err); ok {
+			res.fail(i, TranslateError(err).(*HTTPError))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.created(i, row)
+	}
+	return res, nil
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"api/cmd/api/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryBatch(t *testing.T) {
+	f := utils.Fixture{
+		UserId1: uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac120001"),
+		UserId2: uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac120002"),
+		PostId1: uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac220001"),
+		PostId2: uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac220002"),
+	}
+	testBatch(t, f, func() (Store, error) {
+		return newMemoryTestStore(f), nil
+	})
+}
+
+func TestSQLBatch(t *testing.T) {
+	db := utils.TestNewDB(t)
+	testBatch(t, db.Fixture, func() (Store, error) {
+		_, err := db.Open()
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLStore(&db), nil
+	})
+}
+
+func testBatch(t *testing.T, f utils.Fixture, newStore func() (Store, error)) {
+	missing := uuid.MustParse("4a2b9c00-9daf-11ed-93ce-0242ac120001")
+
+	tests := []struct {
+		description    string
+		users          []*UserInput
+		posts          []*PostInput
+		atomic         bool
+		expectStatus   int
+		expectStatuses []int
+		expectCreated  int
+	}{
+		{
+			description:    "Valid users are all created",
+			users:          []*UserInput{{"a", "a@example.com"}, {"b", "b@example.com"}, {"c", "c@example.com"}},
+			atomic:         true,
+			expectStatus:   http.StatusOK,
+			expectStatuses: []int{201, 201, 201},
+			expectCreated:  3,
+		},
+		{
+			description:    "An invalid user fails an atomic batch",
+			users:          []*UserInput{{"a", "a@example.com"}, {"", "b@example.com"}, nil},
+			atomic:         true,
+			expectStatus:   http.StatusUnprocessableEntity,
+			expectStatuses: []int{424, 422, 400},
+		},
+		{
+			description:    "Repeated emails fail an atomic batch at the repeat",
+			users:          []*UserInput{{"a", "a@example.com"}, {"b", "b@example.com"}, {"c", "A@example.com"}},
+			atomic:         true,
+			expectStatus:   http.StatusConflict,
+			expectStatuses: []int{424, 424, 409},
+		},
+		{
+			description:    "Repeated emails only fail their item",
+			users:          []*UserInput{{"a", "a@example.com"}, {"", "b@example.com"}, {"c", "A@example.com"}, {"d", "d@example.com"}},
+			expectStatus:   http.StatusMultiStatus,
+			expectStatuses: []int{201, 422, 409, 201},
+			expectCreated:  2,
+		},
+		{
+			description:    "Valid posts are all created",
+			posts:          []*PostInput{{"one", "1", f.UserId1, nil, "", nil, ""}, {"two", "2", f.UserId2, nil, "", nil, ""}},
+			expectStatus:   http.StatusOK,
+			expectStatuses: []int{201, 201},
+			expectCreated:  2,
+		},
+		{
+			description:    "Posts of missing users only fail their item",
+			posts:          []*PostInput{{"one", "1", f.UserId1, nil, "", nil, ""}, {"two", "2", missing, nil, "", nil, ""}},
+			expectStatus:   http.StatusMultiStatus,
+			expectStatuses: []int{201, 422},
+			expectCreated:  1,
+		},
+		{
+			description:    "Posts of missing users fail an atomic batch",
+			posts:          []*PostInput{{"one", "1", f.UserId1, nil, "", nil, ""}, {"two", "2", missing, nil, "", nil, ""}},
+			atomic:         true,
+			expectStatus:   http.StatusUnprocessableEntity,
+			expectStatuses: []int{424, 422},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			store, err := newStore()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ctx := context.Background()
+
+			var statuses []int
+			var status, created int
+			if tc.users != nil {
+				res, err := UserBatch.Run(ctx, store, tc.users, tc.atomic)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for i, item := range res.Items {
+					statuses = append(statuses, item.Status)
+					if item.Status == http.StatusCreated && item.Data.Name != tc.users[i].Name {
+						t.Errorf("Item %d is %q", i, item.Data.Name)
+					}
+				}
+				status, created = res.StatusCode(), res.Created
+			} else {
+				res, err := PostBatch.Run(ctx, store, tc.posts, tc.atomic)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for i, item := range res.Items {
+					statuses = append(statuses, item.Status)
+					if item.Status == http.StatusCreated && item.Data.Title != tc.posts[i].Title {
+						t.Errorf("Item %d is %q", i, item.Data.Title)
+					}
+				}
+				status, created = res.StatusCode(), res.Created
+			}
+			if status != tc.expectStatus || fmt.Sprint(statuses) != fmt.Sprint(tc.expectStatuses) || created != tc.expectCreated {
+				t.Errorf("Got %d %v, expected %d %v", status, statuses, tc.expectStatus, tc.expectStatuses)
+			}
+
+			// Only what was reported created is there, besides the fixture.
+			err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+				users, err := r.Users.GetAll(nil)
+				if err != nil {
+					return err
+				}
+				posts, err := r.Posts.GetAll(nil)
+				if err != nil {
+					return err
+				}
+				if n := len(users.Data) + len(posts.Data) - 4; n != tc.expectCreated {
+					return fmt.Errorf("%d rows created, expected %d", n, tc.expectCreated)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
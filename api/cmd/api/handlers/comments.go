@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"api/internal/validator"
+
+	"github.com/google/uuid"
+)
+
+// MaxCommentDepth is how deep replies nest. Top level comments are at depth
+// 0, replies to a comment at MaxCommentDepth are refused.
+const MaxCommentDepth = 10
+
+// Comment is a comment on a post, or a reply to another comment of the
+// same post. Replies is only filled in for threads, see CommentTree.
+type Comment struct {
+	Id        uuid.UUID  `json:"id" db:"id"`
+	PostId    uuid.UUID  `json:"post_id" db:"post_id"`
+	UserId    uuid.UUID  `json:"user_id" db:"user_id"`
+	ParentId  *uuid.UUID `json:"parent_id" db:"parent_id"`
+	RootId    *uuid.UUID `json:"-" db:"root_id"`
+	Depth     int        `json:"depth" db:"depth"`
+	Content   string     `json:"content" db:"content"`
+	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt *time.Time `json:"updatedAt" db:"updated_at"`
+	Version   int64      `json:"version" db:"version"`
+	Replies   []*Comment `json:"replies,omitempty"`
+}
+
+// CommentInput is a new comment. The post comes from the path. Updates only
+// change the content, the author and parent of a comment stay.
+type CommentInput struct {
+	Content  string     `json:"content" db:"content"`
+	UserId   uuid.UUID  `json:"user_id" db:"user_id"`
+	ParentId *uuid.UUID `json:"parent_id" db:"parent_id"`
+}
+
+// Validate checks the input before it is written.
+func (in *CommentInput) Validate() validator.Validator {
+	v := validator.Validator{}
+	v.CheckField(validator.NotBlank(in.Content), "content", "must not be blank")
+	v.CheckField(in.UserId != uuid.Nil, "user_id", "must be set")
+	return v
+}
+
+const COMMENT_FIELDS = "id, post_id, user_id, parent_id, root_id, depth, content, created_at, updated_at, version"
+
+// CommentListSpec declares the filters and sorts of
+// GET /api/posts/:id/comments, which sets post_id from the path. Comments
+// come oldest first unless sorted otherwise.
+var CommentListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"post_id": {Field{"post_id", UUIDField}, "="},
+		"user_id": {Field{"user_id", UUIDField}, "="},
+	},
+	Sorts: map[string]Field{
+		"created_at": {"created_at", TimeField},
+	},
+	DefaultSort: []SortKey{{Field{"created_at", TimeField}, false}},
+}
+
+// CommentDepthFilter keeps the comments at most its value deep, 0 for the
+// top level ones.
+var CommentDepthFilter = Filter{Field{"depth", IntField}, "<="}
+
+// commentVisible hides the comments of soft deleted posts and users, which
+// are only removed for good when those are purged.
+const commentVisible = `NOT EXISTS (SELECT 1 FROM posts WHERE posts.id = comments.post_id AND posts.deleted_at IS NOT NULL)
+	AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = comments.user_id AND users.deleted_at IS NOT NULL)`
+
+// commentDeleted is selected along with a written comment, to refuse
+// comments of soft deleted users and on soft deleted posts, which fk_user
+// and fk_post let through.
+const commentDeleted = `EXISTS (SELECT 1 FROM users WHERE users.id = comments.user_id AND users.deleted_at IS NOT NULL),
+	EXISTS (SELECT 1 FROM posts WHERE posts.id = comments.post_id AND posts.deleted_at IS NOT NULL)`
+
+// errParentMissing reports a parent that isn't a visible comment of the
+// post like fk_parent does a missing one.
+func errParentMissing(id uuid.UUID) error {
+	return &ConstraintError{Kind: ForeignKeyViolation, Field: "parent_id", Err: fmt.Errorf("comment %s is not on the post", id)}
+}
+
+// errPostDeleted reports a soft deleted post like fk_post does a missing
+// one.
+func errPostDeleted(id uuid.UUID) error {
+	return &ConstraintError{Kind: ForeignKeyViolation, Field: "post_id", Err: fmt.Errorf("post %s is deleted", id)}
+}
+
+// errTooDeep refuses replies below MaxCommentDepth.
+func errTooDeep() error {
+	return &ConstraintError{Kind: CheckViolation, Field: "parent_id", Err: fmt.Errorf("replies nest at most %d levels deep", MaxCommentDepth)}
+}
+
+// dest returns the scan destinations for COMMENT_FIELDS.
+func (c *Comment) dest() []any {
+	return []any{&c.Id, &c.PostId, &c.UserId, &c.ParentId, &c.RootId, &c.Depth, &c.Content, &c.CreatedAt, &c.UpdatedAt, &c.Version}
+}
+
+// reply places a reply under parent, which must be a comment of the same
+// post that isn't too deep to reply to.
+func (c *Comment) reply(postId uuid.UUID, parent *Comment) error {
+	if parent == nil || parent.PostId != postId {
+		return errParentMissing(*c.ParentId)
+	}
+	if parent.Depth >= MaxCommentDepth {
+		return errTooDeep()
+	}
+	c.Depth = parent.Depth + 1
+	c.RootId = parent.RootId
+	if c.RootId == nil {
+		c.RootId = &parent.Id
+	}
+	return nil
+}
+
+func CommentsGetTx(tx *sql.Tx, id uuid.UUID) (*Comment, error) {
+	comment := Comment{}
+	s := fmt.Sprintf(`SELECT %s FROM comments WHERE id=$1 AND %s`, COMMENT_FIELDS, commentVisible)
+	err := tx.QueryRow(s, id).Scan(comment.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &comment, err
+}
+
+// CommentsGetAllTx returns one page of comments, filtered and sorted by q,
+// without their replies.
+func CommentsGetAllTx(tx *sql.Tx, q *ListQuery) (*List[Comment], error) {
+	q = q.withDefaultSort(CommentListSpec)
+	where, args, err := q.where(1)
+	if err != nil {
+		return nil, err
+	}
+	s := fmt.Sprintf(`SELECT %s FROM comments WHERE %s AND %s ORDER BY %s LIMIT %d`, COMMENT_FIELDS, where, commentVisible, q.orderBy(), q.limit()+1)
+	rows, err := tx.Query(s, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, err
+	}
+	return newList(comments, q, (*Comment).field), nil
+}
+
+// CommentsRepliesTx returns the replies in the threads of the given top
+// level comments down to maxDepth, oldest first.
+func CommentsRepliesTx(tx *sql.Tx, rootIds []uuid.UUID, maxDepth int) ([]*Comment, error) {
+	if len(rootIds) == 0 || maxDepth < 1 {
+		return []*Comment{}, nil
+	}
+	args := []any{maxDepth}
+	placeholders := make([]string, len(rootIds))
+	for i, id := range rootIds {
+		args = append(args, id)
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+	s := fmt.Sprintf(`SELECT %s FROM comments WHERE root_id IN (%s) AND depth <= $1 AND %s ORDER BY created_at, id`, COMMENT_FIELDS, strings.Join(placeholders, ", "), commentVisible)
+	rows, err := tx.Query(s, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanComments(rows)
+}
+
+func scanComments(rows *sql.Rows) ([]*Comment, error) {
+	comments := []*Comment{}
+	for rows.Next() {
+		comment := Comment{}
+		err := rows.Scan(comment.dest()...)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, &comment)
+	}
+	return comments, rows.Err()
+}
+
+// CommentsCreateTx creates a comment on the post. A missing or deleted post
+// or user is a ConstraintError on post_id or user_id, and a parent that
+// isn't a comment of the post, or is too deep to reply to, one on
+// parent_id.
+func CommentsCreateTx(tx *sql.Tx, postId uuid.UUID, input *CommentInput) (*Comment, error) {
+	comment := &Comment{ParentId: input.ParentId}
+	if input.ParentId != nil {
+		parent, err := CommentsGetTx(tx, *input.ParentId)
+		if err != nil {
+			return nil, err
+		}
+		err = comment.reply(postId, parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var userDeleted, postDeleted bool
+	s := fmt.Sprintf(`INSERT INTO comments (id, post_id, user_id, parent_id, root_id, depth, content, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING %s, %s`, COMMENT_FIELDS, commentDeleted)
+	err := tx.QueryRow(s, uuid.New(), postId, input.UserId, comment.ParentId, comment.RootId, comment.Depth, input.Content, now()).Scan(append(comment.dest(), &userDeleted, &postDeleted)...)
+	if err != nil {
+		return nil, err
+	}
+	if userDeleted {
+		return nil, errUserDeleted(input.UserId)
+	}
+	if postDeleted {
+		return nil, errPostDeleted(postId)
+	}
+	return comment, nil
+}
+
+// CommentsUpdateTx changes the content of the comment.
+func CommentsUpdateTx(tx *sql.Tx, id uuid.UUID, input *CommentInput) (*Comment, error) {
+	comment := &Comment{}
+	s := fmt.Sprintf(`UPDATE comments SET content=$1, updated_at=$2, version=version+1 WHERE id=$3 AND %s RETURNING %s`, commentVisible, COMMENT_FIELDS)
+	err := tx.QueryRow(s, input.Content, now(), id).Scan(comment.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return comment, err
+}
+
+// CommentsDeleteTx deletes the comment, and with it its replies.
+func CommentsDeleteTx(tx *sql.Tx, id uuid.UUID) (*Comment, error) {
+	comment := &Comment{}
+	s := fmt.Sprintf(`DELETE FROM comments WHERE id=$1 AND %s RETURNING %s`, commentVisible, COMMENT_FIELDS)
+	err := tx.QueryRow(s, id).Scan(comment.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return comment, err
+}
+
+// CommentTree hangs the replies under the top level comments they belong
+// to, in order. Replies whose parent isn't there, because it is hidden or
+// too deep, are left out along with their own replies.
+func CommentTree(roots []*Comment, replies []*Comment) {
+	byId := map[uuid.UUID]*Comment{}
+	for _, c := range roots {
+		byId[c.Id] = c
+	}
+	// Parents are always shallower than their replies.
+	replies = slices.Clone(replies)
+	slices.SortStableFunc(replies, func(a, b *Comment) int { return a.Depth - b.Depth })
+	for _, c := range replies {
+		parent, ok := byId[*c.ParentId]
+		if !ok {
+			continue
+		}
+		parent.Replies = append(parent.Replies, c)
+		byId[c.Id] = c
+	}
+}
+
+// ETag identifies the version of the comment, for conditional requests.
+func (c *Comment) ETag() string {
+	return fmt.Sprintf(`"%d"`, c.Version)
+}
+
+// field returns the value of a column, for list queries.
+func (c *Comment) field(column string) any {
+	switch column {
+	case "id":
+		return c.Id
+	case "post_id":
+		return c.PostId
+	case "user_id":
+		return c.UserId
+	case "depth":
+		return c.Depth
+	case "created_at":
+		return c.CreatedAt
+	}
+	panic("unknown comment field " + column)
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"api/cmd/api/utils"
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCommentsCreateTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	id, _ := uuid.Parse("4a2b9c00-9daf-11ed-93ce-0242ac120001")
+	tests := []struct {
+		description      string
+		postId           uuid.UUID
+		input            CommentInput
+		reply            bool
+		expectedDepth    int
+		expectConstraint *ConstraintError
+	}{
+		{
+			description: "Comment on a post",
+			postId:      db.Fixture.PostId1,
+			input:       CommentInput{"comment-1", db.Fixture.UserId2, nil},
+		},
+		{
+			description:   "Reply to a comment",
+			postId:        db.Fixture.PostId1,
+			input:         CommentInput{"reply-1", db.Fixture.UserId2, nil},
+			reply:         true,
+			expectedDepth: 1,
+		},
+		{
+			description:      "Reply to a comment of another post, expect fail",
+			postId:           db.Fixture.PostId2,
+			input:            CommentInput{"reply-1", db.Fixture.UserId2, nil},
+			reply:            true,
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation},
+		},
+		{
+			description:      "Comment on non existing post, expect fail",
+			postId:           id,
+			input:            CommentInput{"comment-1", db.Fixture.UserId2, nil},
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation},
+		},
+		{
+			description:      "Comment by non existing user, expect fail",
+			postId:           db.Fixture.PostId1,
+			input:            CommentInput{"comment-1", id, nil},
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			_, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			ctx := context.Background()
+			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+				if tc.reply {
+					parent, err := CommentsCreateTx(tx, db.Fixture.PostId1, &CommentInput{"parent", db.Fixture.UserId1, nil})
+					if err != nil {
+						return err
+					}
+					tc.input.ParentId = &parent.Id
+				}
+				c, err := CommentsCreateTx(tx, tc.postId, &tc.input)
+				if err != nil {
+					return err
+				}
+				comment, err := CommentsGetTx(tx, c.Id)
+				if err != nil {
+					return err
+				}
+				if comment.Content != tc.input.Content {
+					return fmt.Errorf("Content mismatch")
+				}
+				if comment.UserId != tc.input.UserId {
+					return fmt.Errorf("UserId mismatch")
+				}
+				if comment.Depth != tc.expectedDepth {
+					return fmt.Errorf("Wrong depth:%d!=%d", comment.Depth, tc.expectedDepth)
+				}
+				return nil
+			})
+			if tc.expectConstraint != nil {
+				c, ok := AsConstraintError(err)
+				if !ok || c.Kind != tc.expectConstraint.Kind {
+					t.Errorf("Expected constraint %d, got %v", tc.expectConstraint.Kind, err)
+				}
+			} else if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCommentsDepthTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		var parentId *uuid.UUID
+		for depth := 0; depth <= MaxCommentDepth; depth++ {
+			c, err := CommentsCreateTx(tx, db.Fixture.PostId1, &CommentInput{fmt.Sprintf("depth-%d", depth), db.Fixture.UserId1, parentId})
+			if err != nil {
+				return err
+			}
+			parentId = &c.Id
+		}
+		_, err := CommentsCreateTx(tx, db.Fixture.PostId1, &CommentInput{"too deep", db.Fixture.UserId1, parentId})
+		if c, ok := AsConstraintError(err); !ok || c.Kind != CheckViolation {
+			return fmt.Errorf("Expected check violation, got %v", err)
+		}
+
+		q := &ListQuery{}
+		q.Where(CommentListSpec.Filters["post_id"], db.Fixture.PostId1)
+		q.Where(CommentDepthFilter, 0)
+		roots, err := CommentsGetAllTx(tx, q)
+		if err != nil {
+			return err
+		}
+		if len(roots.Data) != 1 {
+			return fmt.Errorf("Wrong len:%d!=1", len(roots.Data))
+		}
+		replies, err := CommentsRepliesTx(tx, []uuid.UUID{roots.Data[0].Id}, 3)
+		if err != nil {
+			return err
+		}
+		if len(replies) != 3 {
+			return fmt.Errorf("Wrong len:%d!=3", len(replies))
+		}
+
+		CommentTree(roots.Data, replies)
+		depth := 0
+		for c := roots.Data[0]; len(c.Replies) > 0; c = c.Replies[0] {
+			depth++
+		}
+		if depth != 3 {
+			return fmt.Errorf("Wrong tree depth:%d!=3", depth)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCommentsUpdateTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	id, _ := uuid.Parse("4a2b9c00-9daf-11ed-93ce-0242ac120001")
+	tests := []struct {
+		description string
+		missing     bool
+		expectError bool
+	}{
+		{
+			description: "Update 1 comment",
+		},
+		{
+			description: "Update non-existing comment",
+			missing:     true,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			_, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			ctx := context.Background()
+			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+				c, err := CommentsCreateTx(tx, db.Fixture.PostId1, &CommentInput{"comment-1", db.Fixture.UserId1, nil})
+				if err != nil {
+					return err
+				}
+				if tc.missing {
+					c.Id = id
+				}
+				u, err := CommentsUpdateTx(tx, c.Id, &CommentInput{Content: "comment-1-updated"})
+				if err != nil {
+					return err
+				}
+				if u == nil {
+					return fmt.Errorf("Comment not found")
+				}
+				if u.Content != "comment-1-updated" {
+					return fmt.Errorf("Content mismatch")
+				}
+				if u.UserId != db.Fixture.UserId1 {
+					return fmt.Errorf("UserId mismatch")
+				}
+				if u.Version != c.Version+1 {
+					return fmt.Errorf("Version not bumped")
+				}
+				return nil
+			})
+			if tc.expectError {
+				if err == nil {
+					t.Error(err)
+				}
+			} else {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCommentsDeleteTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		c, err := CommentsCreateTx(tx, db.Fixture.PostId1, &CommentInput{"comment-1", db.Fixture.UserId1, nil})
+		if err != nil {
+			return err
+		}
+		reply, err := CommentsCreateTx(tx, db.Fixture.PostId1, &CommentInput{"reply-1", db.Fixture.UserId2, &c.Id})
+		if err != nil {
+			return err
+		}
+
+		deleted, err := CommentsDeleteTx(tx, c.Id)
+		if err != nil {
+			return err
+		}
+		if deleted == nil {
+			return fmt.Errorf("Comment not found")
+		}
+		got, err := CommentsGetTx(tx, reply.Id)
+		if err != nil {
+			return err
+		}
+		if got != nil {
+			return fmt.Errorf("Reply should be deleted with its parent")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/russross/blackfriday/v2"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The formats of the content of a post. Plain content is text, with blank
+// lines between paragraphs.
+const (
+	ContentPlain    = "plain"
+	ContentMarkdown = "markdown"
+)
+
+var ContentFormats = []string{ContentPlain, ContentMarkdown}
+
+// The parts of the content of posts that responses carry, by the content
+// query parameter: the content as written, its HTML, or both, the default.
+const (
+	ContentViewRaw  = "raw"
+	ContentViewHTML = "html"
+	ContentViewBoth = "both"
+)
+
+var ContentViews = []string{ContentViewRaw, ContentViewHTML, ContentViewBoth}
+
+// RenderContent renders content written in format as HTML that is safe to
+// embed in a page. Markdown may hold HTML of its own, so its rendering goes
+// through SanitizeHTML.
+func RenderContent(format, content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if format == ContentMarkdown {
+		return SanitizeHTML(string(blackfriday.Run([]byte(content))))
+	}
+	return renderPlain(content)
+}
+
+var blankLines = regexp.MustCompile(`\n[ \t]*\n`)
+
+// renderPlain escapes plain content, making paragraphs of what blank lines
+// separate and line breaks of the other newlines.
+func renderPlain(content string) string {
+	var b strings.Builder
+	for _, p := range blankLines.Split(content, -1) {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(html.EscapeString(p), "\n", "<br>\n"))
+		b.WriteString("</p>\n")
+	}
+	return b.String()
+}
+
+// allowedElements are the elements SanitizeHTML keeps, with the attributes
+// each of them may keep. The tags of other elements are dropped, and their
+// text is kept unless they are in droppedElements.
+var allowedElements = map[atom.Atom][]string{
+	atom.P:          nil,
+	atom.Br:         nil,
+	atom.Hr:         nil,
+	atom.H1:         nil,
+	atom.H2:         nil,
+	atom.H3:         nil,
+	atom.H4:         nil,
+	atom.H5:         nil,
+	atom.H6:         nil,
+	atom.Blockquote: nil,
+	atom.Pre:        nil,
+	atom.Code:       {"class"},
+	atom.Em:         nil,
+	atom.Strong:     nil,
+	atom.B:          nil,
+	atom.I:          nil,
+	atom.Del:        nil,
+	atom.Sup:        nil,
+	atom.Sub:        nil,
+	atom.Ul:         nil,
+	atom.Ol:         {"start"},
+	atom.Li:         nil,
+	atom.Dl:         nil,
+	atom.Dt:         nil,
+	atom.Dd:         nil,
+	atom.A:          {"href", "title"},
+	atom.Img:        {"src", "alt", "title"},
+	atom.Table:      nil,
+	atom.Thead:      nil,
+	atom.Tbody:      nil,
+	atom.Tr:         nil,
+	atom.Th:         {"align"},
+	atom.Td:         {"align"},
+}
+
+// droppedElements are dropped along with everything in them.
+var droppedElements = []atom.Atom{
+	atom.Script, atom.Style, atom.Iframe, atom.Object, atom.Embed, atom.Noscript, atom.Noembed, atom.Noframes,
+	atom.Template, atom.Textarea, atom.Title, atom.Xmp, atom.Plaintext, atom.Svg, atom.Math, atom.Select,
+}
+
+// voidElements have no end tag.
+var voidElements = []atom.Atom{atom.Br, atom.Hr, atom.Img}
+
+var (
+	// codeClass is the class of fenced code blocks, which name their
+	// language.
+	codeClass = regexp.MustCompile(`^language-[A-Za-z0-9_+-]+$`)
+	listStart = regexp.MustCompile(`^[0-9]{1,9}$`)
+)
+
+// SanitizeHTML keeps the elements and attributes of allowedElements and
+// drops the rest: scripts, styles, event handlers, and links and images
+// other than http, https and mailto ones, javascript: URLs among them.
+// Elements are closed in order, so that nothing leaks out of them.
+func SanitizeHTML(s string) string {
+	var b strings.Builder
+	open := []atom.Atom{}
+	z := nethtml.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == nethtml.ErrorToken {
+			break
+		}
+		t := z.Token()
+		switch tt {
+		case nethtml.TextToken:
+			b.WriteString(html.EscapeString(t.Data))
+
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			if slices.Contains(droppedElements, t.DataAtom) {
+				if tt == nethtml.StartTagToken {
+					skipElement(z, t.DataAtom)
+				}
+				continue
+			}
+			attrs, ok := allowedElements[t.DataAtom]
+			if !ok {
+				continue
+			}
+			b.WriteString("<" + t.DataAtom.String())
+			for _, a := range t.Attr {
+				if a.Namespace != "" || !slices.Contains(attrs, a.Key) || !safeAttr(a.Key, a.Val) {
+					continue
+				}
+				b.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+			}
+			b.WriteString(">")
+			if !slices.Contains(voidElements, t.DataAtom) {
+				open = append(open, t.DataAtom)
+			}
+
+		case nethtml.EndTagToken:
+			i := slices.Index(open, t.DataAtom)
+			if i < 0 {
+				continue
+			}
+			for len(open) > i {
+				b.WriteString("</" + open[len(open)-1].String() + ">")
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i].String() + ">")
+	}
+	return b.String()
+}
+
+// skipElement reads past the end of an element whose start tag was just
+// read, with whatever is in it.
+func skipElement(z *nethtml.Tokenizer, a atom.Atom) {
+	depth := 1
+	for depth > 0 {
+		switch z.Next() {
+		case nethtml.ErrorToken:
+			return
+		case nethtml.StartTagToken:
+			if name, _ := z.TagName(); atom.Lookup(name) == a {
+				depth++
+			}
+		case nethtml.EndTagToken:
+			if name, _ := z.TagName(); atom.Lookup(name) == a {
+				depth--
+			}
+		}
+	}
+}
+
+// safeAttr checks the values of the attributes that browsers act on.
+func safeAttr(key, val string) bool {
+	switch key {
+	case "href", "src":
+		u, err := url.Parse(strings.TrimSpace(val))
+		return err == nil && slices.Contains([]string{"", "http", "https", "mailto"}, u.Scheme)
+	case "class":
+		return codeClass.MatchString(val)
+	case "align":
+		return slices.Contains([]string{"left", "center", "right"}, val)
+	case "start":
+		return listStart.MatchString(val)
+	}
+	return true
+}
+
+// setContentHTML sets the HTML of the content of the post to the one its
+// last revision keeps, or renders it when there is none.
+func (p *Post) setContentHTML(html *string) {
+	if html == nil {
+		rendered := RenderContent(p.ContentFormat, p.Content)
+		html = &rendered
+	}
+	p.ContentHTML = html
+}
+
+// postsLoadContentHTMLTx fills in the HTML of the content of the posts,
+// from their last revisions.
+func postsLoadContentHTMLTx(tx *sql.Tx, posts ...*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	byId := make(map[uuid.UUID]*Post, len(posts))
+	args := make([]any, len(posts))
+	placeholders := make([]string, len(posts))
+	for i, p := range posts {
+		p.ContentHTML = nil
+		byId[p.Id] = p
+		args[i] = p.Id
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	s := fmt.Sprintf(`SELECT post_id, content_html FROM post_revisions
+		WHERE post_id IN (%s) AND rev = (SELECT MAX(rev) FROM post_revisions AS last WHERE last.post_id = post_revisions.post_id)`, strings.Join(placeholders, ", "))
+	rows, err := tx.Query(s, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postId uuid.UUID
+		var html *string
+		err := rows.Scan(&postId, &html)
+		if err != nil {
+			return err
+		}
+		byId[postId].ContentHTML = html
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, p := range posts {
+		if p.ContentHTML == nil {
+			p.setContentHTML(nil)
+		}
+	}
+	return nil
+}
+
+// ViewContent leaves out the content or its HTML when the response is to
+// have only one of them, see ContentViews.
+func (p *Post) ViewContent(view string) {
+	switch view {
+	case ContentViewRaw:
+		p.ContentHTML = nil
+	case ContentViewHTML:
+		p.Content = ""
+	}
+}
+
+// ViewContent applies the view to the rows of the page, when they are
+// posts.
+func (l *List[T]) ViewContent(view string) {
+	for _, row := range l.Data {
+		if v, ok := any(row).(interface{ ViewContent(string) }); ok {
+			v.ViewContent(view)
+		}
+	}
+}
+
+// ViewContent applies the view to the items of the batch, when they are
+// posts.
+func (r *BatchResult[T]) ViewContent(view string) {
+	for _, item := range r.Items {
+		if v, ok := any(item.Data).(interface{ ViewContent(string) }); ok && item.Data != nil {
+			v.ViewContent(view)
+		}
+	}
+}
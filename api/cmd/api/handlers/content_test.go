@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		description string
+		html        string
+		expected    string
+	}{
+		{
+			description: "Allowed elements are kept",
+			html:        `<p>Some <em>text</em> and <a href="https://example.com" title="t">a link</a></p>`,
+			expected:    `<p>Some <em>text</em> and <a href="https://example.com" title="t">a link</a></p>`,
+		},
+		{
+			description: "Scripts are dropped with their content",
+			html:        `<p>one</p><script>alert("<p>")</script><p>two</p>`,
+			expected:    `<p>one</p><p>two</p>`,
+		},
+		{
+			description: "Event handlers and style are dropped",
+			html:        `<p onclick="alert(1)" style="color:red">text</p><img src="a.png" onerror="alert(1)">`,
+			expected:    `<p>text</p><img src="a.png">`,
+		},
+		{
+			description: "javascript: URLs are dropped",
+			html:        `<a href="javascript:alert(1)">one</a><a href=" JavaScript:alert(1)">two</a><img src="data:image/png;base64,AA">`,
+			expected:    `<a>one</a><a>two</a><img>`,
+		},
+		{
+			description: "Unknown elements keep their text",
+			html:        `<div><span>text</span></div>`,
+			expected:    `text`,
+		},
+		{
+			description: "Unclosed elements are closed",
+			html:        `<blockquote><p><strong>text`,
+			expected:    `<blockquote><p><strong>text</strong></p></blockquote>`,
+		},
+		{
+			description: "Text is escaped",
+			html:        `<p>1 &lt; 2 &amp;&amp; "quoted"</p>`,
+			expected:    `<p>1 &lt; 2 &amp;&amp; &#34;quoted&#34;</p>`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if got := SanitizeHTML(tc.html); got != tc.expected {
+				t.Errorf("Got %q, expected %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRenderContent(t *testing.T) {
+	tests := []struct {
+		description string
+		format      string
+		content     string
+		expected    string
+	}{
+		{
+			description: "Plain content is escaped, in paragraphs",
+			format:      ContentPlain,
+			content:     "one <b>\r\ntwo\n\n\nthree",
+			expected:    "<p>one &lt;b&gt;<br>\ntwo</p>\n<p>three</p>\n",
+		},
+		{
+			description: "Markdown is rendered",
+			format:      ContentMarkdown,
+			content:     "## Title\n\nSome *text*.",
+			expected:    "<h2>Title</h2>\n\n<p>Some <em>text</em>.</p>\n",
+		},
+		{
+			description: "HTML in markdown is sanitized",
+			format:      ContentMarkdown,
+			content:     "<script>alert(1)</script>\n\n[link](javascript:alert%281%29) <img src=x onerror=alert(1)>",
+			expected:    "\n\n<p><a>link</a> <img src=\"x\"></p>\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if got := RenderContent(tc.format, tc.content); got != tc.expected {
+				t.Errorf("Got %q, expected %q", got, tc.expected)
+			}
+		})
+	}
+}
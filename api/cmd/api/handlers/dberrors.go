@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type ConstraintKind int
+
+const (
+	ForeignKeyViolation ConstraintKind = iota
+	UniqueViolation
+	CheckViolation
+	NotNullViolation
+	InvalidValue
+)
+
+// ConstraintError is a write that the schema refused, whichever store it
+// came from. Field is the column at fault, when the database tells.
+type ConstraintError struct {
+	Kind  ConstraintKind
+	Field string
+	Err   error
+}
+
+func (e *ConstraintError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+// Postgres error codes of constraint violations, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+var pqConstraintKinds = map[pq.ErrorCode]ConstraintKind{
+	"23503": ForeignKeyViolation,
+	"23505": UniqueViolation,
+	"23514": CheckViolation,
+	"23502": NotNullViolation,
+	"22P02": InvalidValue,
+}
+
+var sqliteConstraintKinds = map[int]ConstraintKind{
+	sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY: ForeignKeyViolation,
+	sqlite3.SQLITE_CONSTRAINT_UNIQUE:     UniqueViolation,
+	sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY: UniqueViolation,
+	sqlite3.SQLITE_CONSTRAINT_CHECK:      CheckViolation,
+	sqlite3.SQLITE_CONSTRAINT_NOTNULL:    NotNullViolation,
+}
+
+var (
+	// Key (user_id)=(...) is not present in table "users".
+	pqDetailKey = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+	// UNIQUE constraint failed: users.email
+	sqliteFailedColumn = regexp.MustCompile(`constraint failed: \w+\.(\w+)`)
+)
+
+// AsConstraintError finds the constraint violation in err, if there is one.
+func AsConstraintError(err error) (*ConstraintError, bool) {
+	var constraintErr *ConstraintError
+	if errors.As(err, &constraintErr) {
+		return constraintErr, true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		kind, ok := pqConstraintKinds[pqErr.Code]
+		if !ok {
+			return nil, false
+		}
+		field := pqErr.Column
+		if m := pqDetailKey.FindStringSubmatch(pqErr.Detail); field == "" && m != nil {
+			field = m[1]
+		}
+		return &ConstraintError{Kind: kind, Field: field, Err: err}, true
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		kind, ok := sqliteConstraintKinds[sqliteErr.Code()]
+		if !ok {
+			return nil, false
+		}
+		// SQLite doesn't name the column of foreign keys.
+		field := ""
+		if m := sqliteFailedColumn.FindStringSubmatch(sqliteErr.Error()); m != nil {
+			field = m[1]
+		}
+		return &ConstraintError{Kind: kind, Field: field, Err: err}, true
+	}
+
+	return nil, false
+}
+
+// TranslateError turns constraint violations into HTTPErrors for the
+// client: a 409 when the row already exists, a 400 for values the column
+// can't hold, and a 422 for anything else the schema refused. Other errors
+// are returned as they are.
+func TranslateError(err error) error {
+	constraintErr, ok := AsConstraintError(err)
+	if !ok {
+		return err
+	}
+
+	code, problem, message := http.StatusUnprocessableEntity, "is invalid", "a value is invalid"
+	switch constraintErr.Kind {
+	case ForeignKeyViolation:
+		problem, message = "does not exist", "a referenced row does not exist"
+	case UniqueViolation:
+		code, problem, message = http.StatusConflict, "is already taken", "the row already exists"
+	case NotNullViolation:
+		problem, message = "must be set", "a required value is missing"
+	case InvalidValue:
+		code = http.StatusBadRequest
+	}
+
+	if constraintErr.Field == "" {
+		return NewHTTPError(code, errors.New(message))
+	}
+	fields := strings.Split(constraintErr.Field, ", ")
+	fieldErrors := map[string]string{}
+	for _, f := range fields {
+		fieldErrors[f] = problem
+	}
+	return &HTTPError{
+		Code:        code,
+		Message:     fmt.Errorf("%s %s", strings.Join(fields, " and "), problem),
+		FieldErrors: fieldErrors,
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		description         string
+		err                 error
+		expectedCode        int
+		expectedFieldErrors map[string]string
+	}{
+		{
+			description:         "Postgres foreign key",
+			err:                 &pq.Error{Code: "23503", Detail: `Key (user_id)=(4a2b9c10-9daf-11ed-93ce-0242ac120001) is not present in table "users".`},
+			expectedCode:        http.StatusUnprocessableEntity,
+			expectedFieldErrors: map[string]string{"user_id": "does not exist"},
+		},
+		{
+			description:         "Postgres unique violation, wrapped",
+			err:                 fmt.Errorf("insert: %w", &pq.Error{Code: "23505", Detail: "Key (post_id, rev)=(x, 1) already exists."}),
+			expectedCode:        http.StatusConflict,
+			expectedFieldErrors: map[string]string{"post_id": "is already taken", "rev": "is already taken"},
+		},
+		{
+			description:         "Postgres not null",
+			err:                 &pq.Error{Code: "23502", Column: "title"},
+			expectedCode:        http.StatusUnprocessableEntity,
+			expectedFieldErrors: map[string]string{"title": "must be set"},
+		},
+		{
+			description:  "Postgres bad text representation",
+			err:          &pq.Error{Code: "22P02"},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			description:  "Postgres check violation",
+			err:          &pq.Error{Code: "23514"},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			description:         "Memory store foreign key",
+			err:                 &ConstraintError{Kind: ForeignKeyViolation, Field: "user_id", Err: ErrForeignKey},
+			expectedCode:        http.StatusUnprocessableEntity,
+			expectedFieldErrors: map[string]string{"user_id": "does not exist"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			var httpErr *HTTPError
+			if !errors.As(TranslateError(tc.err), &httpErr) {
+				t.Fatalf("Not translated")
+			}
+			if httpErr.Code != tc.expectedCode {
+				t.Fatalf("Wrong code: %d", httpErr.Code)
+			}
+			if fmt.Sprint(httpErr.FieldErrors) != fmt.Sprint(tc.expectedFieldErrors) {
+				t.Fatalf("Wrong field errors: %v", httpErr.FieldErrors)
+			}
+		})
+	}
+
+	t.Run("Other errors are kept", func(t *testing.T) {
+		err := errors.New("boom")
+		if TranslateError(err) != err {
+			t.Fatalf("Error was changed")
+		}
+		if TranslateError(&pq.Error{Code: "40001"}).Error() != (&pq.Error{Code: "40001"}).Error() {
+			t.Fatalf("Serialization failure was translated")
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Follow is a user following another one, whose posts then show up in
+// their feed.
+type Follow struct {
+	FollowerId uuid.UUID `json:"follower_id" db:"follower_id"`
+	FolloweeId uuid.UUID `json:"followee_id" db:"followee_id"`
+	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+}
+
+// FollowInput is who follows the user of the path, the viewer of the
+// request.
+type FollowInput struct {
+	FollowerId uuid.UUID `json:"follower_id" db:"follower_id"`
+}
+
+// FollowUser is a follower, or a followed user, with when the follow
+// started.
+type FollowUser struct {
+	User
+	FollowedAt time.Time `json:"followedAt" db:"followed_at"`
+}
+
+// FollowCounts counts the live followers of a user and the live users they
+// follow.
+type FollowCounts struct {
+	Followers int `json:"followers"`
+	Following int `json:"following"`
+}
+
+const FOLLOW_FIELDS = "follower_id, followee_id, created_at"
+
+// FollowListSpec declares the sorts of GET /api/users/:id/followers and
+// /following. Users come most recently followed first unless sorted
+// otherwise.
+var FollowListSpec = ListSpec{
+	Sorts: map[string]Field{
+		"followed_at": {"followed_at", TimeField},
+		"name":        {"name", StringField},
+	},
+	DefaultSort: []SortKey{{Field{"followed_at", TimeField}, true}},
+}
+
+// FeedListSpec declares the filters and sorts of GET /api/users/:id/feed,
+// the filters of posts but those on the author. The feed comes newest
+// first unless sorted otherwise.
+var FeedListSpec = ListSpec{
+	Filters: PostListSpec.Without("user_id").Filters,
+	Sorts: map[string]Field{
+		"created_at": {"created_at", TimeField},
+	},
+	DefaultSort: []SortKey{{Field{"created_at", TimeField}, true}},
+}
+
+// followDeleted is selected along with a written follow, to refuse follows
+// of and by soft deleted users, which fk_follower and fk_followee let
+// through.
+const followDeleted = `EXISTS (SELECT 1 FROM users WHERE users.id = follows.follower_id AND users.deleted_at IS NOT NULL),
+	EXISTS (SELECT 1 FROM users WHERE users.id = follows.followee_id AND users.deleted_at IS NOT NULL)`
+
+// errSelfFollow is follows_self_check, checked before the insert so that
+// both stores report it the same way.
+func errSelfFollow() error {
+	return &ConstraintError{Kind: CheckViolation, Field: "follower_id", Err: fmt.Errorf("users cannot follow themselves")}
+}
+
+// FollowsGetTx finds the follow of followeeId by followerId.
+func FollowsGetTx(tx *sql.Tx, followerId, followeeId uuid.UUID) (*Follow, error) {
+	follow := Follow{}
+	s := fmt.Sprintf(`SELECT %s FROM follows WHERE follower_id=$1 AND followee_id=$2`, FOLLOW_FIELDS)
+	err := tx.QueryRow(s, followerId, followeeId).Scan(&follow.FollowerId, &follow.FolloweeId, &follow.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &follow, err
+}
+
+// FollowsCreateTx makes input.FollowerId follow followeeId. Following a
+// user again returns the follow there is. A missing or deleted user is a
+// ConstraintError on follower_id or followee_id, and so is following
+// oneself.
+func FollowsCreateTx(tx *sql.Tx, followeeId uuid.UUID, input *FollowInput) (*Follow, error) {
+	if input.FollowerId == followeeId {
+		return nil, errSelfFollow()
+	}
+	_, err := tx.Exec(`INSERT INTO follows (follower_id, followee_id, created_at) VALUES ($1, $2, $3) ON CONFLICT (follower_id, followee_id) DO NOTHING`, input.FollowerId, followeeId, now())
+	if err != nil {
+		return nil, err
+	}
+
+	follow := &Follow{}
+	var followerDeleted, followeeDeleted bool
+	s := fmt.Sprintf(`SELECT %s, %s FROM follows WHERE follower_id=$1 AND followee_id=$2`, FOLLOW_FIELDS, followDeleted)
+	err = tx.QueryRow(s, input.FollowerId, followeeId).Scan(&follow.FollowerId, &follow.FolloweeId, &follow.CreatedAt, &followerDeleted, &followeeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	if followerDeleted {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "follower_id", Err: fmt.Errorf("user %s is deleted", input.FollowerId)}
+	}
+	if followeeDeleted {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "followee_id", Err: fmt.Errorf("user %s is deleted", followeeId)}
+	}
+	return follow, nil
+}
+
+// FollowsDeleteTx makes followerId stop following followeeId. It returns
+// nil when there was no such follow.
+func FollowsDeleteTx(tx *sql.Tx, followerId, followeeId uuid.UUID) (*Follow, error) {
+	follow := &Follow{}
+	s := fmt.Sprintf(`DELETE FROM follows WHERE follower_id=$1 AND followee_id=$2 RETURNING %s`, FOLLOW_FIELDS)
+	err := tx.QueryRow(s, followerId, followeeId).Scan(&follow.FollowerId, &follow.FolloweeId, &follow.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return follow, err
+}
+
+// FollowsFollowersTx returns one page of the live users following the
+// user, sorted by q.
+func FollowsFollowersTx(tx *sql.Tx, userId uuid.UUID, q *ListQuery) (*List[FollowUser], error) {
+	return followUsersTx(tx, "follower_id", "followee_id", userId, q)
+}
+
+// FollowsFollowingTx returns one page of the live users the user follows,
+// sorted by q.
+func FollowsFollowingTx(tx *sql.Tx, userId uuid.UUID, q *ListQuery) (*List[FollowUser], error) {
+	return followUsersTx(tx, "followee_id", "follower_id", userId, q)
+}
+
+// followUsersTx lists the users on the other side, of column, of the
+// follows of the user, on by.
+func followUsersTx(tx *sql.Tx, column, by string, userId uuid.UUID, q *ListQuery) (*List[FollowUser], error) {
+	q = q.withDefaultSort(FollowListSpec)
+	where, args, err := q.where(2)
+	if err != nil {
+		return nil, err
+	}
+	s := fmt.Sprintf(`SELECT %s, followed_at FROM (
+		SELECT users.*, follows.created_at AS followed_at FROM follows
+		JOIN users ON users.id = follows.%s
+		WHERE follows.%s=$1 AND users.deleted_at IS NULL
+	) AS follow_users WHERE %s ORDER BY %s LIMIT %d`, USER_FIELDS, column, by, where, q.orderBy(), q.limit()+1)
+	rows, err := tx.Query(s, append([]any{userId}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*FollowUser{}
+	for rows.Next() {
+		u := FollowUser{}
+		err := rows.Scan(append(u.dest(), &u.FollowedAt)...)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return newList(users, q, (*FollowUser).field), nil
+}
+
+// FollowsCountsTx counts the live followers of the user and the live users
+// they follow.
+func FollowsCountsTx(tx *sql.Tx, userId uuid.UUID) (*FollowCounts, error) {
+	counts := &FollowCounts{}
+	err := tx.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM follows JOIN users ON users.id = follows.follower_id WHERE follows.followee_id=$1 AND users.deleted_at IS NULL),
+		(SELECT COUNT(*) FROM follows JOIN users ON users.id = follows.followee_id WHERE follows.follower_id=$1 AND users.deleted_at IS NULL)`,
+		userId).Scan(&counts.Followers, &counts.Following)
+	return counts, err
+}
+
+// PostsFeedTx returns one page of the live posts of the users the user
+// follows, filtered and sorted by q.
+//
+// The authors are a semi-join on the primary key of follows rather than a
+// list of ids, so the query doesn't grow with the number of follows. For
+// users who follow few accounts, Postgres reads the newest posts of each
+// from posts_user_id_created_at_idx and merges them. For those who follow
+// thousands, it rather walks posts_created_at_idx newest first, probing
+// follows for each post, and stops as soon as the page is full. Either
+// way the keyset keeps later pages as cheap as the first.
+func PostsFeedTx(tx *sql.Tx, userId uuid.UUID, q *ListQuery) (*List[Post], error) {
+	q = q.withDefaultSort(FeedListSpec)
+	where, args, err := q.where(2)
+	if err != nil {
+		return nil, err
+	}
+	s := fmt.Sprintf(`SELECT %s FROM posts WHERE user_id IN (SELECT followee_id FROM follows WHERE follower_id=$1) AND deleted_at IS NULL AND %s ORDER BY %s LIMIT %d`, POST_FIELDS, where, q.orderBy(), q.limit()+1)
+	rows, err := tx.Query(s, append([]any{userId}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []*Post{}
+	for rows.Next() {
+		post := Post{}
+		err := rows.Scan(post.dest()...)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, &post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Postgres runs one query at a time on the connection of the tx.
+	rows.Close()
+	err = postsLoadTx(tx, posts...)
+	if err != nil {
+		return nil, err
+	}
+	return newList(posts, q, (*Post).field), nil
+}
+
+// field returns the value of a column, for list queries.
+func (u *FollowUser) field(column string) any {
+	if column == "followed_at" {
+		return u.FollowedAt
+	}
+	return u.User.field(column)
+}
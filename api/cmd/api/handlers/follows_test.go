@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"api/cmd/api/utils"
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFollowsCreateTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	id, _ := uuid.Parse("4a2b9c00-9daf-11ed-93ce-0242ac120001")
+	tests := []struct {
+		description      string
+		followerId       uuid.UUID
+		followeeId       uuid.UUID
+		twice            bool
+		expectConstraint *ConstraintError
+	}{
+		{
+			description: "Follow a user",
+			followerId:  db.Fixture.UserId1,
+			followeeId:  db.Fixture.UserId2,
+		},
+		{
+			description: "Follow a user twice",
+			followerId:  db.Fixture.UserId1,
+			followeeId:  db.Fixture.UserId2,
+			twice:       true,
+		},
+		{
+			description:      "Follow oneself, expect fail",
+			followerId:       db.Fixture.UserId1,
+			followeeId:       db.Fixture.UserId1,
+			expectConstraint: &ConstraintError{Kind: CheckViolation},
+		},
+		{
+			description:      "Follow by non existing user, expect fail",
+			followerId:       id,
+			followeeId:       db.Fixture.UserId2,
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			_, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			ctx := context.Background()
+			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+				f, err := FollowsCreateTx(tx, tc.followeeId, &FollowInput{tc.followerId})
+				if err != nil {
+					return err
+				}
+				if tc.twice {
+					f, err = FollowsCreateTx(tx, tc.followeeId, &FollowInput{tc.followerId})
+					if err != nil {
+						return err
+					}
+				}
+				follow, err := FollowsGetTx(tx, tc.followerId, tc.followeeId)
+				if err != nil {
+					return err
+				}
+				if follow == nil || !follow.CreatedAt.Equal(f.CreatedAt) {
+					return fmt.Errorf("Follow mismatch")
+				}
+				counts, err := FollowsCountsTx(tx, tc.followeeId)
+				if err != nil {
+					return err
+				}
+				if counts.Followers != 1 {
+					return fmt.Errorf("Wrong followers:%d!=1", counts.Followers)
+				}
+				return nil
+			})
+			if tc.expectConstraint != nil {
+				c, ok := AsConstraintError(err)
+				if !ok || c.Kind != tc.expectConstraint.Kind {
+					t.Errorf("Expected constraint %d, got %v", tc.expectConstraint.Kind, err)
+				}
+			} else if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFollowsDeleteTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		_, err := FollowsCreateTx(tx, db.Fixture.UserId2, &FollowInput{db.Fixture.UserId1})
+		if err != nil {
+			return err
+		}
+		deleted, err := FollowsDeleteTx(tx, db.Fixture.UserId1, db.Fixture.UserId2)
+		if err != nil {
+			return err
+		}
+		if deleted == nil {
+			return fmt.Errorf("Follow not found")
+		}
+		deleted, err = FollowsDeleteTx(tx, db.Fixture.UserId1, db.Fixture.UserId2)
+		if err != nil {
+			return err
+		}
+		if deleted != nil {
+			return fmt.Errorf("Follow should be gone")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostsFeedTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		_, err := FollowsCreateTx(tx, db.Fixture.UserId2, &FollowInput{db.Fixture.UserId1})
+		if err != nil {
+			return err
+		}
+		// The fixture post may well be newer than the ones created here.
+		_, err = PostsDeleteTx(tx, db.Fixture.PostId2)
+		if err != nil {
+			return err
+		}
+		var ids []uuid.UUID
+		for i := range 3 {
+			p, err := PostsCreateTx(tx, &PostInput{fmt.Sprintf("post-%d", i), "content", db.Fixture.UserId2, []string{"go"}, "", nil, ""}, nil)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, p.Id)
+		}
+
+		feed, err := PostsFeedTx(tx, db.Fixture.UserId1, &ListQuery{Limit: 2})
+		if err != nil {
+			return err
+		}
+		if len(feed.Data) != 2 || feed.Data[0].Id != ids[2] || feed.Data[1].Id != ids[1] {
+			return fmt.Errorf("Wrong first page: %+v", feed.Data)
+		}
+		if len(feed.Data[0].Tags) != 1 {
+			return fmt.Errorf("Tags not loaded: %q", feed.Data[0].Tags)
+		}
+		cursor, err := DecodeCursor(*feed.NextCursor)
+		if err != nil {
+			return err
+		}
+		feed, err = PostsFeedTx(tx, db.Fixture.UserId1, &ListQuery{Limit: 2, Cursor: cursor})
+		if err != nil {
+			return err
+		}
+		if len(feed.Data) != 1 || feed.Data[0].Id != ids[0] || feed.NextCursor != nil {
+			return fmt.Errorf("Wrong second page: %+v", feed.Data)
+		}
+
+		feed, err = PostsFeedTx(tx, db.Fixture.UserId2, nil)
+		if err != nil {
+			return err
+		}
+		if len(feed.Data) != 0 {
+			return fmt.Errorf("Feed without follows should be empty: %+v", feed.Data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -1,10 +1,20 @@
 package handlers
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"api/internal/validator"
+)
 
 type HTTPError struct {
 	Code    int
 	Message error
+	// FieldErrors lists what is wrong with each request parameter, if any.
+	FieldErrors map[string]string
 }
 
 func (e *HTTPError) Error() string {
@@ -17,3 +27,48 @@ func NewHTTPError(code int, err error) *HTTPError {
 		Message: err,
 	}
 }
+
+// NewValidationError is a 400 listing the field errors collected in v.
+func NewValidationError(v validator.Validator) *HTTPError {
+	return &HTTPError{
+		Code:        http.StatusBadRequest,
+		Message:     errors.New("invalid request parameters"),
+		FieldErrors: v.FieldErrors,
+	}
+}
+
+// NewUnprocessableError is a 422 listing the field errors collected in v,
+// for documents that are well formed but invalid, such as patch results.
+func NewUnprocessableError(v validator.Validator) *HTTPError {
+	return &HTTPError{
+		Code:        http.StatusUnprocessableEntity,
+		Message:     errors.New("invalid fields"),
+		FieldErrors: v.FieldErrors,
+	}
+}
+
+// now is used for every timestamp the Tx functions write, instead of the
+// database clock, so that Postgres and SQLite store the same values. It
+// matches the precision of Postgres timestamps and is always UTC, which
+// SQLite needs to compare its text timestamps in time order.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// insertChunkRows is how many rows a multi-row insert writes at most, which
+// keeps its arguments well under what Postgres and SQLite allow.
+const insertChunkRows = 500
+
+// valuesList returns the VALUES of a multi-row insert of rows rows of cols
+// columns each, numbering the placeholders from 1: ($1, $2), ($3, $4).
+func valuesList(rows, cols int) string {
+	tuples := make([]string, rows)
+	placeholders := make([]string, cols)
+	for i := range rows {
+		for j := range cols {
+			placeholders[j] = fmt.Sprintf("$%d", i*cols+j+1)
+		}
+		tuples[i] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+	return strings.Join(tuples, ", ")
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// IdempotencyKey is a request made with an Idempotency-Key header. Once it
+// is done it holds the response, which retries with the same key get
+// instead of running the request again. Status is 0 while the request is
+// in flight. The request holds the key until LockedUntil, after which a
+// retry can claim it, unless the request committed a write.
+type IdempotencyKey struct {
+	Key         string
+	RequestHash string
+	Status      int
+	Headers     http.Header
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	LockedUntil time.Time
+	CommittedAt *time.Time
+}
+
+const IDEMPOTENCY_KEY_FIELDS = "id, request_hash, status, headers, body, created_at, expires_at, locked_until, committed_at"
+
+// ErrIdempotencyLeaseLost is the error of the writes of a request whose key
+// was claimed by a retry since, after its lease ran out.
+var ErrIdempotencyLeaseLost = errors.New("the idempotency key was claimed by another request")
+
+// InFlight reports whether the request of the key hasn't finished yet and
+// still holds the key: its lease hasn't run out, or it committed a write
+// and must not run again.
+func (k *IdempotencyKey) InFlight() bool {
+	return k.Status == 0 && (k.CommittedAt != nil || k.LockedUntil.After(now()))
+}
+
+// Claimable reports whether the request of the key died before it wrote
+// anything, so that a retry can claim the key and run it again.
+func (k *IdempotencyKey) Claimable() bool {
+	return k.Status == 0 && !k.InFlight()
+}
+
+// scanIdempotencyKey scans a row of IDEMPOTENCY_KEY_FIELDS.
+func scanIdempotencyKey(row *sql.Row) (*IdempotencyKey, error) {
+	k := IdempotencyKey{}
+	var headers json.RawMessage
+	err := row.Scan(&k.Key, &k.RequestHash, &k.Status, jsonColumn{&headers}, &k.Body, &k.CreatedAt, &k.ExpiresAt, &k.LockedUntil, &k.CommittedAt)
+	if err != nil {
+		return nil, err
+	}
+	if headers != nil {
+		err = json.Unmarshal(headers, &k.Headers)
+	}
+	return &k, err
+}
+
+// IdempotencyKeysGetTx finds a key, expired or not.
+func IdempotencyKeysGetTx(tx *sql.Tx, key string) (*IdempotencyKey, error) {
+	k, err := scanIdempotencyKey(tx.QueryRow(`SELECT `+IDEMPOTENCY_KEY_FIELDS+` FROM idempotency_keys WHERE id=$1`, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+// IdempotencyKeysCreateTx saves the key of a request that is starting,
+// held until lockedUntil. A key that is already there is a
+// UniqueViolation.
+func IdempotencyKeysCreateTx(tx *sql.Tx, key, requestHash string, lockedUntil, expiresAt time.Time) (*IdempotencyKey, error) {
+	s := `INSERT INTO idempotency_keys (id, request_hash, created_at, expires_at, locked_until) VALUES ($1, $2, $3, $4, $5) RETURNING ` + IDEMPOTENCY_KEY_FIELDS
+	return scanIdempotencyKey(tx.QueryRow(s, key, requestHash, now(), expiresAt.UTC(), lockedUntil.UTC()))
+}
+
+// IdempotencyKeysClaimTx holds a key whose request died before it wrote
+// anything until lockedUntil, for a retry to run it again. It returns nil
+// if the key can't be claimed, because its request is done, committed a
+// write, or still holds it, which is the case when another retry claimed
+// it first.
+func IdempotencyKeysClaimTx(tx *sql.Tx, key string, lockedUntil time.Time) (*IdempotencyKey, error) {
+	s := `UPDATE idempotency_keys SET locked_until=$1 WHERE id=$2 AND status=0 AND committed_at IS NULL AND locked_until<=$3 RETURNING ` + IDEMPOTENCY_KEY_FIELDS
+	k, err := scanIdempotencyKey(tx.QueryRow(s, lockedUntil.UTC(), key, now()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+// IdempotencyKeysCommitTx marks the request of a key held until lease as
+// having committed a write, in the transaction of that write. It fails with
+// ErrIdempotencyLeaseLost if another request claimed the key since, which
+// rolls the write back.
+func IdempotencyKeysCommitTx(tx *sql.Tx, key string, lease time.Time) error {
+	return checkLease(tx.QueryRow(`UPDATE idempotency_keys SET committed_at=COALESCE(committed_at, $1) WHERE id=$2 RETURNING locked_until`, now(), key), lease)
+}
+
+// IdempotencyKeysCompleteTx saves the response of the request of a key
+// held until lease. It fails with ErrIdempotencyLeaseLost if another
+// request claimed the key since.
+func IdempotencyKeysCompleteTx(tx *sql.Tx, key string, lease time.Time, status int, headers http.Header, body []byte) error {
+	h, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	return checkLease(tx.QueryRow(`UPDATE idempotency_keys SET status=$1, headers=$2, body=$3 WHERE id=$4 RETURNING locked_until`, status, string(h), body, key), lease)
+}
+
+// IdempotencyKeysReleaseTx forgets the key of a request held until lease,
+// so that the request can be made again. It fails with
+// ErrIdempotencyLeaseLost if another request claimed the key since.
+func IdempotencyKeysReleaseTx(tx *sql.Tx, key string, lease time.Time) error {
+	return checkLease(tx.QueryRow(`DELETE FROM idempotency_keys WHERE id=$1 RETURNING locked_until`, key), lease)
+}
+
+// checkLease scans the locked_until of a key that was just written, and
+// fails if it isn't lease, so that the write is rolled back. The row is
+// locked by the write, a claim can't come in between.
+func checkLease(row *sql.Row, lease time.Time) error {
+	var lockedUntil time.Time
+	err := row.Scan(&lockedUntil)
+	if err == sql.ErrNoRows || (err == nil && !lockedUntil.Equal(lease)) {
+		return ErrIdempotencyLeaseLost
+	}
+	return err
+}
+
+// IdempotencyKeysDeleteTx forgets a key, whoever holds it.
+func IdempotencyKeysDeleteTx(tx *sql.Tx, key string) error {
+	_, err := tx.Exec(`DELETE FROM idempotency_keys WHERE id=$1`, key)
+	return err
+}
+
+// IdempotencyKeysPurgeTx deletes the keys that expired before the given
+// time.
+func IdempotencyKeysPurgeTx(tx *sql.Tx, before time.Time) (int64, error) {
+	res, err := tx.Exec(`DELETE FROM idempotency_keys WHERE expires_at < $1`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,1139 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrReadOnlyTx = errors.New("cannot write in a read-only transaction")
+	ErrForeignKey = errors.New("referenced row does not exist")
+	ErrUnique     = errors.New("row already exists")
+)
+
+// MemoryStore keeps users and posts in maps, for unit tests and demos that
+// run without Postgres. It follows the same rules as the SQL store: lists
+// are filtered, sorted and paged the same way, deletes are soft and
+// deleting a user deletes their posts, and missing rows come back as nil.
+//
+// Write transactions work on a copy of the data that replaces the original
+// on success, so a failed transaction leaves nothing behind. Transactions
+// are serialized, which is plenty for the sizes this is meant for.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data *memoryData
+}
+
+// reactionKey is the primary key of reactions.
+type reactionKey struct {
+	post, user uuid.UUID
+	kind       string
+}
+
+// followKey is the primary key of follows.
+type followKey struct {
+	follower, followee uuid.UUID
+}
+
+type memoryData struct {
+	users     map[uuid.UUID]User
+	posts     map[uuid.UUID]Post
+	comments  map[uuid.UUID]Comment
+	follows   map[followKey]Follow
+	reactions map[reactionKey]Reaction
+	tags      map[string]uuid.UUID
+	revisions map[uuid.UUID]PostRevision
+	audit     []AuditRecord
+	keys      map[string]IdempotencyKey
+	// slugs and handles are post_slugs and user_handles.
+	slugs   map[string]uuid.UUID
+	handles map[string]uuid.UUID
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: &memoryData{
+			users:     map[uuid.UUID]User{},
+			posts:     map[uuid.UUID]Post{},
+			comments:  map[uuid.UUID]Comment{},
+			follows:   map[followKey]Follow{},
+			reactions: map[reactionKey]Reaction{},
+			tags:      map[string]uuid.UUID{},
+			revisions: map[uuid.UUID]PostRevision{},
+			keys:      map[string]IdempotencyKey{},
+			slugs:     map[string]uuid.UUID{},
+			handles:   map[string]uuid.UUID{},
+		},
+	}
+}
+
+func (s *MemoryStore) BeginTx(ctx context.Context, opts *sql.TxOptions, fn func(r *Repositories) error) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	if opts != nil && opts.ReadOnly {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return fn(withViewer(ctx, withAudit(ctx, s.data.repositories(true, nil))))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := s.data.clone()
+	r := data.repositories(false, authorFrom(ctx))
+	err = fn(withViewer(ctx, withAudit(ctx, r)))
+	if err != nil {
+		return err
+	}
+	err = commitIdempotencyKey(ctx, opts, r.Idempotency)
+	if err != nil {
+		return err
+	}
+	s.data = data
+	reportCommit(ctx, opts)
+	return nil
+}
+
+func (d *memoryData) clone() *memoryData {
+	c := &memoryData{
+		users:     make(map[uuid.UUID]User, len(d.users)),
+		posts:     make(map[uuid.UUID]Post, len(d.posts)),
+		comments:  maps.Clone(d.comments),
+		follows:   maps.Clone(d.follows),
+		reactions: maps.Clone(d.reactions),
+		tags:      maps.Clone(d.tags),
+		revisions: make(map[uuid.UUID]PostRevision, len(d.revisions)),
+		// Records are only ever appended, the copy can share them.
+		audit:   d.audit[:len(d.audit):len(d.audit)],
+		keys:    maps.Clone(d.keys),
+		slugs:   maps.Clone(d.slugs),
+		handles: maps.Clone(d.handles),
+	}
+	for id, u := range d.users {
+		c.users[id] = u
+	}
+	for id, p := range d.posts {
+		c.posts[id] = p
+	}
+	for id, rev := range d.revisions {
+		c.revisions[id] = rev
+	}
+	return c
+}
+
+func (d *memoryData) repositories(readOnly bool, author *uuid.UUID) *Repositories {
+	return &Repositories{
+		Users:       memoryUsers{d, readOnly},
+		Posts:       memoryPosts{d, readOnly, author},
+		Comments:    memoryComments{d, readOnly},
+		Follows:     memoryFollows{d, readOnly},
+		Reactions:   memoryReactions{d, readOnly},
+		Tags:        memoryTags{d},
+		Revisions:   memoryRevisions{d},
+		Audit:       memoryAudit{d, readOnly},
+		Idempotency: memoryIdempotency{d, readOnly},
+	}
+}
+
+// deletePost hard deletes a post, and like fk_post its revisions and
+// comments.
+func (d *memoryData) deletePost(id uuid.UUID) {
+	delete(d.posts, id)
+	maps.DeleteFunc(d.slugs, func(_ string, postId uuid.UUID) bool { return postId == id })
+	for revId, rev := range d.revisions {
+		if rev.PostId == id {
+			delete(d.revisions, revId)
+		}
+	}
+	for commentId, c := range d.comments {
+		if c.PostId == id {
+			delete(d.comments, commentId)
+		}
+	}
+	for key := range d.reactions {
+		if key.post == id {
+			delete(d.reactions, key)
+		}
+	}
+}
+
+// deleteReaction removes a reaction and counts it off its post, like the
+// trigger on reactions does.
+func (d *memoryData) deleteReaction(key reactionKey) {
+	delete(d.reactions, key)
+	p, ok := d.posts[key.post]
+	if !ok {
+		return
+	}
+	// Posts share their counts with the copies of clone.
+	p.Reactions = maps.Clone(p.Reactions)
+	p.Reactions[key.kind]--
+	if p.Reactions[key.kind] == 0 {
+		delete(p.Reactions, key.kind)
+	}
+	d.posts[key.post] = p
+}
+
+// deleteComment hard deletes a comment, and like fk_parent its replies.
+func (d *memoryData) deleteComment(id uuid.UUID) {
+	delete(d.comments, id)
+	for replyId, c := range d.comments {
+		if c.ParentId != nil && *c.ParentId == id {
+			d.deleteComment(replyId)
+		}
+	}
+}
+
+// memoryList filters, sorts and pages rows the way the SQL list queries do.
+func memoryList[T any](rows []*T, q *ListQuery, field func(row *T, column string) any) *List[T] {
+	fields := func(row *T) func(string) any {
+		return func(column string) any { return field(row, column) }
+	}
+	rows = slices.DeleteFunc(rows, func(row *T) bool {
+		return !q.match(fields(row)) || !q.after(fields(row))
+	})
+	slices.SortFunc(rows, func(a, b *T) int {
+		return q.compare(fields(a), fields(b))
+	})
+	if len(rows) > q.limit()+1 {
+		rows = rows[:q.limit()+1]
+	}
+	return newList(rows, q, field)
+}
+
+type memoryUsers struct {
+	data     *memoryData
+	readOnly bool
+}
+
+func (r memoryUsers) Get(id uuid.UUID) (*User, error) {
+	u, ok := r.data.users[id]
+	if !ok || u.DeletedAt != nil {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+func (r memoryUsers) GetWithDeleted(id uuid.UUID) (*User, error) {
+	u, ok := r.data.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+func (r memoryUsers) GetByEmail(email string) (*User, error) {
+	email = normalizeEmail(email)
+	for _, u := range r.data.users {
+		if u.Email == email && u.DeletedAt == nil {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r memoryUsers) GetByHandle(handle string) (*User, error) {
+	id, ok := r.data.handles[handle]
+	if !ok {
+		return nil, nil
+	}
+	return r.Get(id)
+}
+
+// checkEmail is users_email_key: no other live user may have the email.
+func (r memoryUsers) checkEmail(id uuid.UUID, email string) error {
+	for _, u := range r.data.users {
+		if u.Id != id && u.Email == email && u.DeletedAt == nil {
+			return &ConstraintError{Kind: UniqueViolation, Field: "email", Err: fmt.Errorf("%w: email %s", ErrUnique, email)}
+		}
+	}
+	return nil
+}
+
+func (r memoryUsers) GetAll(q *ListQuery) (*List[User], error) {
+	users := []*User{}
+	for _, u := range r.data.users {
+		if u.DeletedAt == nil || q.includeDeleted() {
+			users = append(users, &u)
+		}
+	}
+	return memoryList(users, q, (*User).field), nil
+}
+
+func (r memoryUsers) Create(input *UserInput) (*User, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	u := User{
+		Id:        uuid.New(),
+		Name:      input.Name,
+		Email:     normalizeEmail(input.Email),
+		CreatedAt: now(),
+		Version:   1,
+	}
+	err := r.checkEmail(u.Id, u.Email)
+	if err != nil {
+		return nil, err
+	}
+	u.Handle = nextSlug(r.data.handles, userHandles, u.Name, u.Id)
+	r.data.users[u.Id] = u
+	return &u, nil
+}
+
+func (r memoryUsers) CreateMany(inputs []*UserInput) ([]*User, error) {
+	users := make([]*User, len(inputs))
+	for i, input := range inputs {
+		u, err := r.Create(input)
+		if err != nil {
+			return nil, err
+		}
+		users[i] = u
+	}
+	return users, nil
+}
+
+func (r memoryUsers) Update(id uuid.UUID, input *UserInput) (*User, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	u, ok := r.data.users[id]
+	if !ok || u.DeletedAt != nil {
+		return nil, nil
+	}
+	updatedAt := now()
+	u.Name = input.Name
+	u.Email = normalizeEmail(input.Email)
+	u.UpdatedAt = &updatedAt
+	err := r.checkEmail(id, u.Email)
+	if err != nil {
+		return nil, err
+	}
+	u.Handle = renameSlug(r.data.handles, userHandles, id, u.Handle, u.Name)
+	u.Version++
+	r.data.users[id] = u
+	return &u, nil
+}
+
+func (r memoryUsers) Delete(id uuid.UUID) (*User, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	u, ok := r.data.users[id]
+	if !ok || u.DeletedAt != nil {
+		return nil, nil
+	}
+	deletedAt := now()
+	u.DeletedAt = &deletedAt
+	u.Version++
+	r.data.users[id] = u
+	for postId, p := range r.data.posts {
+		if p.UserId == id && p.DeletedAt == nil {
+			p.DeletedAt = &deletedAt
+			p.Version++
+			r.data.posts[postId] = p
+		}
+	}
+	return &u, nil
+}
+
+func (r memoryUsers) Restore(id uuid.UUID) (*User, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	u, ok := r.data.users[id]
+	if !ok {
+		return nil, nil
+	}
+	if u.DeletedAt == nil {
+		return &u, nil
+	}
+	err := r.checkEmail(id, u.Email)
+	if err != nil {
+		return nil, err
+	}
+	for postId, p := range r.data.posts {
+		if p.UserId == id && p.DeletedAt != nil && p.DeletedAt.Equal(*u.DeletedAt) {
+			p.DeletedAt = nil
+			p.Version++
+			r.data.posts[postId] = p
+		}
+	}
+	u.DeletedAt = nil
+	u.Version++
+	r.data.users[id] = u
+	return &u, nil
+}
+
+func (r memoryUsers) Purge(before time.Time) (int64, error) {
+	if r.readOnly {
+		return 0, ErrReadOnlyTx
+	}
+	var n int64
+	for id, u := range r.data.users {
+		if u.DeletedAt == nil || !u.DeletedAt.Before(before) {
+			continue
+		}
+		delete(r.data.users, id)
+		maps.DeleteFunc(r.data.handles, func(_ string, userId uuid.UUID) bool { return userId == id })
+		for postId, p := range r.data.posts {
+			if p.UserId == id {
+				r.data.deletePost(postId)
+			}
+		}
+		for commentId, c := range r.data.comments {
+			if c.UserId == id {
+				r.data.deleteComment(commentId)
+			}
+		}
+		for key := range r.data.follows {
+			if key.follower == id || key.followee == id {
+				delete(r.data.follows, key)
+			}
+		}
+		for key := range r.data.reactions {
+			if key.user == id {
+				r.data.deleteReaction(key)
+			}
+		}
+		n++
+	}
+	return n, nil
+}
+
+type memoryPosts struct {
+	data     *memoryData
+	readOnly bool
+	author   *uuid.UUID
+}
+
+func (r memoryPosts) Get(id uuid.UUID) (*Post, error) {
+	p, ok := r.data.posts[id]
+	if !ok || p.DeletedAt != nil {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (r memoryPosts) GetWithDeleted(id uuid.UUID) (*Post, error) {
+	p, ok := r.data.posts[id]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (r memoryPosts) GetBySlug(slug string) (*Post, error) {
+	id, ok := r.data.slugs[slug]
+	if !ok {
+		return nil, nil
+	}
+	return r.Get(id)
+}
+
+func (r memoryPosts) GetAll(q *ListQuery) (*List[Post], error) {
+	posts := []*Post{}
+	for _, p := range r.data.posts {
+		if p.DeletedAt == nil || q.includeDeleted() {
+			posts = append(posts, &p)
+		}
+	}
+	return memoryList(posts, q, (*Post).field), nil
+}
+
+func (r memoryPosts) Count(q *ListQuery) (int, error) {
+	count := 0
+	for _, p := range r.data.posts {
+		if p.DeletedAt != nil && !q.includeDeleted() {
+			continue
+		}
+		if q.match(func(column string) any { return p.field(column) }) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryPosts) Search(text string, q *ListQuery) (*List[PostSearchResult], error) {
+	q = q.withDefaultSort(PostSearchListSpec)
+	sq := parseSearch(text)
+	results := []*PostSearchResult{}
+	for _, p := range r.data.posts {
+		if p.DeletedAt != nil {
+			continue
+		}
+		if rank, ok := sq.rank(p.Title, p.Content); ok {
+			results = append(results, &PostSearchResult{Post: p, Rank: rank, Headline: sq.headline(p.Content)})
+		}
+	}
+	return memoryList(results, q, (*PostSearchResult).field), nil
+}
+
+func (r memoryPosts) Feed(userId uuid.UUID, q *ListQuery) (*List[Post], error) {
+	q = q.withDefaultSort(FeedListSpec)
+	posts := []*Post{}
+	for _, p := range r.data.posts {
+		if _, ok := r.data.follows[followKey{userId, p.UserId}]; ok && p.DeletedAt == nil {
+			posts = append(posts, &p)
+		}
+	}
+	return memoryList(posts, q, (*Post).field), nil
+}
+
+func (r memoryPosts) Create(input *PostInput) (*Post, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	if u, ok := r.data.users[input.UserId]; !ok || u.DeletedAt != nil {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "user_id", Err: fmt.Errorf("%w: user %s", ErrForeignKey, input.UserId)}
+	}
+	createdAt := now()
+	status := input.newStatus(createdAt)
+	p := Post{
+		Id:            uuid.New(),
+		Title:         input.Title,
+		Content:       input.Content,
+		ContentFormat: input.contentFormat(),
+		UserId:        input.UserId,
+		CreatedAt:     createdAt,
+		Version:       1,
+		Status:        status.Status,
+		PublishAt:     status.PublishAt,
+		PublishedAt:   status.PublishedAt,
+		Reactions:     map[string]int{},
+	}
+	err := r.setTags(&p, input.Tags)
+	if err != nil {
+		return nil, err
+	}
+	p.Slug = nextSlug(r.data.slugs, postSlugs, p.Title, p.Id)
+	r.recordRevision(&p)
+	r.data.posts[p.Id] = p
+	return &p, nil
+}
+
+func (r memoryPosts) CreateMany(inputs []*PostInput) ([]*Post, error) {
+	posts := make([]*Post, len(inputs))
+	for i, input := range inputs {
+		p, err := r.Create(input)
+		if err != nil {
+			return nil, err
+		}
+		posts[i] = p
+	}
+	return posts, nil
+}
+
+func (r memoryPosts) Update(id uuid.UUID, input *PostInput) (*Post, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	p, ok := r.data.posts[id]
+	if !ok || p.DeletedAt != nil {
+		return nil, nil
+	}
+	if u, ok := r.data.users[input.UserId]; !ok || u.DeletedAt != nil {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "user_id", Err: fmt.Errorf("%w: user %s", ErrForeignKey, input.UserId)}
+	}
+	updatedAt := now()
+	p.Title = input.Title
+	p.Content = input.Content
+	if input.ContentFormat != "" {
+		p.ContentFormat = input.ContentFormat
+	}
+	p.UserId = input.UserId
+	p.UpdatedAt = &updatedAt
+	p.Version++
+	p.setStatus(input.Status, input.PublishAt, updatedAt)
+	if input.Tags != nil {
+		err := r.setTags(&p, input.Tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.Slug = renameSlug(r.data.slugs, postSlugs, id, p.Slug, p.Title)
+	r.recordRevision(&p)
+	r.data.posts[id] = p
+	return &p, nil
+}
+
+func (r memoryPosts) Delete(id uuid.UUID) (*Post, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	p, ok := r.data.posts[id]
+	if !ok || p.DeletedAt != nil {
+		return nil, nil
+	}
+	deletedAt := now()
+	p.DeletedAt = &deletedAt
+	p.Version++
+	r.data.posts[id] = p
+	return &p, nil
+}
+
+func (r memoryPosts) Restore(id uuid.UUID) (*Post, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	p, ok := r.data.posts[id]
+	if !ok {
+		return nil, nil
+	}
+	if p.DeletedAt == nil {
+		return &p, nil
+	}
+	if r.data.users[p.UserId].DeletedAt != nil {
+		return nil, ErrUserDeleted
+	}
+	p.DeletedAt = nil
+	p.Version++
+	r.data.posts[id] = p
+	return &p, nil
+}
+
+// setTags mirrors postsSetTagsTx.
+func (r memoryPosts) setTags(p *Post, tags []string) error {
+	slugs := NormalizeTags(tags)
+	err := checkTags(slugs)
+	if err != nil {
+		return err
+	}
+	for _, slug := range slugs {
+		if _, ok := r.data.tags[slug]; !ok {
+			r.data.tags[slug] = uuid.New()
+		}
+	}
+	p.Tags = slugs
+	return nil
+}
+
+// nextSlug mirrors slugger.next, on history, and adds the slug to it.
+func nextSlug(history map[string]uuid.UUID, t slugTable, text string, id uuid.UUID) string {
+	base := t.base(text)
+	slug := base
+	for n := 2; ; n++ {
+		if owner, ok := history[slug]; !ok || owner == id {
+			history[slug] = id
+			return slug
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// renameSlug mirrors slugger.renameTx.
+func renameSlug(history map[string]uuid.UUID, t slugTable, id uuid.UUID, current, text string) string {
+	if hasBase(current, t.base(text)) {
+		return current
+	}
+	return nextSlug(history, t, text, id)
+}
+
+// recordRevision mirrors postsRecordRevisionTx. Posts keep the HTML of
+// their last revision.
+func (r memoryPosts) recordRevision(p *Post) {
+	last := PostRevision{}
+	for _, rev := range r.data.revisions {
+		if rev.PostId == p.Id && rev.Rev > last.Rev {
+			last = rev
+		}
+	}
+	if last.Rev > 0 && last.Title == p.Title && last.Content == p.Content && last.ContentFormat == p.ContentFormat {
+		return
+	}
+	p.setContentHTML(nil)
+	createdAt := p.CreatedAt
+	if p.UpdatedAt != nil {
+		createdAt = *p.UpdatedAt
+	}
+	rev := PostRevision{
+		Id:            uuid.New(),
+		PostId:        p.Id,
+		Rev:           last.Rev + 1,
+		UserId:        p.UserId,
+		AuthorId:      r.author,
+		Title:         p.Title,
+		Content:       p.Content,
+		ContentFormat: p.ContentFormat,
+		CreatedAt:     createdAt,
+	}
+	r.data.revisions[rev.Id] = rev
+}
+
+func (r memoryPosts) Purge(before time.Time) (int64, error) {
+	if r.readOnly {
+		return 0, ErrReadOnlyTx
+	}
+	var n int64
+	for id, p := range r.data.posts {
+		if p.DeletedAt != nil && p.DeletedAt.Before(before) {
+			r.data.deletePost(id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memoryPosts) Publish(before time.Time) (int64, error) {
+	if r.readOnly {
+		return 0, ErrReadOnlyTx
+	}
+	publishedAt := now()
+	var n int64
+	for id, p := range r.data.posts {
+		if p.Status == PostScheduled && !p.PublishAt.After(before) && p.DeletedAt == nil {
+			p.setStatus(PostPublished, nil, publishedAt)
+			p.Version++
+			r.data.posts[id] = p
+			n++
+		}
+	}
+	return n, nil
+}
+
+type memoryComments struct {
+	data     *memoryData
+	readOnly bool
+}
+
+// visible is commentVisible: the post and author of the comment are live.
+func (r memoryComments) visible(c Comment) bool {
+	return r.data.posts[c.PostId].DeletedAt == nil && r.data.users[c.UserId].DeletedAt == nil
+}
+
+func (r memoryComments) Get(id uuid.UUID) (*Comment, error) {
+	c, ok := r.data.comments[id]
+	if !ok || !r.visible(c) {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+func (r memoryComments) GetAll(q *ListQuery) (*List[Comment], error) {
+	q = q.withDefaultSort(CommentListSpec)
+	comments := []*Comment{}
+	for _, c := range r.data.comments {
+		if r.visible(c) {
+			comments = append(comments, &c)
+		}
+	}
+	return memoryList(comments, q, (*Comment).field), nil
+}
+
+func (r memoryComments) Replies(rootIds []uuid.UUID, maxDepth int) ([]*Comment, error) {
+	replies := []*Comment{}
+	for _, c := range r.data.comments {
+		if c.RootId != nil && slices.Contains(rootIds, *c.RootId) && c.Depth <= maxDepth && r.visible(c) {
+			replies = append(replies, &c)
+		}
+	}
+	slices.SortFunc(replies, func(a, b *Comment) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.Id.String(), b.Id.String()))
+	})
+	return replies, nil
+}
+
+func (r memoryComments) Create(postId uuid.UUID, input *CommentInput) (*Comment, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	if p, ok := r.data.posts[postId]; !ok || p.DeletedAt != nil {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "post_id", Err: fmt.Errorf("%w: post %s", ErrForeignKey, postId)}
+	}
+	if u, ok := r.data.users[input.UserId]; !ok || u.DeletedAt != nil {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "user_id", Err: fmt.Errorf("%w: user %s", ErrForeignKey, input.UserId)}
+	}
+	c := Comment{
+		Id:        uuid.New(),
+		PostId:    postId,
+		UserId:    input.UserId,
+		ParentId:  input.ParentId,
+		Content:   input.Content,
+		CreatedAt: now(),
+		Version:   1,
+	}
+	if input.ParentId != nil {
+		parent, _ := r.Get(*input.ParentId)
+		err := c.reply(postId, parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.data.comments[c.Id] = c
+	return &c, nil
+}
+
+func (r memoryComments) Update(id uuid.UUID, input *CommentInput) (*Comment, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	c, ok := r.data.comments[id]
+	if !ok || !r.visible(c) {
+		return nil, nil
+	}
+	updatedAt := now()
+	c.Content = input.Content
+	c.UpdatedAt = &updatedAt
+	c.Version++
+	r.data.comments[id] = c
+	return &c, nil
+}
+
+func (r memoryComments) Delete(id uuid.UUID) (*Comment, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	c, ok := r.data.comments[id]
+	if !ok || !r.visible(c) {
+		return nil, nil
+	}
+	r.data.deleteComment(id)
+	return &c, nil
+}
+
+type memoryFollows struct {
+	data     *memoryData
+	readOnly bool
+}
+
+func (r memoryFollows) Get(followerId, followeeId uuid.UUID) (*Follow, error) {
+	f, ok := r.data.follows[followKey{followerId, followeeId}]
+	if !ok {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+func (r memoryFollows) Create(followeeId uuid.UUID, input *FollowInput) (*Follow, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	if input.FollowerId == followeeId {
+		return nil, errSelfFollow()
+	}
+	if u, ok := r.data.users[input.FollowerId]; !ok || u.DeletedAt != nil {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "follower_id", Err: fmt.Errorf("%w: user %s", ErrForeignKey, input.FollowerId)}
+	}
+	if u, ok := r.data.users[followeeId]; !ok || u.DeletedAt != nil {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "followee_id", Err: fmt.Errorf("%w: user %s", ErrForeignKey, followeeId)}
+	}
+	key := followKey{input.FollowerId, followeeId}
+	f, ok := r.data.follows[key]
+	if !ok {
+		f = Follow{FollowerId: input.FollowerId, FolloweeId: followeeId, CreatedAt: now()}
+		r.data.follows[key] = f
+	}
+	return &f, nil
+}
+
+func (r memoryFollows) Delete(followerId, followeeId uuid.UUID) (*Follow, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	key := followKey{followerId, followeeId}
+	f, ok := r.data.follows[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(r.data.follows, key)
+	return &f, nil
+}
+
+// users lists the live users on the other side of the follows of the
+// user, followers or followed ones.
+func (r memoryFollows) users(userId uuid.UUID, followers bool) []*FollowUser {
+	users := []*FollowUser{}
+	for key, f := range r.data.follows {
+		by, id := key.follower, key.followee
+		if followers {
+			by, id = key.followee, key.follower
+		}
+		if by != userId {
+			continue
+		}
+		if u, ok := r.data.users[id]; ok && u.DeletedAt == nil {
+			users = append(users, &FollowUser{User: u, FollowedAt: f.CreatedAt})
+		}
+	}
+	return users
+}
+
+func (r memoryFollows) Followers(userId uuid.UUID, q *ListQuery) (*List[FollowUser], error) {
+	q = q.withDefaultSort(FollowListSpec)
+	return memoryList(r.users(userId, true), q, (*FollowUser).field), nil
+}
+
+func (r memoryFollows) Following(userId uuid.UUID, q *ListQuery) (*List[FollowUser], error) {
+	q = q.withDefaultSort(FollowListSpec)
+	return memoryList(r.users(userId, false), q, (*FollowUser).field), nil
+}
+
+func (r memoryFollows) Counts(userId uuid.UUID) (*FollowCounts, error) {
+	return &FollowCounts{
+		Followers: len(r.users(userId, true)),
+		Following: len(r.users(userId, false)),
+	}, nil
+}
+
+type memoryReactions struct {
+	data     *memoryData
+	readOnly bool
+}
+
+func (r memoryReactions) Create(postId, userId uuid.UUID, kind string) (*Reaction, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	p, ok := r.data.posts[postId]
+	if !ok || p.DeletedAt != nil {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "post_id", Err: fmt.Errorf("%w: post %s", ErrForeignKey, postId)}
+	}
+	if u, ok := r.data.users[userId]; !ok || u.DeletedAt != nil {
+		return nil, &ConstraintError{Kind: ForeignKeyViolation, Field: "user_id", Err: fmt.Errorf("%w: user %s", ErrForeignKey, userId)}
+	}
+	key := reactionKey{postId, userId, kind}
+	if reaction, ok := r.data.reactions[key]; ok {
+		return &reaction, nil
+	}
+	reaction := Reaction{PostId: postId, UserId: userId, Kind: kind, CreatedAt: now()}
+	r.data.reactions[key] = reaction
+	// Posts share their counts with the copies of clone.
+	counts := maps.Clone(p.Reactions)
+	if counts == nil {
+		counts = map[string]int{}
+	}
+	counts[kind]++
+	p.Reactions = counts
+	r.data.posts[postId] = p
+	return &reaction, nil
+}
+
+func (r memoryReactions) Delete(postId, userId uuid.UUID, kind string) (*Reaction, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	key := reactionKey{postId, userId, kind}
+	reaction, ok := r.data.reactions[key]
+	if !ok {
+		return nil, nil
+	}
+	r.data.deleteReaction(key)
+	return &reaction, nil
+}
+
+func (r memoryReactions) LoadReacted(userId uuid.UUID, posts ...*Post) error {
+	for _, p := range posts {
+		p.Reacted = []string{}
+		for key := range r.data.reactions {
+			if key.post == p.Id && key.user == userId {
+				p.Reacted = append(p.Reacted, key.kind)
+			}
+		}
+		slices.Sort(p.Reacted)
+	}
+	return nil
+}
+
+type memoryTags struct {
+	data *memoryData
+}
+
+// counts mirrors tagCounts.
+func (r memoryTags) counts() map[string]*Tag {
+	tags := make(map[string]*Tag, len(r.data.tags))
+	for slug, id := range r.data.tags {
+		tags[slug] = &Tag{Id: id, Slug: slug}
+	}
+	for _, p := range r.data.posts {
+		if p.DeletedAt != nil || p.Status != PostPublished {
+			continue
+		}
+		for _, slug := range p.Tags {
+			tags[slug].Count++
+		}
+	}
+	return tags
+}
+
+func (r memoryTags) Get(slug string) (*Tag, error) {
+	return r.counts()[normalizeTag(slug)], nil
+}
+
+func (r memoryTags) GetAll(q *ListQuery) (*List[Tag], error) {
+	q = q.withDefaultSort(TagListSpec)
+	tags := []*Tag{}
+	for _, t := range r.counts() {
+		if t.Count > 0 {
+			tags = append(tags, t)
+		}
+	}
+	return memoryList(tags, q, (*Tag).field), nil
+}
+
+type memoryRevisions struct {
+	data *memoryData
+}
+
+func (r memoryRevisions) Get(postId uuid.UUID, rev int) (*PostRevision, error) {
+	for _, revision := range r.data.revisions {
+		if revision.PostId == postId && revision.Rev == rev {
+			return &revision, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r memoryRevisions) GetAll(q *ListQuery) (*List[PostRevision], error) {
+	revisions := []*PostRevision{}
+	for _, revision := range r.data.revisions {
+		revisions = append(revisions, &revision)
+	}
+	return memoryList(revisions, q, (*PostRevision).field), nil
+}
+
+type memoryAudit struct {
+	data     *memoryData
+	readOnly bool
+}
+
+func (r memoryAudit) Record(records ...*AuditRecord) error {
+	if r.readOnly {
+		return ErrReadOnlyTx
+	}
+	for _, record := range records {
+		r.data.audit = append(r.data.audit, *record)
+	}
+	return nil
+}
+
+func (r memoryAudit) GetAll(q *ListQuery) (*List[AuditRecord], error) {
+	records := []*AuditRecord{}
+	for _, record := range r.data.audit {
+		records = append(records, &record)
+	}
+	return memoryList(records, q, (*AuditRecord).field), nil
+}
+
+type memoryIdempotency struct {
+	data     *memoryData
+	readOnly bool
+}
+
+func (r memoryIdempotency) Get(key string) (*IdempotencyKey, error) {
+	k, ok := r.data.keys[key]
+	if !ok {
+		return nil, nil
+	}
+	return &k, nil
+}
+
+func (r memoryIdempotency) Create(key, requestHash string, lockedUntil, expiresAt time.Time) (*IdempotencyKey, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	if _, ok := r.data.keys[key]; ok {
+		return nil, &ConstraintError{Kind: UniqueViolation, Field: "id", Err: fmt.Errorf("%w: idempotency key %s", ErrUnique, key)}
+	}
+	k := IdempotencyKey{
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   now(),
+		ExpiresAt:   expiresAt.UTC(),
+		LockedUntil: lockedUntil.UTC(),
+	}
+	r.data.keys[key] = k
+	return &k, nil
+}
+
+func (r memoryIdempotency) Claim(key string, lockedUntil time.Time) (*IdempotencyKey, error) {
+	if r.readOnly {
+		return nil, ErrReadOnlyTx
+	}
+	k, ok := r.data.keys[key]
+	if !ok || !k.Claimable() {
+		return nil, nil
+	}
+	k.LockedUntil = lockedUntil.UTC()
+	r.data.keys[key] = k
+	return &k, nil
+}
+
+func (r memoryIdempotency) Commit(key string, lease time.Time) error {
+	k, err := r.held(key, lease)
+	if err != nil {
+		return err
+	}
+	if k.CommittedAt == nil {
+		t := now()
+		k.CommittedAt = &t
+	}
+	r.data.keys[key] = k
+	return nil
+}
+
+func (r memoryIdempotency) Complete(key string, lease time.Time, status int, headers http.Header, body []byte) error {
+	k, err := r.held(key, lease)
+	if err != nil {
+		return err
+	}
+	k.Status = status
+	k.Headers = headers.Clone()
+	k.Body = slices.Clone(body)
+	r.data.keys[key] = k
+	return nil
+}
+
+func (r memoryIdempotency) Release(key string, lease time.Time) error {
+	_, err := r.held(key, lease)
+	if err != nil {
+		return err
+	}
+	delete(r.data.keys, key)
+	return nil
+}
+
+// held finds a key held until lease, for a write.
+func (r memoryIdempotency) held(key string, lease time.Time) (IdempotencyKey, error) {
+	if r.readOnly {
+		return IdempotencyKey{}, ErrReadOnlyTx
+	}
+	k, ok := r.data.keys[key]
+	if !ok || !k.LockedUntil.Equal(lease) {
+		return IdempotencyKey{}, ErrIdempotencyLeaseLost
+	}
+	return k, nil
+}
+
+func (r memoryIdempotency) Delete(key string) error {
+	if r.readOnly {
+		return ErrReadOnlyTx
+	}
+	delete(r.data.keys, key)
+	return nil
+}
+
+func (r memoryIdempotency) Purge(before time.Time) (int64, error) {
+	if r.readOnly {
+		return 0, ErrReadOnlyTx
+	}
+	var n int64
+	for key, k := range r.data.keys {
+		if k.ExpiresAt.Before(before) {
+			delete(r.data.keys, key)
+			n++
+		}
+	}
+	return n, nil
+}
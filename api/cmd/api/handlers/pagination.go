@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+)
+
+const DefaultPageSize = 20
+
+// Cursor points just past the last row of a page, in the direction the
+// client is paging. It holds the row's value for each sort key, see
+// ListQuery.sortKeys.
+type Cursor struct {
+	Sort     string   `json:"s"`
+	Values   []string `json:"v"`
+	Backward bool     `json:"b,omitempty"`
+}
+
+// List is one page of a collection. The cursors are nil at either end.
+// Total counts the rows of every page, and is only filled in by routes
+// that promise it.
+type List[T any] struct {
+	Data       []*T    `json:"data"`
+	NextCursor *string `json:"next_cursor"`
+	PrevCursor *string `json:"prev_cursor"`
+	Total      *int    `json:"total,omitempty"`
+}
+
+// Cursors exposes the cursors to handleQuery, which turns them into Link
+// headers.
+func (l *List[T]) Cursors() (next, prev *string) {
+	return l.NextCursor, l.PrevCursor
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cursor{}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newList builds the page from rows fetched in the paging direction, with up
+// to one extra row that only signals there is more to fetch. field reads a
+// row's value by column name.
+func newList[T any](rows []*T, q *ListQuery, field func(row *T, column string) any) *List[T] {
+	limit := q.limit()
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	if q.backward() {
+		slices.Reverse(rows)
+	}
+
+	list := &List[T]{Data: rows}
+	if len(rows) == 0 {
+		return list
+	}
+
+	cursor := func(row *T, backward bool) *string {
+		c := Cursor{Sort: q.sortParam(), Backward: backward}
+		for _, k := range q.sortKeys() {
+			c.Values = append(c.Values, formatValue(field(row, k.Column)))
+		}
+		s := c.Encode()
+		return &s
+	}
+
+	hasNext, hasPrev := more, q != nil && q.Cursor != nil
+	if q.backward() {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		list.NextCursor = cursor(rows[len(rows)-1], false)
+	}
+	if hasPrev {
+		list.PrevCursor = cursor(rows[0], true)
+	}
+	return list
+}
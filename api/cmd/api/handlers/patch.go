@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Content types accepted by PATCH routes.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// Patch applies a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902),
+// depending on contentType, to the JSON of current and decodes the result
+// into a new T. Fields that T doesn't have are rejected, so patches can only
+// change what a PUT could.
+//
+// Malformed patches are a 400, patches that don't apply to current, such as
+// a failed test operation, a 409, and results that aren't a valid T a 422.
+func Patch[T any](contentType string, current *T, patch []byte) (*T, error) {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case MergePatchType:
+		doc, err = mergePatch(doc, patch)
+	case JSONPatchType:
+		doc, err = jsonPatch(doc, patch)
+	default:
+		return nil, NewHTTPError(http.StatusUnsupportedMediaType, fmt.Errorf("content type must be %s or %s", MergePatchType, JSONPatchType))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(T)
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(result)
+	if err != nil {
+		return nil, NewHTTPError(http.StatusUnprocessableEntity, fmt.Errorf("patched document is invalid: %w", err))
+	}
+	return result, nil
+}
+
+func decodeJSON(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func mergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeJSON(patch)
+	if err != nil {
+		return nil, NewHTTPError(http.StatusBadRequest, fmt.Errorf("invalid merge patch: %w", err))
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+// mergeValue is the MergePatch function of RFC 7396: objects are merged
+// member by member, null removes a member, and anything else replaces the
+// target.
+func mergeValue(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergeValue(t[k], v)
+	}
+	return t
+}
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+func jsonPatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []patchOperation
+	err = json.Unmarshal(patch, &ops)
+	if err != nil {
+		return nil, NewHTTPError(http.StatusBadRequest, fmt.Errorf("invalid JSON patch: %w", err))
+	}
+
+	for i, op := range ops {
+		target, err = op.apply(target)
+		if err != nil {
+			var httpErr *HTTPError
+			if errors.As(err, &httpErr) {
+				httpErr.Message = fmt.Errorf("operation %d: %w", i, httpErr.Message)
+				return nil, httpErr
+			}
+			return nil, NewHTTPError(http.StatusConflict, fmt.Errorf("operation %d: %w", i, err))
+		}
+	}
+	return json.Marshal(target)
+}
+
+// apply runs one operation. Errors in the operation itself are returned as
+// 400 HTTPErrors, anything else means it doesn't apply to doc.
+func (op patchOperation) apply(doc any) (any, error) {
+	malformed := func(format string, args ...any) error {
+		return NewHTTPError(http.StatusBadRequest, fmt.Errorf(format, args...))
+	}
+
+	if op.Path == nil {
+		return nil, malformed("missing path")
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, malformed("%w", err)
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, malformed("%s needs a value", op.Op)
+		}
+		value, err = decodeJSON(op.Value)
+		if err != nil {
+			return nil, malformed("invalid value: %w", err)
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, malformed("%s needs from", op.Op)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, malformed("%w", err)
+		}
+		if op.Op == "move" && len(from) < len(path) && slices.Equal(from, path[:len(from)]) {
+			return nil, malformed("cannot move a value into itself")
+		}
+		value, err = getPointer(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			doc, err = removePointer(doc, from)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// Copies must not share maps or slices with the original.
+			b, _ := json.Marshal(value)
+			value, _ = decodeJSON(b)
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return addPointer(doc, path, value)
+	case "remove":
+		return removePointer(doc, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, err = removePointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, value)
+	case "test":
+		current, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, fmt.Errorf("test failed at %q", *op.Path)
+		}
+		return doc, nil
+	}
+	return nil, malformed("unknown op %q", op.Op)
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into reference tokens.
+func parsePointer(s string) ([]string, error) {
+	if s == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("invalid pointer %q", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token. "-", past the last element, is
+// only valid where n allows it.
+func arrayIndex(token string, n int) (int, error) {
+	if token == "-" {
+		token = strconv.Itoa(n - 1)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= n || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+func getPointer(doc any, path []string) (any, error) {
+	for _, t := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[t]
+			if !ok {
+				return nil, fmt.Errorf("no member %q", t)
+			}
+			doc = v
+		case []any:
+			if t == "-" {
+				return nil, fmt.Errorf("invalid array index %q", t)
+			}
+			i, err := arrayIndex(t, len(node))
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot index a scalar with %q", t)
+		}
+	}
+	return doc, nil
+}
+
+// updateParent calls fn with the container holding the last token of path,
+// and stores whatever fn returns in its place. Slices can change length, so
+// every container on the way is stored back.
+func updateParent(doc any, path []string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("no member %q", path[0])
+		}
+		child, err := updateParent(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[path[0]] = child
+		return node, nil
+	case []any:
+		i, err := arrayIndex(path[0], len(node))
+		if err != nil || path[0] == "-" {
+			return nil, fmt.Errorf("invalid array index %q", path[0])
+		}
+		child, err := updateParent(node[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+	return nil, fmt.Errorf("cannot index a scalar with %q", path[0])
+}
+
+func addPointer(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			// Adding may append, one past the last element.
+			i, err := arrayIndex(token, len(node)+1)
+			if err != nil {
+				return nil, err
+			}
+			return slices.Insert(node, i, value), nil
+		}
+		return nil, fmt.Errorf("cannot add to a scalar")
+	})
+}
+
+func removePointer(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	return updateParent(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("no member %q", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []any:
+			if token == "-" {
+				return nil, fmt.Errorf("invalid array index %q", token)
+			}
+			i, err := arrayIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			return slices.Delete(node, i, i+1), nil
+		}
+		return nil, fmt.Errorf("cannot remove from a scalar")
+	})
+}
+
+// jsonEqual compares decoded JSON values the way the test operation does,
+// numbers by value rather than by spelling.
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		return errA == nil && errB == nil && x == y
+	}
+	return a == b
+}
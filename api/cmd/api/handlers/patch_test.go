@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPatch(t *testing.T) {
+	userId := uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac120001")
+	otherId := uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac120002")
+	current := PostInput{Title: "title-1", Content: "content-1", UserId: userId}
+
+	tests := []struct {
+		description  string
+		contentType  string
+		patch        string
+		expected     PostInput
+		expectedCode int
+	}{
+		{
+			description: "Merge patch changes only the given fields",
+			contentType: MergePatchType,
+			patch:       `{"title": "new"}`,
+			expected:    PostInput{Title: "new", Content: "content-1", UserId: userId},
+		},
+		{
+			description: "Merge patch sets the tags",
+			contentType: MergePatchType,
+			patch:       `{"tags": ["go", "db"]}`,
+			expected:    PostInput{Title: "title-1", Content: "content-1", UserId: userId, Tags: []string{"go", "db"}},
+		},
+		{
+			description: "Merge patch null clears a field",
+			contentType: MergePatchType + "; charset=utf-8",
+			patch:       `{"content": null, "user_id": "` + otherId.String() + `"}`,
+			expected:    PostInput{Title: "title-1", UserId: otherId},
+		},
+		{
+			description:  "Merge patch with unknown field, expect fail",
+			contentType:  MergePatchType,
+			patch:        `{"id": "` + otherId.String() + `"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			description:  "Merge patch with wrong type, expect fail",
+			contentType:  MergePatchType,
+			patch:        `{"title": 1}`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			description:  "Malformed merge patch, expect fail",
+			contentType:  MergePatchType,
+			patch:        `{"title": `,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			description: "JSON patch with a passing test",
+			contentType: JSONPatchType,
+			patch:       `[{"op": "test", "path": "/title", "value": "title-1"}, {"op": "replace", "path": "/title", "value": "new"}, {"op": "copy", "from": "/title", "path": "/content"}]`,
+			expected:    PostInput{Title: "new", Content: "new", UserId: userId},
+		},
+		{
+			description:  "JSON patch with a failing test, expect fail",
+			contentType:  JSONPatchType,
+			patch:        `[{"op": "test", "path": "/title", "value": "stale"}, {"op": "replace", "path": "/title", "value": "new"}]`,
+			expectedCode: http.StatusConflict,
+		},
+		{
+			description:  "JSON patch replacing a missing member, expect fail",
+			contentType:  JSONPatchType,
+			patch:        `[{"op": "replace", "path": "/missing", "value": "x"}]`,
+			expectedCode: http.StatusConflict,
+		},
+		{
+			description:  "JSON patch with unknown op, expect fail",
+			contentType:  JSONPatchType,
+			patch:        `[{"op": "merge", "path": "/title", "value": "x"}]`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			description:  "JSON patch without value, expect fail",
+			contentType:  JSONPatchType,
+			patch:        `[{"op": "add", "path": "/title"}]`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			description:  "Plain JSON, expect fail",
+			contentType:  "application/json",
+			patch:        `{"title": "new"}`,
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			result, err := Patch(tc.contentType, &current, []byte(tc.patch))
+			if tc.expectedCode != 0 {
+				var httpErr *HTTPError
+				if !errors.As(err, &httpErr) || httpErr.Code != tc.expectedCode {
+					t.Fatalf("Expected a %d, got %v", tc.expectedCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*result, tc.expected) {
+				t.Fatalf("Wrong result: %+v", *result)
+			}
+			if current.Title != "title-1" {
+				t.Fatalf("Current was changed")
+			}
+		})
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		description string
+		doc         string
+		patch       string
+		expected    string
+		expectError bool
+	}{
+		{
+			description: "Add to an array",
+			doc:         `{"foo": ["bar", "baz"]}`,
+			patch:       `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			expected:    `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			description: "Append to an array",
+			doc:         `{"foo": ["bar"]}`,
+			patch:       `[{"op": "add", "path": "/foo/-", "value": ["abc"]}]`,
+			expected:    `{"foo": ["bar", ["abc"]]}`,
+		},
+		{
+			description: "Remove from an array",
+			doc:         `{"foo": ["bar", "qux", "baz"]}`,
+			patch:       `[{"op": "remove", "path": "/foo/1"}]`,
+			expected:    `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			description: "Move an array element",
+			doc:         `{"foo": ["all", "grass", "cows", "eat"]}`,
+			patch:       `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			expected:    `{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		{
+			description: "Escaped pointers",
+			doc:         `{"a/b": {"m~n": 1}}`,
+			patch:       `[{"op": "test", "path": "/a~1b/m~0n", "value": 1.0}, {"op": "remove", "path": "/a~1b/m~0n"}]`,
+			expected:    `{"a/b": {}}`,
+		},
+		{
+			description: "Test compares objects by value",
+			doc:         `{"foo": {"a": [1, 2], "b": null}}`,
+			patch:       `[{"op": "test", "path": "/foo", "value": {"b": null, "a": [1, 2]}}]`,
+			expected:    `{"foo": {"a": [1, 2], "b": null}}`,
+		},
+		{
+			description: "Index out of bounds, expect fail",
+			doc:         `{"foo": ["bar"]}`,
+			patch:       `[{"op": "add", "path": "/foo/2", "value": "x"}]`,
+			expectError: true,
+		},
+		{
+			description: "Leading zero index, expect fail",
+			doc:         `{"foo": ["bar", "baz"]}`,
+			patch:       `[{"op": "remove", "path": "/foo/01"}]`,
+			expectError: true,
+		},
+		{
+			description: "Move into itself, expect fail",
+			doc:         `{"foo": {"bar": 1}}`,
+			patch:       `[{"op": "move", "from": "/foo", "path": "/foo/bar/baz"}]`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			result, err := jsonPatch([]byte(tc.doc), []byte(tc.patch))
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("Expected an error, got %s", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, expected any
+			json.Unmarshal(result, &got)
+			json.Unmarshal([]byte(tc.expected), &expected)
+			if !jsonEqual(normalize(got), normalize(expected)) {
+				t.Fatalf("Wrong result: %s", result)
+			}
+		})
+	}
+}
+
+// normalize turns float64s into json.Numbers, which jsonEqual compares.
+func normalize(v any) any {
+	b, _ := json.Marshal(v)
+	v, _ = decodeJSON(b)
+	return v
+}
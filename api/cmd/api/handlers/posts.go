@@ -2,70 +2,440 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
+	"api/internal/validator"
+
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 )
 
+// ErrUserDeleted is returned when restoring a post of a deleted user. The
+// user has to be restored first.
+var ErrUserDeleted = errors.New("the post's user is deleted")
+
 type Post struct {
-	Id        uuid.UUID  `json:"id" db:"id"`
-	Title     string     `json:"title" db:"title"`
-	Content   string     `json:"content" db:"content"`
-	UserId    uuid.UUID  `json:"user_id" db:"user_id"`
-	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
-	UpdatedAt *time.Time `json:"updatedAt" db:"updated_at"`
+	Id    uuid.UUID `json:"id" db:"id"`
+	Title string    `json:"title" db:"title"`
+	// Slug is made from the title, and follows it. Id stays the canonical
+	// one, see PostsGetBySlugTx.
+	Slug string `json:"slug" db:"slug"`
+	// Content is written in ContentFormat, and ContentHTML is its
+	// rendering. Responses leave out one or the other when the request
+	// asks for only one, see ContentViews.
+	Content       string     `json:"content,omitempty" db:"content"`
+	ContentFormat string     `json:"content_format" db:"content_format"`
+	ContentHTML   *string    `json:"content_html,omitempty"`
+	UserId        uuid.UUID  `json:"user_id" db:"user_id"`
+	CreatedAt     time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt     *time.Time `json:"updatedAt" db:"updated_at"`
+	DeletedAt     *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+	Version       int64      `json:"version" db:"version"`
+	// Status is one of PostStatuses. PublishAt is when a scheduled post
+	// goes live, and PublishedAt when the post first did.
+	Status      string     `json:"status" db:"status"`
+	PublishAt   *time.Time `json:"publishAt" db:"publish_at"`
+	PublishedAt *time.Time `json:"publishedAt" db:"published_at"`
+	Tags        []string   `json:"tags"`
+	// Reactions counts the reactions to the post by kind. Reacted lists
+	// the kinds the user making the request reacted with, and is null when
+	// the request doesn't name one, see ContextWithViewer.
+	Reactions map[string]int `json:"reactions"`
+	Reacted   []string       `json:"reacted"`
 }
 
+// The statuses of a post. Only published posts are listed and found by
+// everyone, the others by admins only, and scheduled ones are published by
+// PostsPublishTx once their publish_at has come.
+const (
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+	PostPublished = "published"
+	PostArchived  = "archived"
+)
+
+var PostStatuses = []string{PostDraft, PostScheduled, PostPublished, PostArchived}
+
+// PostStatusFilter keeps the posts of one status, published for everyone
+// but admins.
+var PostStatusFilter = Filter{Field{"status", StringField}, "="}
+
+// PostInput is a new post, or the new state of one. Tags are normalized to
+// slugs, see NormalizeTags. Updates without tags keep those the post has,
+// and those without a status or a content format keep theirs. New posts
+// are published unless they say otherwise, and plain text. PublishAt is
+// required for scheduled posts and ignored for the others.
 type PostInput struct {
-	Title   string    `json:"title" db:"title"`
-	Content string    `json:"content" db:"content"`
-	UserId  uuid.UUID `json:"user_id" db:"user_id"`
+	Title         string     `json:"title" db:"title"`
+	Content       string     `json:"content" db:"content"`
+	UserId        uuid.UUID  `json:"user_id" db:"user_id"`
+	Tags          []string   `json:"tags,omitempty"`
+	Status        string     `json:"status,omitempty" db:"status"`
+	PublishAt     *time.Time `json:"publish_at,omitempty" db:"publish_at"`
+	ContentFormat string     `json:"content_format,omitempty" db:"content_format"`
+}
+
+// Input is the part of the post that clients can change, for PATCH.
+func (p *Post) Input() *PostInput {
+	return &PostInput{Title: p.Title, Content: p.Content, UserId: p.UserId, Tags: slices.Clone(p.Tags), Status: p.Status, PublishAt: p.PublishAt, ContentFormat: p.ContentFormat}
 }
 
-const POST_FIELDS = "id, title, content, user_id, created_at, updated_at"
+// Validate checks the input before it is written.
+func (in *PostInput) Validate() validator.Validator {
+	v := validator.Validator{}
+	v.CheckField(validator.NotBlank(in.Title), "title", "must not be blank")
+	v.CheckField(in.UserId != uuid.Nil, "user_id", "must be set")
+	if err := checkTags(NormalizeTags(in.Tags)); err != nil {
+		v.AddFieldError("tags", err.Error())
+	}
+	if in.Status != "" {
+		v.CheckField(validator.In(in.Status, PostStatuses...), "status", "must be draft, scheduled, published or archived")
+	}
+	v.CheckField(in.Status != PostScheduled || in.PublishAt != nil, "publish_at", "must be set for scheduled posts")
+	if in.ContentFormat != "" {
+		v.CheckField(validator.In(in.ContentFormat, ContentFormats...), "content_format", "must be plain or markdown")
+	}
+	return v
+}
+
+// setStatus moves the post to status, or keeps its status when status is
+// empty. publish_at is only kept while the post is scheduled, and
+// published_at is set at the first time the post is published. The SQL of
+// PostsUpdateTx and PostsPublishTx does the same.
+func (p *Post) setStatus(status string, publishAt *time.Time, at time.Time) {
+	if status != "" {
+		p.Status = status
+	}
+	if p.Status != PostScheduled {
+		p.PublishAt = nil
+	} else if publishAt != nil {
+		t := publishAt.UTC().Truncate(time.Microsecond)
+		p.PublishAt = &t
+	}
+	if p.Status == PostPublished && p.PublishedAt == nil {
+		p.PublishedAt = &at
+	}
+}
+
+// newStatus returns the status, publish_at and published_at of a post
+// created from the input at the given time.
+func (in *PostInput) newStatus(at time.Time) *Post {
+	p := &Post{Status: PostPublished}
+	p.setStatus(in.Status, in.PublishAt, at)
+	return p
+}
+
+// contentFormat is the content format of a post created from the input.
+func (in *PostInput) contentFormat() string {
+	if in.ContentFormat == "" {
+		return ContentPlain
+	}
+	return in.ContentFormat
+}
+
+const POST_FIELDS = "id, title, slug, content, content_format, user_id, created_at, updated_at, deleted_at, version, status, publish_at, published_at"
+
+// PostListSpec declares the filters and sorts of GET /api/posts.
+var PostListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"user_id":        {Field{"user_id", UUIDField}, "="},
+		"title_contains": {Field{"title", StringField}, "contains"},
+		"created_after":  {Field{"created_at", TimeField}, ">"},
+		"created_before": {Field{"created_at", TimeField}, "<"},
+		"status":         PostStatusFilter,
+	},
+	Sorts: map[string]Field{
+		"created_at": {"created_at", TimeField},
+		"title":      {"title", StringField},
+	},
+	SoftDelete: true,
+}
+
+// dest returns the scan destinations for POST_FIELDS.
+func (p *Post) dest() []any {
+	return []any{&p.Id, &p.Title, &p.Slug, &p.Content, &p.ContentFormat, &p.UserId, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt, &p.Version, &p.Status, &p.PublishAt, &p.PublishedAt}
+}
+
+// PostsGetBySlugTx finds the live post that has or had the slug. Posts
+// that had it have another one now, which is how callers tell old slugs.
+func PostsGetBySlugTx(tx *sql.Tx, slug string) (*Post, error) {
+	id, ok, err := postSlugs.lookupTx(tx, slug)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return PostsGetTx(tx, id)
+}
 
 func PostsGetTx(tx *sql.Tx, id uuid.UUID) (*Post, error) {
+	post := Post{}
+	s := fmt.Sprintf(`SELECT %s FROM posts WHERE id=$1 AND deleted_at IS NULL`, POST_FIELDS)
+	err := tx.QueryRow(s, id).Scan(post.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &post, postsLoadTx(tx, &post)
+}
+
+// PostsGetWithDeletedTx is PostsGetTx that also finds soft deleted posts.
+func PostsGetWithDeletedTx(tx *sql.Tx, id uuid.UUID) (*Post, error) {
 	post := Post{}
 	s := fmt.Sprintf(`SELECT %s FROM posts WHERE id=$1`, POST_FIELDS)
-	err := tx.QueryRow(s, id).Scan(&post.Id, &post.Title, &post.Content, &post.UserId, &post.CreatedAt, &post.UpdatedAt)
+	err := tx.QueryRow(s, id).Scan(post.dest()...)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	return &post, err
+	if err != nil {
+		return nil, err
+	}
+	return &post, postsLoadTx(tx, &post)
 }
 
-func PostsCreateTx(tx *sql.Tx, input *PostInput) (*Post, error) {
+// userDeleted is selected along with a written post, to refuse posts of
+// soft deleted users, which fk_user lets through.
+const userDeleted = `EXISTS (SELECT 1 FROM users WHERE users.id = posts.user_id AND users.deleted_at IS NOT NULL)`
+
+// errUserDeleted reports a soft deleted user like fk_user does a missing one.
+func errUserDeleted(id uuid.UUID) error {
+	return &ConstraintError{Kind: ForeignKeyViolation, Field: "user_id", Err: fmt.Errorf("user %s is deleted", id)}
+}
+
+// PostsCreateTx creates the post along with its first revision, by
+// authorId, its slug and its tags. A missing or deleted user is a
+// ConstraintError on user_id.
+func PostsCreateTx(tx *sql.Tx, input *PostInput, authorId *uuid.UUID) (*Post, error) {
 	post := &Post{}
-	s := fmt.Sprintf(`INSERT INTO posts (title, content, user_id) VALUES ($1, $2, $3) RETURNING %s`, POST_FIELDS)
-	err := tx.QueryRow(s, input.Title, input.Content, input.UserId).Scan(&post.Id, &post.Title, &post.Content, &post.UserId, &post.CreatedAt, &post.UpdatedAt)
-	return post, err
+	var deleted bool
+	createdAt := now()
+	status := input.newStatus(createdAt)
+	id := uuid.New()
+	slugs := postSlugs.slugger(tx)
+	err := slugs.claimTx(func() error {
+		slug, err := slugs.next(input.Title, id)
+		if err != nil {
+			return err
+		}
+		s := fmt.Sprintf(`INSERT INTO posts (id, title, slug, content, content_format, user_id, created_at, status, publish_at, published_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING %s, %s`, POST_FIELDS, userDeleted)
+		err = tx.QueryRow(s, id, input.Title, slug, input.Content, input.contentFormat(), input.UserId, createdAt, status.Status, status.PublishAt, status.PublishedAt).Scan(append(post.dest(), &deleted)...)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			return errUserDeleted(input.UserId)
+		}
+		return slugs.recordTx(createdAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = postsSetTagsTx(tx, post, input.Tags)
+	if err != nil {
+		return nil, err
+	}
+	post.Reactions = map[string]int{}
+	return post, postsRecordRevisionTx(tx, post, authorId)
+}
+
+// PostsCreateManyTx creates the posts, their first revisions by authorId
+// and their slugs with multi-row inserts, then their tags, and returns them
+// in the order of inputs. A missing or deleted user is a ConstraintError on
+// user_id.
+func PostsCreateManyTx(tx *sql.Tx, inputs []*PostInput, authorId *uuid.UUID) ([]*Post, error) {
+	posts := make([]*Post, 0, len(inputs))
+	slugs := postSlugs.slugger(tx)
+	for chunk := range slices.Chunk(inputs, insertChunkRows) {
+		createdAt := now()
+		ids := make([]uuid.UUID, len(chunk))
+		index := make(map[uuid.UUID]int, len(chunk))
+		for i := range chunk {
+			ids[i] = uuid.New()
+			index[ids[i]] = i
+		}
+		created := make([]*Post, len(chunk))
+		err := slugs.claimTx(func() error {
+			args := make([]any, 0, 10*len(chunk))
+			for i, input := range chunk {
+				slug, err := slugs.next(input.Title, ids[i])
+				if err != nil {
+					return err
+				}
+				status := input.newStatus(createdAt)
+				args = append(args, ids[i], input.Title, slug, input.Content, input.contentFormat(), input.UserId, createdAt, status.Status, status.PublishAt, status.PublishedAt)
+			}
+
+			s := fmt.Sprintf(`INSERT INTO posts (id, title, slug, content, content_format, user_id, created_at, status, publish_at, published_at) VALUES %s RETURNING %s, %s`, valuesList(len(chunk), 10), POST_FIELDS, userDeleted)
+			rows, err := tx.Query(s, args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			// RETURNING doesn't keep the order of VALUES.
+			for rows.Next() {
+				post := Post{}
+				var deleted bool
+				err := rows.Scan(append(post.dest(), &deleted)...)
+				if err == nil && deleted {
+					err = errUserDeleted(post.UserId)
+				}
+				if err != nil {
+					return err
+				}
+				created[index[post.Id]] = &post
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			// Postgres runs one query at a time on the connection of the tx.
+			rows.Close()
+			return slugs.recordTx(createdAt)
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = postsRecordFirstRevisionsTx(tx, created, authorId)
+		if err != nil {
+			return nil, err
+		}
+		for i, post := range created {
+			post.Tags = []string{}
+			post.Reactions = map[string]int{}
+			if len(chunk[i].Tags) == 0 {
+				continue
+			}
+			err = postsSetTagsTx(tx, post, chunk[i].Tags)
+			if err != nil {
+				return nil, err
+			}
+		}
+		posts = append(posts, created...)
+	}
+	return posts, nil
 }
 
-func PostsUpdateTx(tx *sql.Tx, id uuid.UUID, input *PostInput) (*Post, error) {
+// postStatusUpdate is Post.setStatus for PostsUpdateTx, with the new
+// status, which may be empty, as $6 and publish_at as $7. The right hand
+// sides all see the row as it was.
+const postStatusUpdate = `status=COALESCE(NULLIF($6, ''), status),
+	publish_at=CASE WHEN COALESCE(NULLIF($6, ''), status) = 'scheduled' THEN COALESCE($7, publish_at) END,
+	published_at=CASE WHEN COALESCE(NULLIF($6, ''), status) = 'published' THEN COALESCE(published_at, $4) ELSE published_at END`
+
+// PostsUpdateTx updates the post, and records a revision by authorId when
+// the title or content changed. A new title makes a new slug, unless it
+// makes the same. The tags are replaced when input has any, even an empty
+// list. A missing or deleted user is a ConstraintError on user_id.
+func PostsUpdateTx(tx *sql.Tx, id uuid.UUID, input *PostInput, authorId *uuid.UUID) (*Post, error) {
 	post := &Post{}
-	s := fmt.Sprintf(`UPDATE posts SET title=$1, content=$2, user_id=$3 WHERE id = $4 RETURNING %s`, POST_FIELDS)
-	err := tx.QueryRow(s, input.Title, input.Content, input.UserId, id).Scan(&post.Id, &post.Title, &post.Content, &post.UserId, &post.CreatedAt, &post.UpdatedAt)
+	var deleted bool
+	var publishAt *time.Time
+	if input.PublishAt != nil {
+		t := input.PublishAt.UTC().Truncate(time.Microsecond)
+		publishAt = &t
+	}
+	s := fmt.Sprintf(`UPDATE posts SET title=$1, content=$2, content_format=COALESCE(NULLIF($8, ''), content_format), user_id=$3, updated_at=$4, version=version+1, %s WHERE id = $5 AND deleted_at IS NULL RETURNING %s, %s`, postStatusUpdate, POST_FIELDS, userDeleted)
+	err := tx.QueryRow(s, input.Title, input.Content, input.UserId, now(), id, input.Status, publishAt, input.ContentFormat).Scan(append(post.dest(), &deleted)...)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	return post, err
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, errUserDeleted(input.UserId)
+	}
+	post.Slug, err = postSlugs.slugger(tx).renameTx(post.Id, post.Slug, post.Title)
+	if err != nil {
+		return nil, err
+	}
+	if input.Tags != nil {
+		err = postsSetTagsTx(tx, post, input.Tags)
+	} else {
+		err = postsLoadTagsTx(tx, post)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = postsLoadReactionsTx(tx, post)
+	if err != nil {
+		return nil, err
+	}
+	return post, postsRecordRevisionTx(tx, post, authorId)
 }
 
+// PostsDeleteTx soft deletes the post.
 func PostsDeleteTx(tx *sql.Tx, id uuid.UUID) (*Post, error) {
 	post := &Post{}
-	s := fmt.Sprintf(`DELETE FROM posts WHERE id=$1 RETURNING %s`, POST_FIELDS)
-	err := tx.QueryRow(s, id).Scan(&post.Id, &post.Title, &post.Content, &post.UserId, &post.CreatedAt, &post.UpdatedAt)
+	s := fmt.Sprintf(`UPDATE posts SET deleted_at=$1, version=version+1 WHERE id=$2 AND deleted_at IS NULL RETURNING %s`, POST_FIELDS)
+	err := tx.QueryRow(s, now(), id).Scan(post.dest()...)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	return post, postsLoadTx(tx, post)
+}
+
+// PostsRestoreTx undoes PostsDeleteTx. Restoring a post that isn't deleted
+// changes nothing.
+func PostsRestoreTx(tx *sql.Tx, id uuid.UUID) (*Post, error) {
+	post, err := PostsGetWithDeletedTx(tx, id)
+	if err != nil || post == nil || post.DeletedAt == nil {
+		return post, err
+	}
+
+	var userDeletedAt *time.Time
+	err = tx.QueryRow(`SELECT deleted_at FROM users WHERE id=$1`, post.UserId).Scan(&userDeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if userDeletedAt != nil {
+		return nil, ErrUserDeleted
+	}
+
+	s := fmt.Sprintf(`UPDATE posts SET deleted_at=NULL, version=version+1 WHERE id=$1 RETURNING %s`, POST_FIELDS)
+	err = tx.QueryRow(s, id).Scan(post.dest()...)
 	return post, err
 }
 
-func PostsGetAllTx(tx *sql.Tx) ([]*Post, error) {
-	s := fmt.Sprintf(`SELECT %s FROM posts ORDER BY created_at DESC`, POST_FIELDS)
-	rows, err := tx.Query(s)
+// PostsPurgeTx hard deletes the posts soft deleted before the given time.
+func PostsPurgeTx(tx *sql.Tx, before time.Time) (int64, error) {
+	res, err := tx.Exec(`DELETE FROM posts WHERE deleted_at < $1`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PostsPublishTx publishes the live scheduled posts whose publish_at is not
+// after the given time, and returns how many. The update is a single
+// statement, so schedulers running on several instances at once publish
+// every post once: those that lose the race for a row find it published
+// when they get to it and leave it alone.
+func PostsPublishTx(tx *sql.Tx, before time.Time) (int64, error) {
+	res, err := tx.Exec(`UPDATE posts SET status='published', publish_at=NULL, published_at=COALESCE(published_at, $1), version=version+1
+		WHERE status='scheduled' AND publish_at <= $2 AND deleted_at IS NULL`, now(), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PostsGetAllTx returns one page of posts, filtered and sorted by q.
+func PostsGetAllTx(tx *sql.Tx, q *ListQuery) (*List[Post], error) {
+	where, args, err := q.where(1)
+	if err != nil {
+		return nil, err
+	}
+	if !q.includeDeleted() {
+		where += " AND deleted_at IS NULL"
+	}
+	s := fmt.Sprintf(`SELECT %s FROM posts WHERE %s ORDER BY %s LIMIT %d`, POST_FIELDS, where, q.orderBy(), q.limit()+1)
+	rows, err := tx.Query(s, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -74,11 +444,59 @@ func PostsGetAllTx(tx *sql.Tx) ([]*Post, error) {
 	posts := []*Post{}
 	for rows.Next() {
 		post := Post{}
-		err := rows.Scan(&post.Id, &post.Title, &post.Content, &post.UserId, &post.CreatedAt, &post.UpdatedAt)
+		err := rows.Scan(post.dest()...)
 		if err != nil {
 			return nil, err
 		}
 		posts = append(posts, &post)
 	}
-	return posts, err
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Postgres runs one query at a time on the connection of the tx.
+	rows.Close()
+	err = postsLoadTx(tx, posts...)
+	if err != nil {
+		return nil, err
+	}
+	return newList(posts, q, (*Post).field), nil
+}
+
+// PostsCountTx counts the posts matching the filters of q, on every page.
+func PostsCountTx(tx *sql.Tx, q *ListQuery) (int, error) {
+	where, args := q.filterWhere(1)
+	if !q.includeDeleted() {
+		where += " AND deleted_at IS NULL"
+	}
+	var count int
+	err := tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM posts WHERE %s`, where), args...).Scan(&count)
+	return count, err
+}
+
+// ETag identifies the version of the post, for conditional requests,
+// followed by a hash of its reactions after a dot. Reactions don't bump the
+// version, and Reacted depends on the viewer, but responses carry both, so
+// If-None-Match must not match once they change. VersionOfETag leaves the
+// hash out, for If-Match.
+func (p *Post) ETag() string {
+	return fmt.Sprintf(`"%d.%s"`, p.Version, p.reactionsHash())
+}
+
+// field returns the value of a column, for list queries.
+func (p *Post) field(column string) any {
+	switch column {
+	case "id":
+		return p.Id
+	case "title":
+		return p.Title
+	case "user_id":
+		return p.UserId
+	case "created_at":
+		return p.CreatedAt
+	case "status":
+		return p.Status
+	case "tags":
+		return p.Tags
+	}
+	panic("unknown post field " + column)
 }
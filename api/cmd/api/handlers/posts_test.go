@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -45,10 +46,11 @@ func TestPostsGetAllTx(t *testing.T) {
 
 			ctx := context.Background()
 			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
-				posts, err := PostsGetAllTx(tx)
+				list, err := PostsGetAllTx(tx, nil)
 				if err != nil {
 					return err
 				}
+				posts := list.Data
 				if len(tc.expectedPosts) != len(posts) {
 					return fmt.Errorf("Wrong len:%d!=%d", len(tc.expectedPosts), len(posts))
 				}
@@ -157,8 +159,8 @@ func TestPostsCreateTx(t *testing.T) {
 		{
 			description: "Create 2 posts",
 			postsToCreate: []*PostInput{
-				{"one", "1", db.Fixture.UserId1},
-				{"two", "2", db.Fixture.UserId2},
+				{"one", "1", db.Fixture.UserId1, nil, "", nil, ""},
+				{"two", "2", db.Fixture.UserId2, nil, "", nil, ""},
 			},
 			expectedPosts: []*Post{
 				{
@@ -186,7 +188,7 @@ func TestPostsCreateTx(t *testing.T) {
 		{
 			description: "Create post on non existing user, expect fail",
 			postsToCreate: []*PostInput{
-				{"one", "1", id},
+				{"one", "1", id, nil, "", nil, ""},
 			},
 			expectError: true,
 		},
@@ -204,7 +206,7 @@ func TestPostsCreateTx(t *testing.T) {
 			ctx := context.Background()
 			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
 				for _, u := range tc.postsToCreate {
-					u, err := PostsCreateTx(tx, u)
+					u, err := PostsCreateTx(tx, u, nil)
 					if err != nil {
 						return err
 					}
@@ -253,7 +255,7 @@ func TestPostsUpdateTx(t *testing.T) {
 		{
 			description: "Update 1 post",
 			postsToUpdate: []*updateInput{
-				{db.Fixture.PostId2, PostInput{"title-2-updated", "content-2-updated", db.Fixture.UserId2}},
+				{db.Fixture.PostId2, PostInput{"title-2-updated", "content-2-updated", db.Fixture.UserId2, nil, "", nil, ""}},
 			},
 			expectedPosts: []*Post{
 				{
@@ -266,14 +268,14 @@ func TestPostsUpdateTx(t *testing.T) {
 		{
 			description: "Update non-existing user on existing post",
 			postsToUpdate: []*updateInput{
-				{db.Fixture.PostId2, PostInput{"title-2-updated", "content-2-updated", id}},
+				{db.Fixture.PostId2, PostInput{"title-2-updated", "content-2-updated", id, nil, "", nil, ""}},
 			},
 			expectError: true,
 		},
 		{
 			description: "Update non-existing post",
 			postsToUpdate: []*updateInput{
-				{id, PostInput{"title-2-updated", "content-2-updated", db.Fixture.UserId2}},
+				{id, PostInput{"title-2-updated", "content-2-updated", db.Fixture.UserId2, nil, "", nil, ""}},
 			},
 			expectError: true,
 		},
@@ -291,7 +293,7 @@ func TestPostsUpdateTx(t *testing.T) {
 			ctx := context.Background()
 			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
 				for _, u := range tc.postsToUpdate {
-					u, err := PostsUpdateTx(tx, u.id, &u.PostInput)
+					u, err := PostsUpdateTx(tx, u.id, &u.PostInput, nil)
 					if err != nil {
 						return err
 					}
@@ -390,3 +392,225 @@ func TestPostsDeleteTx(t *testing.T) {
 		})
 	}
 }
+
+func TestPostsCountTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	id, _ := uuid.Parse("4a2b9c00-9daf-11ed-93ce-0242ac120001")
+	tests := []struct {
+		description   string
+		userId        uuid.UUID
+		limit         int
+		expectedCount int
+		expectedLen   int
+	}{
+		{
+			description:   "Count posts of a user across pages",
+			userId:        db.Fixture.UserId1,
+			limit:         1,
+			expectedCount: 3,
+			expectedLen:   1,
+		},
+		{
+			description:   "Count posts of a user with no posts",
+			userId:        id,
+			limit:         10,
+			expectedCount: 0,
+			expectedLen:   0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			_, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			ctx := context.Background()
+			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+				for _, title := range []string{"one", "two"} {
+					_, err := PostsCreateTx(tx, &PostInput{title, "content", db.Fixture.UserId1, nil, "", nil, ""}, nil)
+					if err != nil {
+						return err
+					}
+				}
+
+				q := &ListQuery{Limit: tc.limit}
+				q.Where(PostListSpec.Filters["user_id"], tc.userId)
+				count, err := PostsCountTx(tx, q)
+				if err != nil {
+					return err
+				}
+				if count != tc.expectedCount {
+					return fmt.Errorf("Wrong count:%d!=%d", count, tc.expectedCount)
+				}
+				list, err := PostsGetAllTx(tx, q)
+				if err != nil {
+					return err
+				}
+				if len(list.Data) != tc.expectedLen {
+					return fmt.Errorf("Wrong len:%d!=%d", len(list.Data), tc.expectedLen)
+				}
+				for _, p := range list.Data {
+					if p.UserId != tc.userId {
+						return fmt.Errorf("UserId mismatch")
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestPostsPurgeTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	tests := []struct {
+		description   string
+		postsToDelete []uuid.UUID
+		purgeBefore   time.Duration
+		expectedPurge int64
+	}{
+		{
+			description:   "Purge deleted posts",
+			postsToDelete: []uuid.UUID{db.Fixture.PostId1, db.Fixture.PostId2},
+			purgeBefore:   time.Hour,
+			expectedPurge: 2,
+		},
+		{
+			description:   "Keep posts deleted within the retention",
+			postsToDelete: []uuid.UUID{db.Fixture.PostId1},
+			purgeBefore:   -time.Hour,
+			expectedPurge: 0,
+		},
+		{
+			description:   "Keep live posts",
+			purgeBefore:   time.Hour,
+			expectedPurge: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			_, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			ctx := context.Background()
+			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+				for _, id := range tc.postsToDelete {
+					p, err := PostsDeleteTx(tx, id)
+					if err != nil {
+						return err
+					}
+					if p == nil || p.DeletedAt == nil {
+						return fmt.Errorf("Post not deleted")
+					}
+				}
+
+				n, err := PostsPurgeTx(tx, time.Now().Add(tc.purgeBefore))
+				if err != nil {
+					return err
+				}
+				if n != tc.expectedPurge {
+					return fmt.Errorf("Wrong purged:%d!=%d", n, tc.expectedPurge)
+				}
+				for _, id := range tc.postsToDelete {
+					p, err := PostsGetWithDeletedTx(tx, id)
+					if err != nil {
+						return err
+					}
+					if (p == nil) != (tc.expectedPurge > 0) {
+						return fmt.Errorf("Post purge mismatch")
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestPostsPublishTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	tests := []struct {
+		description     string
+		publishAt       time.Duration
+		deleted         bool
+		expectedPublish int64
+	}{
+		{
+			description:     "Publish a due post",
+			publishAt:       -time.Minute,
+			expectedPublish: 1,
+		},
+		{
+			description:     "Keep a post scheduled for later",
+			publishAt:       time.Hour,
+			expectedPublish: 0,
+		},
+		{
+			description:     "Keep a deleted post",
+			publishAt:       -time.Minute,
+			deleted:         true,
+			expectedPublish: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			_, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			ctx := context.Background()
+			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+				publishAt := time.Now().Add(tc.publishAt)
+				p, err := PostsCreateTx(tx, &PostInput{"title", "content", db.Fixture.UserId1, nil, PostScheduled, &publishAt, ""}, nil)
+				if err != nil {
+					return err
+				}
+				if tc.deleted {
+					_, err = PostsDeleteTx(tx, p.Id)
+					if err != nil {
+						return err
+					}
+				}
+
+				n, err := PostsPublishTx(tx, time.Now())
+				if err != nil {
+					return err
+				}
+				if n != tc.expectedPublish {
+					return fmt.Errorf("Wrong published:%d!=%d", n, tc.expectedPublish)
+				}
+				p, err = PostsGetWithDeletedTx(tx, p.Id)
+				if err != nil {
+					return err
+				}
+				if (p.Status == PostPublished) != (tc.expectedPublish > 0) || (p.PublishedAt != nil) != (tc.expectedPublish > 0) {
+					return fmt.Errorf("Wrong status: %s %v", p.Status, p.PublishedAt)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"api/internal/validator"
+
+	"github.com/google/uuid"
+)
+
+type FieldType int
+
+const (
+	StringField FieldType = iota
+	TimeField
+	UUIDField
+	// EmailField is a StringField holding normalized emails, which filters
+	// check and normalize the same way.
+	EmailField
+	// FloatField is a computed score, such as the rank of a search result,
+	// which lists can sort on but not filter by.
+	FloatField
+	IntField
+	// TagsField is the tags of a post, which lists can filter by with a
+	// list of slugs but not sort on.
+	TagsField
+)
+
+// Field is a column that list queries can filter or sort on. Only declared
+// fields ever make it into SQL, values are always bound as arguments.
+type Field struct {
+	Column string
+	Type   FieldType
+}
+
+// Filter is a query parameter that narrows a list, comparing a field with
+// Op, one of "=", "<", "<=", ">" or "contains" (case-insensitive
+// substring). TagsField filters take "any" or "all" of their slugs.
+type Filter struct {
+	Field
+	Op string
+}
+
+// ListSpec declares what a collection can be filtered and sorted by, keyed
+// by query parameter and by sort name. Collections with SoftDelete also
+// take include_deleted. DefaultSort replaces newest first when the query
+// has no sort.
+type ListSpec struct {
+	Filters     map[string]Filter
+	Sorts       map[string]Field
+	SoftDelete  bool
+	DefaultSort []SortKey
+}
+
+// Without returns a copy of the spec without the given filters, for routes
+// that fix them some other way.
+func (s ListSpec) Without(params ...string) ListSpec {
+	filters := maps.Clone(s.Filters)
+	for _, p := range params {
+		delete(filters, p)
+	}
+	return ListSpec{Filters: filters, Sorts: s.Sorts, SoftDelete: s.SoftDelete, DefaultSort: s.DefaultSort}
+}
+
+// ListQuery selects one page of a filtered and sorted collection. Pages are
+// found by keyset on the sort fields plus the id instead of OFFSET, so they
+// stay stable while rows are being added.
+type ListQuery struct {
+	Limit          int
+	Cursor         *Cursor
+	Filters        []Condition
+	Sort           []SortKey
+	IncludeDeleted bool
+}
+
+type Condition struct {
+	Filter
+	Value any
+}
+
+type SortKey struct {
+	Field
+	Desc bool
+}
+
+var (
+	idField      = Field{"id", UUIDField}
+	defaultSort  = []SortKey{{Field{"created_at", TimeField}, true}}
+	reservedKeys = []string{"limit", "cursor", "sort"}
+)
+
+// ParseListQuery reads the page, filters and sort from the query string,
+// checking them against spec. Limits above maxLimit are lowered to it.
+func ParseListQuery(values url.Values, spec ListSpec, maxLimit int) (*ListQuery, error) {
+	q := &ListQuery{Limit: min(DefaultPageSize, maxLimit)}
+	v := validator.Validator{}
+
+	if s := values.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		v.CheckField(err == nil && limit > 0, "limit", "must be a positive integer")
+		q.Limit = min(limit, maxLimit)
+	}
+
+	if s := values.Get("sort"); s != "" {
+		names := strings.Split(s, ",")
+		seen := []string{}
+		for _, name := range names {
+			name, desc := strings.CutPrefix(name, "-")
+			field, ok := spec.Sorts[name]
+			v.CheckField(ok, "sort", fmt.Sprintf("cannot sort by %q", name))
+			seen = append(seen, name)
+			q.Sort = append(q.Sort, SortKey{field, desc})
+		}
+		v.CheckField(validator.NoDuplicates(seen), "sort", "must not repeat a field")
+	} else {
+		q.Sort = slices.Clone(spec.DefaultSort)
+	}
+
+	if s := values.Get("include_deleted"); s != "" && spec.SoftDelete {
+		include, err := strconv.ParseBool(s)
+		v.CheckField(err == nil, "include_deleted", "must be true or false")
+		q.IncludeDeleted = include
+	}
+
+	for key := range values {
+		if key == "include_deleted" && spec.SoftDelete {
+			continue
+		}
+		filter, ok := spec.Filters[key]
+		if !ok {
+			v.CheckField(validator.In(key, reservedKeys...), key, "unknown parameter")
+			continue
+		}
+		value, err := parseValue(filter.Type, values.Get(key))
+		if err != nil {
+			v.AddFieldError(key, err.Error())
+			continue
+		}
+		q.Filters = append(q.Filters, Condition{filter, value})
+	}
+	// Map order is random, keep the SQL stable.
+	slices.SortFunc(q.Filters, func(a, b Condition) int {
+		return strings.Compare(a.Column+a.Op, b.Column+b.Op)
+	})
+
+	if s := values.Get("cursor"); s != "" && !v.HasErrors() {
+		c, err := DecodeCursor(s)
+		if err == nil {
+			q.Cursor = c
+			_, err = q.cursorValues()
+		}
+		v.CheckField(err == nil, "cursor", "is invalid or was made with a different sort")
+	}
+
+	if v.HasErrors() {
+		return nil, NewValidationError(v)
+	}
+	return q, nil
+}
+
+func parseValue(t FieldType, s string) (any, error) {
+	switch t {
+	case TimeField:
+		v, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("must be an RFC 3339 time")
+		}
+		return v.UTC(), nil
+	case UUIDField:
+		v, err := uuid.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("must be a UUID")
+		}
+		return v, nil
+	case IntField:
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return v, nil
+	case FloatField:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return v, nil
+	case EmailField:
+		if !validator.IsEmail(strings.TrimSpace(s)) {
+			return nil, fmt.Errorf("must be a valid email address")
+		}
+		return normalizeEmail(s), nil
+	default:
+		if !validator.NotBlank(s) {
+			return nil, fmt.Errorf("must not be blank")
+		}
+		return s, nil
+	}
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case uuid.UUID:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (q *ListQuery) limit() int {
+	if q == nil || q.Limit < 1 {
+		return DefaultPageSize
+	}
+	return q.Limit
+}
+
+func (q *ListQuery) includeDeleted() bool {
+	return q != nil && q.IncludeDeleted
+}
+
+func (q *ListQuery) backward() bool {
+	return q != nil && q.Cursor != nil && q.Cursor.Backward
+}
+
+// sortKeys is the requested sort with the id appended, which makes the
+// order total so that the keyset never skips or repeats rows.
+func (q *ListQuery) sortKeys() []SortKey {
+	keys := defaultSort
+	if q != nil && len(q.Sort) > 0 {
+		keys = q.Sort
+	}
+	return append(slices.Clone(keys), SortKey{idField, keys[len(keys)-1].Desc})
+}
+
+// sortParam is the sort as given in the query string. Cursors record it so
+// they can't be replayed against a different sort.
+func (q *ListQuery) sortParam() string {
+	names := []string{}
+	for _, k := range q.sortKeys() {
+		name := k.Column
+		if k.Desc {
+			name = "-" + name
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+// cursorValues parses the cursor values, one for each sort key.
+func (q *ListQuery) cursorValues() ([]any, error) {
+	if q == nil || q.Cursor == nil {
+		return nil, nil
+	}
+	keys := q.sortKeys()
+	if q.Cursor.Sort != q.sortParam() || len(q.Cursor.Values) != len(keys) {
+		return nil, fmt.Errorf("cursor does not match the sort")
+	}
+	values := make([]any, len(keys))
+	for i, k := range keys {
+		v, err := parseValue(k.Type, q.Cursor.Values[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// where returns the filter and keyset conditions, numbering the arguments
+// from argn.
+func (q *ListQuery) where(argn int) (string, []any, error) {
+	return q.conditions(argn, true)
+}
+
+// filterWhere leaves out the keyset, for counting the rows of every page.
+func (q *ListQuery) filterWhere(argn int) (string, []any) {
+	where, args, _ := q.conditions(argn, false)
+	return where, args
+}
+
+func (q *ListQuery) conditions(argn int, keyset bool) (string, []any, error) {
+	conds := []string{}
+	args := []any{}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", argn+len(args)-1)
+	}
+
+	if q != nil {
+		for _, f := range q.Filters {
+			if f.Type == TagsField {
+				conds = append(conds, tagCondition(f.Op, f.Value.([]string), arg))
+				continue
+			}
+			if f.Op == "contains" {
+				pattern := "%" + escapeLike(strings.ToLower(f.Value.(string))) + "%"
+				conds = append(conds, fmt.Sprintf(`LOWER(%s) LIKE %s ESCAPE '\'`, f.Column, arg(pattern)))
+				continue
+			}
+			conds = append(conds, fmt.Sprintf("%s %s %s", f.Column, f.Op, arg(f.Value)))
+		}
+	}
+
+	values, err := q.cursorValues()
+	if err != nil {
+		return "", nil, err
+	}
+	if keyset && values != nil {
+		// (a, b, id) after (x, y, z) expands to
+		// a > x OR (a = x AND b > y) OR (a = x AND b = y AND id > z)
+		// with the comparison flipped for descending keys, which row values
+		// can't express when the directions are mixed.
+		keys := q.sortKeys()
+		ors := []string{}
+		for i, k := range keys {
+			ands := []string{}
+			for j := range i {
+				ands = append(ands, fmt.Sprintf("%s = %s", keys[j].Column, arg(values[j])))
+			}
+			op := ">"
+			if k.Desc != q.backward() {
+				op = "<"
+			}
+			ands = append(ands, fmt.Sprintf("%s %s %s", k.Column, op, arg(values[i])))
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		}
+		conds = append(conds, "("+strings.Join(ors, " OR ")+")")
+	}
+
+	if len(conds) == 0 {
+		return "TRUE", args, nil
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// orderBy is the ORDER BY for the paging direction. The query must fetch
+// limit()+1 rows so that newList can tell whether there is another page.
+func (q *ListQuery) orderBy() string {
+	terms := []string{}
+	for _, k := range q.sortKeys() {
+		dir := "ASC"
+		if k.Desc != q.backward() {
+			dir = "DESC"
+		}
+		terms = append(terms, k.Column+" "+dir)
+	}
+	return strings.Join(terms, ", ")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// withDefaultSort returns q sorted by the DefaultSort of spec when it has no
+// sort of its own, for repositories that take queries which didn't go
+// through ParseListQuery.
+func (q *ListQuery) withDefaultSort(spec ListSpec) *ListQuery {
+	if q != nil && len(q.Sort) > 0 {
+		return q
+	}
+	sorted := ListQuery{}
+	if q != nil {
+		sorted = *q
+	}
+	sorted.Sort = spec.DefaultSort
+	return &sorted
+}
+
+// Where adds a condition that the query string can't override, such as
+// the user of GET /api/users/:id/posts.
+func (q *ListQuery) Where(filter Filter, value any) {
+	q.Filters = append(q.Filters, Condition{filter, value})
+}
+
+// The rest mirrors the SQL for the memory store, reading row fields by
+// column name.
+
+func (q *ListQuery) match(field func(column string) any) bool {
+	if q == nil {
+		return true
+	}
+	for _, f := range q.Filters {
+		v := field(f.Column)
+		var ok bool
+		switch f.Op {
+		case "any":
+			ok = slices.ContainsFunc(f.Value.([]string), func(slug string) bool { return slices.Contains(v.([]string), slug) })
+		case "all":
+			ok = !slices.ContainsFunc(f.Value.([]string), func(slug string) bool { return !slices.Contains(v.([]string), slug) })
+		case "contains":
+			ok = strings.Contains(strings.ToLower(v.(string)), strings.ToLower(f.Value.(string)))
+		case "=":
+			ok = compareValues(v, f.Value) == 0
+		case "<":
+			ok = compareValues(v, f.Value) < 0
+		case "<=":
+			ok = compareValues(v, f.Value) <= 0
+		case ">":
+			ok = compareValues(v, f.Value) > 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders two rows in the paging direction.
+func (q *ListQuery) compare(a, b func(column string) any) int {
+	for _, k := range q.sortKeys() {
+		c := compareValues(a(k.Column), b(k.Column))
+		if k.Desc != q.backward() {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// after reports whether a row comes after the cursor in the paging
+// direction.
+func (q *ListQuery) after(field func(column string) any) bool {
+	values, err := q.cursorValues()
+	if err != nil {
+		return false
+	}
+	if values == nil {
+		return true
+	}
+	keys := q.sortKeys()
+	cursor := func(column string) any {
+		return values[slices.IndexFunc(keys, func(k SortKey) bool { return k.Column == column })]
+	}
+	return q.compare(field, cursor) > 0
+}
+
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case uuid.UUID:
+		return strings.Compare(a.String(), b.(uuid.UUID).String())
+	case int:
+		return cmp.Compare(a, b.(int))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	default:
+		return strings.Compare(a.(string), b.(string))
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseListQuery(t *testing.T) {
+	cursor := Cursor{
+		Sort:     "-created_at,-id",
+		Values:   []string{"2024-01-02T03:04:05.000006Z", "4a2b9c10-9daf-11ed-93ce-0242ac120001"},
+		Backward: true,
+	}
+	titleCursor := Cursor{
+		Sort:   "title,id",
+		Values: []string{"title-1", "4a2b9c10-9daf-11ed-93ce-0242ac220001"},
+	}
+
+	tests := []struct {
+		description    string
+		values         url.Values
+		expectedLimit  int
+		expectedCursor *Cursor
+		expectedSort   string
+		expectedErrors []string
+	}{
+		{
+			description:   "Defaults to the default page size and sort",
+			values:        url.Values{},
+			expectedLimit: DefaultPageSize,
+			expectedSort:  "-created_at,-id",
+		},
+		{
+			description:   "Limit is capped at the max",
+			values:        url.Values{"limit": {"1000"}},
+			expectedLimit: 50,
+			expectedSort:  "-created_at,-id",
+		},
+		{
+			description:    "Cursor round trips",
+			values:         url.Values{"limit": {"5"}, "cursor": {cursor.Encode()}},
+			expectedLimit:  5,
+			expectedCursor: &cursor,
+			expectedSort:   "-created_at,-id",
+		},
+		{
+			description:    "Sort by several fields",
+			values:         url.Values{"sort": {"title,-created_at"}, "cursor": {Cursor{Sort: "title,-created_at,-id", Values: []string{"a", "2024-01-02T03:04:05Z", "4a2b9c10-9daf-11ed-93ce-0242ac220001"}}.Encode()}},
+			expectedLimit:  DefaultPageSize,
+			expectedSort:   "title,-created_at,-id",
+			expectedCursor: &Cursor{Sort: "title,-created_at,-id", Values: []string{"a", "2024-01-02T03:04:05Z", "4a2b9c10-9daf-11ed-93ce-0242ac220001"}},
+		},
+		{
+			description:   "Include deleted",
+			values:        url.Values{"include_deleted": {"true"}},
+			expectedLimit: DefaultPageSize,
+			expectedSort:  "-created_at,-id",
+		},
+		{
+			description:    "Bad include deleted, expect fail",
+			values:         url.Values{"include_deleted": {"yes please"}},
+			expectedErrors: []string{"include_deleted"},
+		},
+		{
+			description:    "Zero limit, expect fail",
+			values:         url.Values{"limit": {"0"}},
+			expectedErrors: []string{"limit"},
+		},
+		{
+			description:    "Non numeric limit, expect fail",
+			values:         url.Values{"limit": {"ten"}},
+			expectedErrors: []string{"limit"},
+		},
+		{
+			description:    "Garbage cursor, expect fail",
+			values:         url.Values{"cursor": {"not-a-cursor"}},
+			expectedErrors: []string{"cursor"},
+		},
+		{
+			description:    "Cursor from another sort, expect fail",
+			values:         url.Values{"cursor": {titleCursor.Encode()}},
+			expectedErrors: []string{"cursor"},
+		},
+		{
+			description:    "Unknown sort field, expect fail",
+			values:         url.Values{"sort": {"-content"}},
+			expectedErrors: []string{"sort"},
+		},
+		{
+			description:    "Repeated sort field, expect fail",
+			values:         url.Values{"sort": {"title,-title"}},
+			expectedErrors: []string{"sort"},
+		},
+		{
+			description:    "Bad filter values and unknown parameters are all reported",
+			values:         url.Values{"user_id": {"42"}, "created_after": {"yesterday"}, "title_contains": {" "}, "content": {"x"}},
+			expectedErrors: []string{"user_id", "created_after", "title_contains", "content"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			q, err := ParseListQuery(tc.values, PostListSpec, 50)
+			if len(tc.expectedErrors) > 0 {
+				httpErr, ok := err.(*HTTPError)
+				if !ok {
+					t.Errorf("Expected a validation error, got %v", err)
+					return
+				}
+				if len(httpErr.FieldErrors) != len(tc.expectedErrors) {
+					t.Errorf("Wrong errors: %v", httpErr.FieldErrors)
+				}
+				for _, key := range tc.expectedErrors {
+					if _, ok := httpErr.FieldErrors[key]; !ok {
+						t.Errorf("Missing error for %s: %v", key, httpErr.FieldErrors)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if q.Limit != tc.expectedLimit {
+				t.Errorf("Wrong limit:%d!=%d", q.Limit, tc.expectedLimit)
+			}
+			if q.sortParam() != tc.expectedSort {
+				t.Errorf("Wrong sort:%s!=%s", q.sortParam(), tc.expectedSort)
+			}
+			if tc.expectedCursor == nil {
+				if q.Cursor != nil {
+					t.Error("Unexpected cursor")
+				}
+				return
+			}
+			if q.Cursor == nil || q.Cursor.Encode() != tc.expectedCursor.Encode() {
+				t.Errorf("Cursor mismatch: %+v", q.Cursor)
+			}
+		})
+	}
+}
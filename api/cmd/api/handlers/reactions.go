@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reaction is a user reacting to a post, with one of the kinds the server
+// is configured with.
+type Reaction struct {
+	PostId    uuid.UUID `json:"post_id" db:"post_id"`
+	UserId    uuid.UUID `json:"user_id" db:"user_id"`
+	Kind      string    `json:"kind" db:"kind"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+const REACTION_FIELDS = "post_id, user_id, kind, created_at"
+
+// reactionDeleted is selected along with a written reaction, to refuse
+// reactions of soft deleted users and to soft deleted posts, which fk_user
+// and fk_post let through.
+const reactionDeleted = `EXISTS (SELECT 1 FROM users WHERE users.id = reactions.user_id AND users.deleted_at IS NOT NULL),
+	EXISTS (SELECT 1 FROM posts WHERE posts.id = reactions.post_id AND posts.deleted_at IS NOT NULL)`
+
+// ReactionsCreateTx adds the reaction of the user to the post. Reacting
+// again with the same kind returns the reaction there is. A missing or
+// deleted post or user is a ConstraintError on post_id or user_id.
+func ReactionsCreateTx(tx *sql.Tx, postId, userId uuid.UUID, kind string) (*Reaction, error) {
+	_, err := tx.Exec(`INSERT INTO reactions (post_id, user_id, kind, created_at) VALUES ($1, $2, $3, $4) ON CONFLICT (post_id, user_id, kind) DO NOTHING`, postId, userId, kind, now())
+	if err != nil {
+		return nil, err
+	}
+
+	reaction := &Reaction{}
+	var userDeleted, postDeleted bool
+	s := fmt.Sprintf(`SELECT %s, %s FROM reactions WHERE post_id=$1 AND user_id=$2 AND kind=$3`, REACTION_FIELDS, reactionDeleted)
+	err = tx.QueryRow(s, postId, userId, kind).Scan(&reaction.PostId, &reaction.UserId, &reaction.Kind, &reaction.CreatedAt, &userDeleted, &postDeleted)
+	if err != nil {
+		return nil, err
+	}
+	if userDeleted {
+		return nil, errUserDeleted(userId)
+	}
+	if postDeleted {
+		return nil, errPostDeleted(postId)
+	}
+	return reaction, nil
+}
+
+// ReactionsDeleteTx removes the reaction of the user to the post. It
+// returns nil when there was no such reaction.
+func ReactionsDeleteTx(tx *sql.Tx, postId, userId uuid.UUID, kind string) (*Reaction, error) {
+	reaction := &Reaction{}
+	s := fmt.Sprintf(`DELETE FROM reactions WHERE post_id=$1 AND user_id=$2 AND kind=$3 RETURNING %s`, REACTION_FIELDS)
+	err := tx.QueryRow(s, postId, userId, kind).Scan(&reaction.PostId, &reaction.UserId, &reaction.Kind, &reaction.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return reaction, err
+}
+
+// postsLoadReactionsTx fills in the reaction counts of the posts, from
+// post_reaction_counts.
+func postsLoadReactionsTx(tx *sql.Tx, posts ...*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	byId := make(map[uuid.UUID]*Post, len(posts))
+	args := make([]any, len(posts))
+	placeholders := make([]string, len(posts))
+	for i, p := range posts {
+		p.Reactions = map[string]int{}
+		byId[p.Id] = p
+		args[i] = p.Id
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	s := fmt.Sprintf(`SELECT post_id, kind, count FROM post_reaction_counts WHERE post_id IN (%s) AND count > 0`, strings.Join(placeholders, ", "))
+	rows, err := tx.Query(s, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postId uuid.UUID
+		var kind string
+		var count int
+		err := rows.Scan(&postId, &kind, &count)
+		if err != nil {
+			return err
+		}
+		byId[postId].Reactions[kind] = count
+	}
+	return rows.Err()
+}
+
+// ReactionsLoadReactedTx fills in the kinds the user reacted with to each
+// of the posts.
+func ReactionsLoadReactedTx(tx *sql.Tx, userId uuid.UUID, posts ...*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	byId := make(map[uuid.UUID]*Post, len(posts))
+	args := []any{userId}
+	placeholders := make([]string, len(posts))
+	for i, p := range posts {
+		p.Reacted = []string{}
+		byId[p.Id] = p
+		args = append(args, p.Id)
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+
+	s := fmt.Sprintf(`SELECT post_id, kind FROM reactions WHERE user_id=$1 AND post_id IN (%s) ORDER BY kind`, strings.Join(placeholders, ", "))
+	rows, err := tx.Query(s, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postId uuid.UUID
+		var kind string
+		err := rows.Scan(&postId, &kind)
+		if err != nil {
+			return err
+		}
+		p := byId[postId]
+		p.Reacted = append(p.Reacted, kind)
+	}
+	return rows.Err()
+}
+
+// postsLoadTx fills in what posts carry besides their columns: tags,
+// reaction counts and the HTML of the content.
+func postsLoadTx(tx *sql.Tx, posts ...*Post) error {
+	err := postsLoadTagsTx(tx, posts...)
+	if err != nil {
+		return err
+	}
+	err = postsLoadReactionsTx(tx, posts...)
+	if err != nil {
+		return err
+	}
+	return postsLoadContentHTMLTx(tx, posts...)
+}
+
+// reactionsHash sums up the reaction counts of the post and the kinds the
+// viewer reacted with, for its ETag.
+func (p *Post) reactionsHash() string {
+	// Maps are marshalled with sorted keys.
+	b, _ := json.Marshal([]any{p.Reactions, p.Reacted})
+	h := fnv.New32a()
+	h.Write(b)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// VersionOfETag returns the part of an ETag of Post.ETag that is about the
+// row, without the hash of the reactions. Other ETags are returned as they
+// are.
+func VersionOfETag(etag string) string {
+	if i := strings.IndexByte(etag, '.'); i >= 0 && strings.HasSuffix(etag, `"`) {
+		return etag[:i] + `"`
+	}
+	return etag
+}
+
+type viewerContextKey struct{}
+
+// ContextWithViewer names the user making the request. Posts read in a
+// transaction whose context carries one say which kinds they reacted
+// with, see Post.Reacted.
+func ContextWithViewer(ctx context.Context, userId uuid.UUID) context.Context {
+	return context.WithValue(ctx, viewerContextKey{}, userId)
+}
+
+// ViewerFrom returns the user of ContextWithViewer, if there is one.
+func ViewerFrom(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(viewerContextKey{}).(uuid.UUID)
+	return id, ok
+}
+
+// withViewer wraps the post repository so that the posts it returns say
+// which kinds the viewer reacted with, when ctx has one.
+func withViewer(ctx context.Context, r *Repositories) *Repositories {
+	userId, ok := ViewerFrom(ctx)
+	if !ok {
+		return r
+	}
+	viewed := *r
+	viewed.Posts = viewedPosts{r.Posts, r.Reactions, userId}
+	return &viewed
+}
+
+// viewedPosts fills in Post.Reacted for a viewer.
+type viewedPosts struct {
+	PostRepository
+	reactions ReactionRepository
+	userId    uuid.UUID
+}
+
+// post fills in one post, which may be missing.
+func (r viewedPosts) post(p *Post, err error) (*Post, error) {
+	if err != nil || p == nil {
+		return p, err
+	}
+	return p, r.reactions.LoadReacted(r.userId, p)
+}
+
+// list fills in the posts of a page.
+func (r viewedPosts) list(l *List[Post], err error) (*List[Post], error) {
+	if err != nil {
+		return nil, err
+	}
+	return l, r.reactions.LoadReacted(r.userId, l.Data...)
+}
+
+func (r viewedPosts) Get(id uuid.UUID) (*Post, error) {
+	return r.post(r.PostRepository.Get(id))
+}
+
+func (r viewedPosts) GetWithDeleted(id uuid.UUID) (*Post, error) {
+	return r.post(r.PostRepository.GetWithDeleted(id))
+}
+
+func (r viewedPosts) GetBySlug(slug string) (*Post, error) {
+	return r.post(r.PostRepository.GetBySlug(slug))
+}
+
+func (r viewedPosts) GetAll(q *ListQuery) (*List[Post], error) {
+	return r.list(r.PostRepository.GetAll(q))
+}
+
+func (r viewedPosts) Feed(userId uuid.UUID, q *ListQuery) (*List[Post], error) {
+	return r.list(r.PostRepository.Feed(userId, q))
+}
+
+func (r viewedPosts) Search(text string, q *ListQuery) (*List[PostSearchResult], error) {
+	l, err := r.PostRepository.Search(text, q)
+	if err != nil {
+		return nil, err
+	}
+	posts := make([]*Post, len(l.Data))
+	for i, result := range l.Data {
+		posts[i] = &result.Post
+	}
+	return l, r.reactions.LoadReacted(r.userId, posts...)
+}
+
+func (r viewedPosts) Create(input *PostInput) (*Post, error) {
+	return r.post(r.PostRepository.Create(input))
+}
+
+func (r viewedPosts) CreateMany(inputs []*PostInput) ([]*Post, error) {
+	posts, err := r.PostRepository.CreateMany(inputs)
+	if err != nil {
+		return nil, err
+	}
+	return posts, r.reactions.LoadReacted(r.userId, posts...)
+}
+
+func (r viewedPosts) Update(id uuid.UUID, input *PostInput) (*Post, error) {
+	return r.post(r.PostRepository.Update(id, input))
+}
+
+func (r viewedPosts) Delete(id uuid.UUID) (*Post, error) {
+	return r.post(r.PostRepository.Delete(id))
+}
+
+func (r viewedPosts) Restore(id uuid.UUID) (*Post, error) {
+	return r.post(r.PostRepository.Restore(id))
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"api/cmd/api/utils"
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReactionsTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	id, _ := uuid.Parse("4a2b9c00-9daf-11ed-93ce-0242ac120001")
+	tests := []struct {
+		description      string
+		react            func(tx *sql.Tx) error
+		expectedCounts   map[string]int
+		expectedReacted  []string
+		expectConstraint *ConstraintError
+	}{
+		{
+			description: "Reactions are counted by kind",
+			react: func(tx *sql.Tx) error {
+				for _, r := range []struct {
+					userId uuid.UUID
+					kind   string
+				}{{db.Fixture.UserId1, "like"}, {db.Fixture.UserId2, "like"}, {db.Fixture.UserId1, "love"}} {
+					_, err := ReactionsCreateTx(tx, db.Fixture.PostId1, r.userId, r.kind)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			expectedCounts:  map[string]int{"like": 2, "love": 1},
+			expectedReacted: []string{"like", "love"},
+		},
+		{
+			description: "Reacting twice counts once",
+			react: func(tx *sql.Tx) error {
+				for range 2 {
+					_, err := ReactionsCreateTx(tx, db.Fixture.PostId1, db.Fixture.UserId1, "like")
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			expectedCounts:  map[string]int{"like": 1},
+			expectedReacted: []string{"like"},
+		},
+		{
+			description: "Removing a reaction counts it off",
+			react: func(tx *sql.Tx) error {
+				_, err := ReactionsCreateTx(tx, db.Fixture.PostId1, db.Fixture.UserId1, "like")
+				if err != nil {
+					return err
+				}
+				_, err = ReactionsCreateTx(tx, db.Fixture.PostId1, db.Fixture.UserId2, "wow")
+				if err != nil {
+					return err
+				}
+				r, err := ReactionsDeleteTx(tx, db.Fixture.PostId1, db.Fixture.UserId1, "like")
+				if err != nil {
+					return err
+				}
+				if r == nil {
+					return fmt.Errorf("Reaction not found")
+				}
+				return nil
+			},
+			expectedCounts:  map[string]int{"wow": 1},
+			expectedReacted: []string{},
+		},
+		{
+			description: "React to non existing post, expect fail",
+			react: func(tx *sql.Tx) error {
+				_, err := ReactionsCreateTx(tx, id, db.Fixture.UserId1, "like")
+				return err
+			},
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation},
+		},
+		{
+			description: "React to a deleted post, expect fail",
+			react: func(tx *sql.Tx) error {
+				_, err := PostsDeleteTx(tx, db.Fixture.PostId1)
+				if err != nil {
+					return err
+				}
+				_, err = ReactionsCreateTx(tx, db.Fixture.PostId1, db.Fixture.UserId1, "like")
+				return err
+			},
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			_, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			ctx := context.Background()
+			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+				err := tc.react(tx)
+				if err != nil {
+					return err
+				}
+				post, err := PostsGetTx(tx, db.Fixture.PostId1)
+				if err != nil {
+					return err
+				}
+				if !maps.Equal(post.Reactions, tc.expectedCounts) {
+					return fmt.Errorf("Wrong counts: %v", post.Reactions)
+				}
+				err = ReactionsLoadReactedTx(tx, db.Fixture.UserId1, post)
+				if err != nil {
+					return err
+				}
+				if !slices.Equal(post.Reacted, tc.expectedReacted) {
+					return fmt.Errorf("Wrong reacted: %q", post.Reacted)
+				}
+				return nil
+			})
+			if tc.expectConstraint != nil {
+				c, ok := AsConstraintError(err)
+				if !ok || c.Kind != tc.expectConstraint.Kind {
+					t.Errorf("Expected constraint %d, got %v", tc.expectConstraint.Kind, err)
+				}
+			} else if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestReactionsPurgeTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		_, err := ReactionsCreateTx(tx, db.Fixture.PostId1, db.Fixture.UserId2, "like")
+		if err != nil {
+			return err
+		}
+		_, err = UsersDeleteTx(tx, db.Fixture.UserId2)
+		if err != nil {
+			return err
+		}
+		_, err = UsersPurgeTx(tx, now().Add(1))
+		if err != nil {
+			return err
+		}
+		post, err := PostsGetTx(tx, db.Fixture.PostId1)
+		if err != nil {
+			return err
+		}
+		if len(post.Reactions) != 0 {
+			return fmt.Errorf("Reactions of purged users should not count: %v", post.Reactions)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostETagTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		before, err := PostsGetTx(tx, db.Fixture.PostId1)
+		if err != nil {
+			return err
+		}
+		_, err = ReactionsCreateTx(tx, db.Fixture.PostId1, db.Fixture.UserId2, "like")
+		if err != nil {
+			return err
+		}
+		after, err := PostsGetTx(tx, db.Fixture.PostId1)
+		if err != nil {
+			return err
+		}
+		if after.ETag() == before.ETag() {
+			return fmt.Errorf("ETag should change with the counts: %s", after.ETag())
+		}
+		if VersionOfETag(after.ETag()) != VersionOfETag(before.ETag()) || VersionOfETag(after.ETag()) != `"1"` {
+			return fmt.Errorf("Version should stay: %s %s", before.ETag(), after.ETag())
+		}
+
+		anonymous := after.ETag()
+		for _, userId := range []uuid.UUID{db.Fixture.UserId1, db.Fixture.UserId2} {
+			err = ReactionsLoadReactedTx(tx, userId, after)
+			if err != nil {
+				return err
+			}
+			if after.ETag() == anonymous {
+				return fmt.Errorf("ETag should change with the viewer: %s", after.ETag())
+			}
+			anonymous = after.ETag()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"api/cmd/api/utils"
+
+	"github.com/google/uuid"
+)
+
+// UserRepository covers every operation on users. Lookups of a missing user
+// return nil and no error, and so do lookups of a soft deleted user, except
+// through GetWithDeleted and Restore. Emails are unique among live users,
+// writes that would share one fail with a UniqueViolation. GetByHandle
+// also finds users by the handles they had before a rename.
+type UserRepository interface {
+	Get(id uuid.UUID) (*User, error)
+	GetWithDeleted(id uuid.UUID) (*User, error)
+	GetByEmail(email string) (*User, error)
+	GetByHandle(handle string) (*User, error)
+	GetAll(q *ListQuery) (*List[User], error)
+	Create(input *UserInput) (*User, error)
+	CreateMany(inputs []*UserInput) ([]*User, error)
+	Update(id uuid.UUID, input *UserInput) (*User, error)
+	Delete(id uuid.UUID) (*User, error)
+	Restore(id uuid.UUID) (*User, error)
+	Purge(before time.Time) (int64, error)
+}
+
+// PostRepository covers every operation on posts. Lookups of a missing post
+// return nil and no error, and so do lookups of a soft deleted post, except
+// through GetWithDeleted and Restore. Search takes websearch_to_tsquery
+// syntax and never finds soft deleted posts, and neither does Feed, which
+// lists the posts of the users a user follows. Publish publishes the
+// scheduled posts that are due. GetBySlug also finds posts by the slugs
+// they had before a rename.
+type PostRepository interface {
+	Get(id uuid.UUID) (*Post, error)
+	GetWithDeleted(id uuid.UUID) (*Post, error)
+	GetBySlug(slug string) (*Post, error)
+	GetAll(q *ListQuery) (*List[Post], error)
+	Count(q *ListQuery) (int, error)
+	Search(text string, q *ListQuery) (*List[PostSearchResult], error)
+	Feed(userId uuid.UUID, q *ListQuery) (*List[Post], error)
+	Create(input *PostInput) (*Post, error)
+	CreateMany(inputs []*PostInput) ([]*Post, error)
+	Update(id uuid.UUID, input *PostInput) (*Post, error)
+	Delete(id uuid.UUID) (*Post, error)
+	Restore(id uuid.UUID) (*Post, error)
+	Purge(before time.Time) (int64, error)
+	Publish(before time.Time) (int64, error)
+}
+
+// CommentRepository covers every operation on comments. Comments of soft
+// deleted posts and users are hidden, lookups of them return nil and no
+// error like lookups of missing ones. Deleting a comment deletes its
+// replies.
+type CommentRepository interface {
+	Get(id uuid.UUID) (*Comment, error)
+	GetAll(q *ListQuery) (*List[Comment], error)
+	Replies(rootIds []uuid.UUID, maxDepth int) ([]*Comment, error)
+	Create(postId uuid.UUID, input *CommentInput) (*Comment, error)
+	Update(id uuid.UUID, input *CommentInput) (*Comment, error)
+	Delete(id uuid.UUID) (*Comment, error)
+}
+
+// FollowRepository covers users following users. Following is idempotent,
+// and unfollowing a user that isn't followed returns nil and no error.
+// Lists and counts leave soft deleted users out, and a soft deleted user
+// can neither follow nor be followed.
+type FollowRepository interface {
+	Get(followerId, followeeId uuid.UUID) (*Follow, error)
+	Create(followeeId uuid.UUID, input *FollowInput) (*Follow, error)
+	Delete(followerId, followeeId uuid.UUID) (*Follow, error)
+	Followers(userId uuid.UUID, q *ListQuery) (*List[FollowUser], error)
+	Following(userId uuid.UUID, q *ListQuery) (*List[FollowUser], error)
+	Counts(userId uuid.UUID) (*FollowCounts, error)
+}
+
+// ReactionRepository covers reactions of users to posts, one of each kind
+// per user and post. Reacting again is idempotent, and removing a reaction
+// there isn't returns nil and no error. PostRepository fills in the counts
+// of the posts it returns, and LoadReacted the kinds a user reacted with.
+type ReactionRepository interface {
+	Create(postId, userId uuid.UUID, kind string) (*Reaction, error)
+	Delete(postId, userId uuid.UUID, kind string) (*Reaction, error)
+	LoadReacted(userId uuid.UUID, posts ...*Post) error
+}
+
+// TagRepository reads the tags of posts, which PostRepository sets as posts
+// are created and updated. Counts leave soft deleted posts out, and GetAll
+// the tags no post carries.
+type TagRepository interface {
+	Get(slug string) (*Tag, error)
+	GetAll(q *ListQuery) (*List[Tag], error)
+}
+
+// RevisionRepository reads the history of posts, which PostRepository
+// records as posts are created and updated.
+type RevisionRepository interface {
+	Get(postId uuid.UUID, rev int) (*PostRevision, error)
+	GetAll(q *ListQuery) (*List[PostRevision], error)
+}
+
+// AuditRepository is the append only log of writes to users and posts.
+// Stores fill it in themselves, see ContextWithAuditor.
+type AuditRepository interface {
+	Record(records ...*AuditRecord) error
+	GetAll(q *ListQuery) (*List[AuditRecord], error)
+}
+
+// IdempotencyRepository keeps the Idempotency-Key of mutations along with
+// their responses. Creating a key that exists fails with a
+// UniqueViolation, which is how concurrent retries are told apart. A
+// request holds its key until a lease runs out, and Commit, Complete and
+// Release fail with ErrIdempotencyLeaseLost once a retry claimed it.
+type IdempotencyRepository interface {
+	Get(key string) (*IdempotencyKey, error)
+	Create(key, requestHash string, lockedUntil, expiresAt time.Time) (*IdempotencyKey, error)
+	Claim(key string, lockedUntil time.Time) (*IdempotencyKey, error)
+	Commit(key string, lease time.Time) error
+	Complete(key string, lease time.Time, status int, headers http.Header, body []byte) error
+	Release(key string, lease time.Time) error
+	Delete(key string) error
+	Purge(before time.Time) (int64, error)
+}
+
+// Repositories are bound to a single transaction.
+type Repositories struct {
+	Users       UserRepository
+	Posts       PostRepository
+	Comments    CommentRepository
+	Follows     FollowRepository
+	Reactions   ReactionRepository
+	Tags        TagRepository
+	Revisions   RevisionRepository
+	Audit       AuditRepository
+	Idempotency IdempotencyRepository
+}
+
+// Store runs fn in a transaction. Everything fn does through the
+// repositories is committed when it returns nil and discarded otherwise.
+// When ctx has an Auditor, the writes are recorded in the audit log of the
+// same transaction, and when it has a viewer, posts say which kinds they
+// reacted with. Commits of transactions that aren't read only are reported
+// to ctx, see ContextWithCommits, and mark the idempotency key of ctx
+// committed, see ContextWithIdempotencyKey.
+type Store interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions, fn func(r *Repositories) error) error
+}
+
+type commitsContextKey struct{}
+
+// ContextWithCommits has the stores report the commits of the
+// transactions run with ctx, for requests that must not run again once
+// they wrote something, see Committed.
+func ContextWithCommits(ctx context.Context) context.Context {
+	return context.WithValue(ctx, commitsContextKey{}, &atomic.Bool{})
+}
+
+// Committed reports whether a transaction that isn't read only was
+// committed with ctx since ContextWithCommits.
+func Committed(ctx context.Context) bool {
+	committed, ok := ctx.Value(commitsContextKey{}).(*atomic.Bool)
+	return ok && committed.Load()
+}
+
+type idempotencyKeyContextKey struct{}
+
+type idempotencyLease struct {
+	key   string
+	lease time.Time
+}
+
+// ContextWithIdempotencyKey has the first transaction that commits a
+// write with ctx mark key, held until lease, committed, so that a retry
+// never claims it and writes again. The transaction fails with
+// ErrIdempotencyLeaseLost if a retry claimed the key already. It goes with
+// ContextWithCommits, which tells the first transaction apart.
+func ContextWithIdempotencyKey(ctx context.Context, key string, lease time.Time) context.Context {
+	return context.WithValue(ContextWithCommits(ctx), idempotencyKeyContextKey{}, idempotencyLease{key, lease})
+}
+
+// commitIdempotencyKey marks the idempotency key of ctx committed, in a
+// transaction with opts that is about to commit.
+func commitIdempotencyKey(ctx context.Context, opts *sql.TxOptions, r IdempotencyRepository) error {
+	l, ok := ctx.Value(idempotencyKeyContextKey{}).(idempotencyLease)
+	if !ok || (opts != nil && opts.ReadOnly) || Committed(ctx) {
+		return nil
+	}
+	return r.Commit(l.key, l.lease)
+}
+
+// reportCommit tells ctx that a transaction with opts was committed.
+func reportCommit(ctx context.Context, opts *sql.TxOptions) {
+	committed, ok := ctx.Value(commitsContextKey{}).(*atomic.Bool)
+	if ok && (opts == nil || !opts.ReadOnly) {
+		committed.Store(true)
+	}
+}
+
+// SQLStore is the Postgres backed Store, built on the Tx functions.
+type SQLStore struct {
+	db utils.IDB
+}
+
+func NewSQLStore(db utils.IDB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) BeginTx(ctx context.Context, opts *sql.TxOptions, fn func(r *Repositories) error) error {
+	err := s.db.BeginTx(ctx, opts, func(tx *sql.Tx) error {
+		r := NewSQLRepositories(tx, s.db.Dialect(), authorFrom(ctx))
+		err := fn(withViewer(ctx, withAudit(ctx, r)))
+		if err != nil {
+			return err
+		}
+		return commitIdempotencyKey(ctx, opts, r.Idempotency)
+	})
+	if err != nil {
+		return err
+	}
+	reportCommit(ctx, opts)
+	return nil
+}
+
+// NewSQLRepositories binds the repositories to tx. The dialect picks the
+// SQL of the few queries that can't be written the same way for both
+// databases, such as search, and author is who the revisions of the posts
+// written through them are by, if known.
+func NewSQLRepositories(tx *sql.Tx, dialect utils.Dialect, author *uuid.UUID) *Repositories {
+	return &Repositories{
+		Users:       sqlUsers{tx},
+		Posts:       sqlPosts{tx, dialect, author},
+		Comments:    sqlComments{tx},
+		Follows:     sqlFollows{tx},
+		Reactions:   sqlReactions{tx},
+		Tags:        sqlTags{tx},
+		Revisions:   sqlRevisions{tx},
+		Audit:       sqlAudit{tx},
+		Idempotency: sqlIdempotency{tx},
+	}
+}
+
+type sqlUsers struct {
+	tx *sql.Tx
+}
+
+func (r sqlUsers) Get(id uuid.UUID) (*User, error) {
+	return UsersGetTx(r.tx, id)
+}
+
+func (r sqlUsers) GetWithDeleted(id uuid.UUID) (*User, error) {
+	return UsersGetWithDeletedTx(r.tx, id)
+}
+
+func (r sqlUsers) GetByEmail(email string) (*User, error) {
+	return UsersGetByEmailTx(r.tx, email)
+}
+
+func (r sqlUsers) GetByHandle(handle string) (*User, error) {
+	return UsersGetByHandleTx(r.tx, handle)
+}
+
+func (r sqlUsers) GetAll(q *ListQuery) (*List[User], error) {
+	return UsersGetAllTx(r.tx, q)
+}
+
+func (r sqlUsers) Create(input *UserInput) (*User, error) {
+	return UsersCreateTx(r.tx, input)
+}
+
+func (r sqlUsers) CreateMany(inputs []*UserInput) ([]*User, error) {
+	return UsersCreateManyTx(r.tx, inputs)
+}
+
+func (r sqlUsers) Update(id uuid.UUID, input *UserInput) (*User, error) {
+	return UsersUpdateTx(r.tx, id, input)
+}
+
+func (r sqlUsers) Delete(id uuid.UUID) (*User, error) {
+	return UsersDeleteTx(r.tx, id)
+}
+
+func (r sqlUsers) Restore(id uuid.UUID) (*User, error) {
+	return UsersRestoreTx(r.tx, id)
+}
+
+func (r sqlUsers) Purge(before time.Time) (int64, error) {
+	return UsersPurgeTx(r.tx, before)
+}
+
+type sqlPosts struct {
+	tx      *sql.Tx
+	dialect utils.Dialect
+	author  *uuid.UUID
+}
+
+func (r sqlPosts) Get(id uuid.UUID) (*Post, error) {
+	return PostsGetTx(r.tx, id)
+}
+
+func (r sqlPosts) GetWithDeleted(id uuid.UUID) (*Post, error) {
+	return PostsGetWithDeletedTx(r.tx, id)
+}
+
+func (r sqlPosts) GetBySlug(slug string) (*Post, error) {
+	return PostsGetBySlugTx(r.tx, slug)
+}
+
+func (r sqlPosts) GetAll(q *ListQuery) (*List[Post], error) {
+	return PostsGetAllTx(r.tx, q)
+}
+
+func (r sqlPosts) Count(q *ListQuery) (int, error) {
+	return PostsCountTx(r.tx, q)
+}
+
+func (r sqlPosts) Search(text string, q *ListQuery) (*List[PostSearchResult], error) {
+	return PostsSearchTx(r.tx, r.dialect, text, q)
+}
+
+func (r sqlPosts) Feed(userId uuid.UUID, q *ListQuery) (*List[Post], error) {
+	return PostsFeedTx(r.tx, userId, q)
+}
+
+func (r sqlPosts) Create(input *PostInput) (*Post, error) {
+	return PostsCreateTx(r.tx, input, r.author)
+}
+
+func (r sqlPosts) CreateMany(inputs []*PostInput) ([]*Post, error) {
+	return PostsCreateManyTx(r.tx, inputs, r.author)
+}
+
+func (r sqlPosts) Update(id uuid.UUID, input *PostInput) (*Post, error) {
+	return PostsUpdateTx(r.tx, id, input, r.author)
+}
+
+func (r sqlPosts) Delete(id uuid.UUID) (*Post, error) {
+	return PostsDeleteTx(r.tx, id)
+}
+
+func (r sqlPosts) Restore(id uuid.UUID) (*Post, error) {
+	return PostsRestoreTx(r.tx, id)
+}
+
+func (r sqlPosts) Purge(before time.Time) (int64, error) {
+	return PostsPurgeTx(r.tx, before)
+}
+
+func (r sqlPosts) Publish(before time.Time) (int64, error) {
+	return PostsPublishTx(r.tx, before)
+}
+
+type sqlComments struct {
+	tx *sql.Tx
+}
+
+func (r sqlComments) Get(id uuid.UUID) (*Comment, error) {
+	return CommentsGetTx(r.tx, id)
+}
+
+func (r sqlComments) GetAll(q *ListQuery) (*List[Comment], error) {
+	return CommentsGetAllTx(r.tx, q)
+}
+
+func (r sqlComments) Replies(rootIds []uuid.UUID, maxDepth int) ([]*Comment, error) {
+	return CommentsRepliesTx(r.tx, rootIds, maxDepth)
+}
+
+func (r sqlComments) Create(postId uuid.UUID, input *CommentInput) (*Comment, error) {
+	return CommentsCreateTx(r.tx, postId, input)
+}
+
+func (r sqlComments) Update(id uuid.UUID, input *CommentInput) (*Comment, error) {
+	return CommentsUpdateTx(r.tx, id, input)
+}
+
+func (r sqlComments) Delete(id uuid.UUID) (*Comment, error) {
+	return CommentsDeleteTx(r.tx, id)
+}
+
+type sqlFollows struct {
+	tx *sql.Tx
+}
+
+func (r sqlFollows) Get(followerId, followeeId uuid.UUID) (*Follow, error) {
+	return FollowsGetTx(r.tx, followerId, followeeId)
+}
+
+func (r sqlFollows) Create(followeeId uuid.UUID, input *FollowInput) (*Follow, error) {
+	return FollowsCreateTx(r.tx, followeeId, input)
+}
+
+func (r sqlFollows) Delete(followerId, followeeId uuid.UUID) (*Follow, error) {
+	return FollowsDeleteTx(r.tx, followerId, followeeId)
+}
+
+func (r sqlFollows) Followers(userId uuid.UUID, q *ListQuery) (*List[FollowUser], error) {
+	return FollowsFollowersTx(r.tx, userId, q)
+}
+
+func (r sqlFollows) Following(userId uuid.UUID, q *ListQuery) (*List[FollowUser], error) {
+	return FollowsFollowingTx(r.tx, userId, q)
+}
+
+func (r sqlFollows) Counts(userId uuid.UUID) (*FollowCounts, error) {
+	return FollowsCountsTx(r.tx, userId)
+}
+
+type sqlReactions struct {
+	tx *sql.Tx
+}
+
+func (r sqlReactions) Create(postId, userId uuid.UUID, kind string) (*Reaction, error) {
+	return ReactionsCreateTx(r.tx, postId, userId, kind)
+}
+
+func (r sqlReactions) Delete(postId, userId uuid.UUID, kind string) (*Reaction, error) {
+	return ReactionsDeleteTx(r.tx, postId, userId, kind)
+}
+
+func (r sqlReactions) LoadReacted(userId uuid.UUID, posts ...*Post) error {
+	return ReactionsLoadReactedTx(r.tx, userId, posts...)
+}
+
+type sqlTags struct {
+	tx *sql.Tx
+}
+
+func (r sqlTags) Get(slug string) (*Tag, error) {
+	return TagsGetTx(r.tx, slug)
+}
+
+func (r sqlTags) GetAll(q *ListQuery) (*List[Tag], error) {
+	return TagsGetAllTx(r.tx, q)
+}
+
+type sqlRevisions struct {
+	tx *sql.Tx
+}
+
+func (r sqlRevisions) Get(postId uuid.UUID, rev int) (*PostRevision, error) {
+	return RevisionsGetTx(r.tx, postId, rev)
+}
+
+func (r sqlRevisions) GetAll(q *ListQuery) (*List[PostRevision], error) {
+	return RevisionsGetAllTx(r.tx, q)
+}
+
+type sqlAudit struct {
+	tx *sql.Tx
+}
+
+func (r sqlAudit) Record(records ...*AuditRecord) error {
+	return AuditRecordTx(r.tx, records...)
+}
+
+func (r sqlAudit) GetAll(q *ListQuery) (*List[AuditRecord], error) {
+	return AuditGetAllTx(r.tx, q)
+}
+
+type sqlIdempotency struct {
+	tx *sql.Tx
+}
+
+func (r sqlIdempotency) Get(key string) (*IdempotencyKey, error) {
+	return IdempotencyKeysGetTx(r.tx, key)
+}
+
+func (r sqlIdempotency) Create(key, requestHash string, lockedUntil, expiresAt time.Time) (*IdempotencyKey, error) {
+	return IdempotencyKeysCreateTx(r.tx, key, requestHash, lockedUntil, expiresAt)
+}
+
+func (r sqlIdempotency) Claim(key string, lockedUntil time.Time) (*IdempotencyKey, error) {
+	return IdempotencyKeysClaimTx(r.tx, key, lockedUntil)
+}
+
+func (r sqlIdempotency) Commit(key string, lease time.Time) error {
+	return IdempotencyKeysCommitTx(r.tx, key, lease)
+}
+
+func (r sqlIdempotency) Complete(key string, lease time.Time, status int, headers http.Header, body []byte) error {
+	return IdempotencyKeysCompleteTx(r.tx, key, lease, status, headers, body)
+}
+
+func (r sqlIdempotency) Release(key string, lease time.Time) error {
+	return IdempotencyKeysReleaseTx(r.tx, key, lease)
+}
+
+func (r sqlIdempotency) Delete(key string) error {
+	return IdempotencyKeysDeleteTx(r.tx, key)
+}
+
+func (r sqlIdempotency) Purge(before time.Time) (int64, error) {
+	return IdempotencyKeysPurgeTx(r.tx, before)
+}
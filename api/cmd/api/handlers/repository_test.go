@@ -0,0 +1,2045 @@
+package handlers
+
+import (
+	"api/cmd/api/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryRepositories(t *testing.T) {
+	f := utils.Fixture{
+		UserId1: uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac120001"),
+		UserId2: uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac120002"),
+		PostId1: uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac220001"),
+		PostId2: uuid.MustParse("4a2b9c10-9daf-11ed-93ce-0242ac220002"),
+	}
+	testRepositories(t, f, func() (Store, error) {
+		return newMemoryTestStore(f), nil
+	})
+}
+
+func TestSQLRepositories(t *testing.T) {
+	db := utils.TestNewDB(t)
+	testRepositories(t, db.Fixture, func() (Store, error) {
+		_, err := db.Open()
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLStore(&db), nil
+	})
+}
+
+// newMemoryTestStore seeds the same fixture as utils.TestDB, inserted in the
+// same order.
+func newMemoryTestStore(f utils.Fixture) *MemoryStore {
+	s := NewMemoryStore()
+	t := now()
+	s.data.users[f.UserId1] = User{Id: f.UserId1, Name: "user-1", Handle: "user-1", Email: "email-1", CreatedAt: t, Version: 1}
+	s.data.users[f.UserId2] = User{Id: f.UserId2, Name: "user-2", Handle: "user-2", Email: "email-2", CreatedAt: t.Add(time.Millisecond), Version: 1}
+	s.data.posts[f.PostId1] = Post{Id: f.PostId1, Title: "title-1", Slug: "title-1", Content: "content-1", ContentFormat: ContentPlain, UserId: f.UserId1, CreatedAt: t.Add(2 * time.Millisecond), Version: 1, Status: PostPublished}
+	s.data.posts[f.PostId2] = Post{Id: f.PostId2, Title: "title-2", Slug: "title-2", Content: "content-2", ContentFormat: ContentPlain, UserId: f.UserId2, CreatedAt: t.Add(3 * time.Millisecond), Version: 1, Status: PostPublished}
+	for id, p := range s.data.posts {
+		p.PublishedAt = &p.CreatedAt
+		p.setContentHTML(nil)
+		s.data.posts[id] = p
+		s.data.slugs[p.Slug] = id
+	}
+	for id, u := range s.data.users {
+		s.data.handles[u.Handle] = id
+	}
+	return s
+}
+
+// testRepositories checks the behaviour every Store must share.
+func testRepositories(t *testing.T, f utils.Fixture, newStore func() (Store, error)) {
+	missing, _ := uuid.Parse("4a2b9c00-9daf-11ed-93ce-0242ac120001")
+
+	tests := []struct {
+		description string
+		fn          func(r *Repositories) error
+		expectError bool
+		// expectConstraint is the violation the error must be, if set. An
+		// empty Field matches any, SQLite doesn't name foreign keys.
+		expectConstraint *ConstraintError
+	}{
+		{
+			description: "Users are ordered newest first",
+			fn: func(r *Repositories) error {
+				users, err := r.Users.GetAll(nil)
+				if err != nil {
+					return err
+				}
+				if len(users.Data) != 2 || users.Data[0].Id != f.UserId2 || users.Data[1].Id != f.UserId1 {
+					return fmt.Errorf("Wrong order")
+				}
+				if users.NextCursor != nil || users.PrevCursor != nil {
+					return fmt.Errorf("Single page should have no cursors")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Posts are ordered newest first",
+			fn: func(r *Repositories) error {
+				posts, err := r.Posts.GetAll(nil)
+				if err != nil {
+					return err
+				}
+				if len(posts.Data) != 2 || posts.Data[0].Id != f.PostId2 || posts.Data[1].Id != f.PostId1 {
+					return fmt.Errorf("Wrong order")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Users are paged by cursor",
+			fn: func(r *Repositories) error {
+				first, err := r.Users.GetAll(&ListQuery{Limit: 1})
+				if err != nil {
+					return err
+				}
+				if len(first.Data) != 1 || first.Data[0].Id != f.UserId2 || first.NextCursor == nil || first.PrevCursor != nil {
+					return fmt.Errorf("Wrong first page")
+				}
+				c, err := DecodeCursor(*first.NextCursor)
+				if err != nil {
+					return err
+				}
+				second, err := r.Users.GetAll(&ListQuery{Limit: 1, Cursor: c})
+				if err != nil {
+					return err
+				}
+				if len(second.Data) != 1 || second.Data[0].Id != f.UserId1 || second.NextCursor != nil || second.PrevCursor == nil {
+					return fmt.Errorf("Wrong second page")
+				}
+				c, err = DecodeCursor(*second.PrevCursor)
+				if err != nil {
+					return err
+				}
+				back, err := r.Users.GetAll(&ListQuery{Limit: 1, Cursor: c})
+				if err != nil {
+					return err
+				}
+				if len(back.Data) != 1 || back.Data[0].Id != f.UserId2 || back.NextCursor == nil || back.PrevCursor != nil {
+					return fmt.Errorf("Wrong page going back")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Posts are paged by cursor",
+			fn: func(r *Repositories) error {
+				_, err := r.Posts.Create(&PostInput{"title-3", "content-3", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				seen := []uuid.UUID{}
+				q := &ListQuery{Limit: 2}
+				for {
+					page, err := r.Posts.GetAll(q)
+					if err != nil {
+						return err
+					}
+					for _, p := range page.Data {
+						seen = append(seen, p.Id)
+					}
+					if page.NextCursor == nil {
+						break
+					}
+					q.Cursor, err = DecodeCursor(*page.NextCursor)
+					if err != nil {
+						return err
+					}
+				}
+				if len(seen) != 3 || seen[0] == seen[1] || seen[1] == seen[2] || seen[0] == seen[2] {
+					return fmt.Errorf("Wrong posts: %v", seen)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Posts are filtered",
+			fn: func(r *Repositories) error {
+				_, err := r.Posts.Create(&PostInput{"Another 100%_Title", "content-3", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				tests := []struct {
+					values   url.Values
+					expected int
+				}{
+					{url.Values{"user_id": {f.UserId1.String()}}, 2},
+					{url.Values{"user_id": {f.UserId2.String()}}, 1},
+					{url.Values{"title_contains": {"TITLE"}}, 3},
+					{url.Values{"title_contains": {"100%_"}}, 1},
+					{url.Values{"title_contains": {"0%t"}}, 0},
+					{url.Values{"title_contains": {"title-"}, "user_id": {f.UserId1.String()}}, 1},
+					{url.Values{"created_after": {"2000-01-01T00:00:00Z"}}, 3},
+					{url.Values{"created_before": {"2000-01-01T00:00:00Z"}}, 0},
+				}
+				for _, tc := range tests {
+					q, err := ParseListQuery(tc.values, PostListSpec, 10)
+					if err != nil {
+						return err
+					}
+					posts, err := r.Posts.GetAll(q)
+					if err != nil {
+						return err
+					}
+					if len(posts.Data) != tc.expected {
+						return fmt.Errorf("%v: wrong len:%d!=%d", tc.values, len(posts.Data), tc.expected)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			description: "Posts are counted across pages",
+			fn: func(r *Repositories) error {
+				_, err := r.Posts.Create(&PostInput{"title-3", "content-3", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				q := &ListQuery{Limit: 1}
+				q.Where(PostListSpec.Filters["user_id"], f.UserId1)
+				count, err := r.Posts.Count(q)
+				if err != nil {
+					return err
+				}
+				if count != 2 {
+					return fmt.Errorf("Wrong count:%d!=2", count)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Users are sorted by several fields and paged",
+			fn: func(r *Repositories) error {
+				for _, in := range []UserInput{{"b", "b1@example.com"}, {"a", "a@example.com"}, {"b", "b2@example.com"}, {"c", "c@example.com"}} {
+					_, err := r.Users.Create(&in)
+					if err != nil {
+						return err
+					}
+				}
+				values := url.Values{"sort": {"name,-email"}, "limit": {"2"}}
+				names := []string{}
+				for {
+					q, err := ParseListQuery(values, UserListSpec, 10)
+					if err != nil {
+						return err
+					}
+					page, err := r.Users.GetAll(q)
+					if err != nil {
+						return err
+					}
+					for _, u := range page.Data {
+						names = append(names, u.Name+":"+u.Email)
+					}
+					if page.NextCursor == nil {
+						break
+					}
+					values.Set("cursor", *page.NextCursor)
+				}
+				if strings.Join(names, ",") != "a:a@example.com,b:b2@example.com,b:b1@example.com,c:c@example.com,user-1:email-1,user-2:email-2" {
+					return fmt.Errorf("Wrong order: %v", names)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Emails are normalized and found in any case",
+			fn: func(r *Repositories) error {
+				u, err := r.Users.Create(&UserInput{"one", " One@Example.com"})
+				if err != nil {
+					return err
+				}
+				if u.Email != "one@example.com" {
+					return fmt.Errorf("Email not normalized: %q", u.Email)
+				}
+				found, err := r.Users.GetByEmail("ONE@example.COM")
+				if err != nil || found == nil || found.Id != u.Id {
+					return fmt.Errorf("Not found by email: %v", err)
+				}
+				q, err := ParseListQuery(url.Values{"email": {"one@EXAMPLE.com"}}, UserListSpec, 10)
+				if err != nil {
+					return err
+				}
+				users, err := r.Users.GetAll(q)
+				if err != nil || len(users.Data) != 1 {
+					return fmt.Errorf("Not filtered by email: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Create user with a taken email, expect fail",
+			fn: func(r *Repositories) error {
+				_, err := r.Users.Create(&UserInput{"one", "EMAIL-1"})
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: UniqueViolation, Field: "email"},
+		},
+		{
+			description: "Update user to a taken email, expect fail",
+			fn: func(r *Repositories) error {
+				_, err := r.Users.Update(f.UserId1, &UserInput{"user-1", "Email-2"})
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: UniqueViolation, Field: "email"},
+		},
+		{
+			description: "Restore user whose email was taken while deleted, expect fail",
+			fn: func(r *Repositories) error {
+				_, err := r.Users.Delete(f.UserId1)
+				if err != nil {
+					return fmt.Errorf("Delete: %w", err)
+				}
+				found, err := r.Users.GetByEmail("email-1")
+				if err != nil || found != nil {
+					return fmt.Errorf("Deleted user found by email")
+				}
+				_, err = r.Users.Create(&UserInput{"one", "email-1"})
+				if err != nil {
+					return fmt.Errorf("Email of deleted user not free: %w", err)
+				}
+				_, err = r.Users.Restore(f.UserId1)
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: UniqueViolation, Field: "email"},
+		},
+		{
+			description: "Missing rows are nil",
+			fn: func(r *Repositories) error {
+				u, err := r.Users.Get(missing)
+				if err != nil || u != nil {
+					return fmt.Errorf("Should be not found")
+				}
+				u, err = r.Users.Update(missing, &UserInput{"name", "email"})
+				if err != nil || u != nil {
+					return fmt.Errorf("Should be not found")
+				}
+				u, err = r.Users.Delete(missing)
+				if err != nil || u != nil {
+					return fmt.Errorf("Should be not found")
+				}
+				p, err := r.Posts.Get(missing)
+				if err != nil || p != nil {
+					return fmt.Errorf("Should be not found")
+				}
+				p, err = r.Posts.Update(missing, &PostInput{"title", "content", f.UserId1, nil, "", nil, ""})
+				if err != nil || p != nil {
+					return fmt.Errorf("Should be not found")
+				}
+				p, err = r.Posts.Delete(missing)
+				if err != nil || p != nil {
+					return fmt.Errorf("Should be not found")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Create and update set the fields",
+			fn: func(r *Repositories) error {
+				u, err := r.Users.Create(&UserInput{"one", "1"})
+				if err != nil {
+					return err
+				}
+				p, err := r.Posts.Create(&PostInput{"title", "content", u.Id, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				if p.UpdatedAt != nil {
+					return fmt.Errorf("UpdatedAt set on create")
+				}
+				p, err = r.Posts.Update(p.Id, &PostInput{"title-updated", "content-updated", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				post, err := r.Posts.Get(p.Id)
+				if err != nil {
+					return err
+				}
+				if post.Title != "title-updated" || post.Content != "content-updated" || post.UserId != f.UserId1 {
+					return fmt.Errorf("Post mismatch")
+				}
+				if post.UpdatedAt == nil {
+					return fmt.Errorf("UpdatedAt not set on update")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Deleting a user deletes their posts",
+			fn: func(r *Repositories) error {
+				u, err := r.Users.Delete(f.UserId1)
+				if err != nil {
+					return err
+				}
+				if u == nil {
+					return fmt.Errorf("User not found")
+				}
+				p, err := r.Posts.Get(f.PostId1)
+				if err != nil {
+					return err
+				}
+				if p != nil {
+					return fmt.Errorf("Post was not deleted")
+				}
+				p, err = r.Posts.Get(f.PostId2)
+				if err != nil {
+					return err
+				}
+				if p == nil {
+					return fmt.Errorf("Post of another user was deleted")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Deleted users are hidden and restored with their posts",
+			fn: func(r *Repositories) error {
+				p, err := r.Posts.Create(&PostInput{"title-3", "content-3", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				_, err = r.Posts.Delete(p.Id)
+				if err != nil {
+					return err
+				}
+				// Deleted on its own, so it must stay deleted when the user is restored.
+				time.Sleep(time.Millisecond)
+				u, err := r.Users.Delete(f.UserId1)
+				if err != nil {
+					return err
+				}
+				if u == nil || u.DeletedAt == nil {
+					return fmt.Errorf("DeletedAt not set")
+				}
+				u, err = r.Users.Delete(f.UserId1)
+				if err != nil || u != nil {
+					return fmt.Errorf("Deleted twice")
+				}
+				u, err = r.Users.Get(f.UserId1)
+				if err != nil || u != nil {
+					return fmt.Errorf("Deleted user found")
+				}
+				u, err = r.Users.GetWithDeleted(f.UserId1)
+				if err != nil || u == nil {
+					return fmt.Errorf("Deleted user not found with deleted")
+				}
+				users, err := r.Users.GetAll(nil)
+				if err != nil {
+					return err
+				}
+				if len(users.Data) != 1 {
+					return fmt.Errorf("Wrong len:%d!=1", len(users.Data))
+				}
+				users, err = r.Users.GetAll(&ListQuery{IncludeDeleted: true})
+				if err != nil {
+					return err
+				}
+				if len(users.Data) != 2 {
+					return fmt.Errorf("Wrong len with deleted:%d!=2", len(users.Data))
+				}
+				_, err = r.Posts.Restore(f.PostId1)
+				if !errors.Is(err, ErrUserDeleted) {
+					return fmt.Errorf("Restored a post of a deleted user: %v", err)
+				}
+
+				u, err = r.Users.Restore(f.UserId1)
+				if err != nil {
+					return err
+				}
+				if u == nil || u.DeletedAt != nil {
+					return fmt.Errorf("User not restored")
+				}
+				post, err := r.Posts.Get(f.PostId1)
+				if err != nil || post == nil {
+					return fmt.Errorf("Post deleted with the user was not restored")
+				}
+				// Deleted and restored along with the user, so that cached ETags
+				// don't match it.
+				if post.Version != 3 {
+					return fmt.Errorf("Post deleted and restored with the user has version %d", post.Version)
+				}
+				post, err = r.Posts.Get(p.Id)
+				if err != nil || post != nil {
+					return fmt.Errorf("Post deleted on its own was restored")
+				}
+				post, err = r.Posts.Restore(p.Id)
+				if err != nil || post == nil || post.DeletedAt != nil {
+					return fmt.Errorf("Post not restored")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Purge hard deletes old deleted rows",
+			fn: func(r *Repositories) error {
+				_, err := r.Posts.Delete(f.PostId2)
+				if err != nil {
+					return err
+				}
+				_, err = r.Users.Delete(f.UserId1)
+				if err != nil {
+					return err
+				}
+				n, err := r.Posts.Purge(now().Add(-time.Hour))
+				if err != nil || n != 0 {
+					return fmt.Errorf("Purged recent posts: %d %v", n, err)
+				}
+				n, err = r.Posts.Purge(now().Add(time.Hour))
+				if err != nil || n != 2 {
+					return fmt.Errorf("Wrong purged posts: %d %v", n, err)
+				}
+				n, err = r.Users.Purge(now().Add(time.Hour))
+				if err != nil || n != 1 {
+					return fmt.Errorf("Wrong purged users: %d %v", n, err)
+				}
+				u, err := r.Users.GetWithDeleted(f.UserId1)
+				if err != nil || u != nil {
+					return fmt.Errorf("User not purged")
+				}
+				u, err = r.Users.GetWithDeleted(f.UserId2)
+				if err != nil || u == nil {
+					return fmt.Errorf("Live user purged")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Every write bumps the version",
+			fn: func(r *Repositories) error {
+				p, err := r.Posts.Create(&PostInput{"one", "1", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				if p.Version != 1 {
+					return fmt.Errorf("New post has version %d", p.Version)
+				}
+				p, err = r.Posts.Update(p.Id, &PostInput{"two", "2", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				if p.Version != 2 || VersionOfETag(p.ETag()) != `"2"` {
+					return fmt.Errorf("Updated post has version %d, etag %s", p.Version, p.ETag())
+				}
+				p, err = r.Posts.Delete(p.Id)
+				if err != nil {
+					return err
+				}
+				p, err = r.Posts.Restore(p.Id)
+				if err != nil {
+					return err
+				}
+				if p.Version != 4 {
+					return fmt.Errorf("Restored post has version %d", p.Version)
+				}
+				u, err := r.Users.Update(f.UserId2, &UserInput{"name", "name@example.com"})
+				if err != nil {
+					return err
+				}
+				if u.Version != 2 {
+					return fmt.Errorf("Updated user has version %d", u.Version)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Changes to title or content are recorded as revisions",
+			fn: func(r *Repositories) error {
+				p, err := r.Posts.Create(&PostInput{"one", "1", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				_, err = r.Posts.Update(p.Id, &PostInput{"two", "1\n2", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				// Only the author changes, nothing to record.
+				_, err = r.Posts.Update(p.Id, &PostInput{"two", "1\n2", f.UserId2, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+
+				q := &ListQuery{}
+				q.Where(RevisionListSpec.Filters["post_id"], p.Id)
+				revisions, err := r.Revisions.GetAll(q)
+				if err != nil {
+					return err
+				}
+				if len(revisions.Data) != 2 || revisions.Data[0].Rev != 2 || revisions.Data[1].Rev != 1 {
+					return fmt.Errorf("Wrong revisions: %+v", revisions.Data)
+				}
+				rev, err := r.Revisions.Get(p.Id, 1)
+				if err != nil {
+					return err
+				}
+				if rev == nil || rev.Title != "one" || rev.Content != "1" || rev.UserId != f.UserId1 {
+					return fmt.Errorf("Wrong first revision: %+v", rev)
+				}
+				rev, err = r.Revisions.Get(p.Id, 3)
+				if err != nil || rev != nil {
+					return fmt.Errorf("Revision 3 should not exist")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Purged posts lose their revisions",
+			fn: func(r *Repositories) error {
+				p, err := r.Posts.Create(&PostInput{"one", "1", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				_, err = r.Posts.Delete(p.Id)
+				if err != nil {
+					return err
+				}
+				_, err = r.Posts.Purge(now().Add(time.Hour))
+				if err != nil {
+					return err
+				}
+				rev, err := r.Revisions.Get(p.Id, 1)
+				if err != nil || rev != nil {
+					return fmt.Errorf("Revision not purged")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Posts of deleted users are constraint errors, like missing users",
+			fn: func(r *Repositories) error {
+				_, err := r.Users.Delete(f.UserId2)
+				if err != nil {
+					return err
+				}
+				_, err = r.Posts.Create(&PostInput{"one", "1", f.UserId2, nil, "", nil, ""})
+				c, ok := AsConstraintError(err)
+				if !ok || c.Kind != ForeignKeyViolation || c.Field != "user_id" {
+					return fmt.Errorf("Deleted user on create: %v", err)
+				}
+				_, err = r.Posts.Update(f.PostId1, &PostInput{"one", "1", f.UserId2, nil, "", nil, ""})
+				c, ok = AsConstraintError(err)
+				if !ok || c.Kind != ForeignKeyViolation || c.Field != "user_id" {
+					return fmt.Errorf("Deleted user on update: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Many users and posts are created at once, in order",
+			fn: func(r *Repositories) error {
+				// More than fits in one insert.
+				userInputs := []*UserInput{}
+				for i := range insertChunkRows + 1 {
+					userInputs = append(userInputs, &UserInput{fmt.Sprintf("user-%d", i), fmt.Sprintf("user-%d@example.com", i)})
+				}
+				users, err := r.Users.CreateMany(userInputs)
+				if err != nil {
+					return err
+				}
+				if len(users) != len(userInputs) {
+					return fmt.Errorf("Wrong len:%d!=%d", len(users), len(userInputs))
+				}
+				for i, u := range users {
+					if u.Name != userInputs[i].Name || u.Version != 1 {
+						return fmt.Errorf("Wrong user %d: %+v", i, u)
+					}
+				}
+
+				posts, err := r.Posts.CreateMany([]*PostInput{{"one", "1", users[0].Id, nil, "", nil, ""}, {"two", "2", users[1].Id, nil, "", nil, ""}})
+				if err != nil {
+					return err
+				}
+				if len(posts) != 2 || posts[0].Title != "one" || posts[1].UserId != users[1].Id {
+					return fmt.Errorf("Wrong posts: %+v", posts)
+				}
+				rev, err := r.Revisions.Get(posts[1].Id, 1)
+				if err != nil {
+					return err
+				}
+				if rev == nil || rev.Title != "two" {
+					return fmt.Errorf("Wrong first revision: %+v", rev)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Creating many posts of a missing user fails them all",
+			fn: func(r *Repositories) error {
+				_, err := r.Posts.CreateMany([]*PostInput{{"one", "1", f.UserId1, nil, "", nil, ""}, {"two", "2", missing, nil, "", nil, ""}})
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation},
+		},
+		{
+			description: "Idempotency keys keep the response of their request",
+			fn: func(r *Repositories) error {
+				expiresAt := now().Add(time.Hour)
+				k, err := r.Idempotency.Create("key-1", "hash", now().Add(time.Minute), expiresAt)
+				if err != nil {
+					return err
+				}
+				if !k.InFlight() || k.Claimable() || !k.ExpiresAt.Equal(expiresAt) {
+					return fmt.Errorf("Wrong new key: %+v", k)
+				}
+				err = r.Idempotency.Complete("key-1", k.LockedUntil, 201, http.Header{"Etag": {`"1"`}}, []byte(`{"id":1}`))
+				if err != nil {
+					return err
+				}
+				k, err = r.Idempotency.Get("key-1")
+				if err != nil {
+					return err
+				}
+				if k == nil || k.InFlight() || k.Status != 201 || k.Headers.Get("ETag") != `"1"` || string(k.Body) != `{"id":1}` {
+					return fmt.Errorf("Wrong completed key: %+v", k)
+				}
+
+				n, err := r.Idempotency.Purge(expiresAt.Add(time.Second))
+				if err != nil {
+					return err
+				}
+				k, err = r.Idempotency.Get("key-1")
+				if err != nil || n != 1 || k != nil {
+					return fmt.Errorf("Key not purged")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Idempotency keys can't be created twice",
+			fn: func(r *Repositories) error {
+				_, err := r.Idempotency.Create("key-1", "hash", now().Add(time.Minute), now().Add(time.Hour))
+				if err != nil {
+					return err
+				}
+				_, err = r.Idempotency.Create("key-1", "other", now().Add(time.Minute), now().Add(time.Hour))
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: UniqueViolation},
+		},
+		{
+			description: "Idempotency keys are claimed once their lease ran out, unless their request committed",
+			fn: func(r *Repositories) error {
+				expired := now().Add(-time.Second)
+				k, err := r.Idempotency.Create("key-1", "hash", expired, now().Add(time.Hour))
+				if err != nil {
+					return err
+				}
+				if k.InFlight() || !k.Claimable() {
+					return fmt.Errorf("Wrong expired lease: %+v", k)
+				}
+				claimed, err := r.Idempotency.Claim("key-1", now().Add(time.Minute))
+				if err != nil {
+					return err
+				}
+				if claimed == nil || !claimed.InFlight() {
+					return fmt.Errorf("Wrong claimed key: %+v", claimed)
+				}
+				again, err := r.Idempotency.Claim("key-1", now().Add(time.Minute))
+				if err != nil || again != nil {
+					return fmt.Errorf("Key claimed twice: %+v %v", again, err)
+				}
+				// The request that held the lease before can't write any more.
+				err = r.Idempotency.Commit("key-1", k.LockedUntil)
+				if !errors.Is(err, ErrIdempotencyLeaseLost) {
+					return fmt.Errorf("Wrong commit of a lost lease: %v", err)
+				}
+				err = r.Idempotency.Release("key-1", k.LockedUntil)
+				if !errors.Is(err, ErrIdempotencyLeaseLost) {
+					return fmt.Errorf("Wrong release of a lost lease: %v", err)
+				}
+
+				k, err = r.Idempotency.Create("key-2", "hash", expired, now().Add(time.Hour))
+				if err != nil {
+					return err
+				}
+				err = r.Idempotency.Commit("key-2", k.LockedUntil)
+				if err != nil {
+					return err
+				}
+				k, err = r.Idempotency.Get("key-2")
+				if err != nil {
+					return err
+				}
+				if k == nil || k.CommittedAt == nil || !k.InFlight() || k.Claimable() {
+					return fmt.Errorf("Wrong committed key: %+v", k)
+				}
+				claimed, err = r.Idempotency.Claim("key-2", now().Add(time.Minute))
+				if err != nil || claimed != nil {
+					return fmt.Errorf("Committed key claimed: %+v %v", claimed, err)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Posts are searched by title and content, best match first",
+			fn: func(r *Repositories) error {
+				posts, err := r.Posts.CreateMany([]*PostInput{
+					{"Gardening tips", "Water the tomato plants early", f.UserId1, nil, "", nil, ""},
+					{"Cooking", "Tomato soup with basil", f.UserId2, nil, "", nil, ""},
+					{"Tomato harvest", "Picking tomato before the frost", f.UserId1, nil, "", nil, ""},
+				})
+				if err != nil {
+					return err
+				}
+				tests := []struct {
+					text     string
+					values   url.Values
+					expected int
+				}{
+					{"tomato", nil, 3},
+					{"TOMATO", url.Values{"user_id": {f.UserId1.String()}}, 2},
+					{`"tomato soup" or harvest -frost`, nil, 1},
+					{"-tomato", nil, 0},
+					{"gardening basil", nil, 0},
+				}
+				for _, tc := range tests {
+					q, err := ParseListQuery(tc.values, PostSearchListSpec, 10)
+					if err != nil {
+						return err
+					}
+					results, err := r.Posts.Search(tc.text, q)
+					if err != nil {
+						return err
+					}
+					if len(results.Data) != tc.expected {
+						return fmt.Errorf("%q %v: wrong len:%d!=%d", tc.text, tc.values, len(results.Data), tc.expected)
+					}
+				}
+
+				results, err := r.Posts.Search("tomato", nil)
+				if err != nil {
+					return err
+				}
+				if results.Data[0].Id != posts[2].Id || results.Data[0].Rank <= results.Data[1].Rank {
+					return fmt.Errorf("Title match should rank first: %+v", results.Data)
+				}
+				if !strings.Contains(results.Data[0].Headline, "<b>tomato</b>") {
+					return fmt.Errorf("Wrong headline: %q", results.Data[0].Headline)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Search headlines escape the markup of the content",
+			fn: func(r *Repositories) error {
+				_, err := r.Posts.Create(&PostInput{"Markup", `<img src=x onerror=alert(1)> tomato <script>alert(2)</script>`, f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				results, err := r.Posts.Search("tomato", nil)
+				if err != nil {
+					return err
+				}
+				if len(results.Data) != 1 {
+					return fmt.Errorf("Wrong len:%d!=1", len(results.Data))
+				}
+				headline := results.Data[0].Headline
+				if strings.Contains(headline, "<img") || strings.Contains(headline, "<script") || !strings.Contains(headline, "&lt;img") || !strings.Contains(headline, "<b>tomato</b>") {
+					return fmt.Errorf("Wrong headline: %q", headline)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Search results are paged by cursor and skip deleted posts",
+			fn: func(r *Repositories) error {
+				posts, err := r.Posts.CreateMany([]*PostInput{
+					{"one", "tomato", f.UserId1, nil, "", nil, ""},
+					{"two", "tomato tomato", f.UserId1, nil, "", nil, ""},
+					{"three", "tomato", f.UserId2, nil, "", nil, ""},
+					{"four", "tomato", f.UserId2, nil, "", nil, ""},
+				})
+				if err != nil {
+					return err
+				}
+				_, err = r.Posts.Delete(posts[3].Id)
+				if err != nil {
+					return err
+				}
+
+				seen := map[uuid.UUID]bool{}
+				q, err := ParseListQuery(url.Values{"limit": {"1"}}, PostSearchListSpec, 10)
+				if err != nil {
+					return err
+				}
+				for {
+					page, err := r.Posts.Search("tomato", q)
+					if err != nil {
+						return err
+					}
+					for _, p := range page.Data {
+						seen[p.Id] = true
+					}
+					if page.NextCursor == nil {
+						break
+					}
+					q.Cursor, err = DecodeCursor(*page.NextCursor)
+					if err != nil {
+						return err
+					}
+				}
+				if len(seen) != 3 || seen[posts[3].Id] {
+					return fmt.Errorf("Wrong posts: %v", seen)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Posts are filtered by any or all of their tags",
+			fn: func(r *Repositories) error {
+				posts, err := r.Posts.CreateMany([]*PostInput{
+					{"one", "1", f.UserId1, []string{"Go", "db"}, "", nil, ""},
+					{"two", "2", f.UserId1, []string{"go"}, "", nil, ""},
+					{"three", "3", f.UserId2, []string{"db", "sql"}, "", nil, ""},
+				})
+				if err != nil {
+					return err
+				}
+				if !slices.Equal(posts[0].Tags, []string{"db", "go"}) {
+					return fmt.Errorf("Wrong tags: %q", posts[0].Tags)
+				}
+
+				tests := []struct {
+					mode     string
+					tags     []string
+					expected int
+				}{
+					{"any", []string{"go"}, 2},
+					{"any", []string{"go", "sql"}, 3},
+					{"all", []string{"go", "db"}, 1},
+					{"all", []string{"go", "sql"}, 0},
+				}
+				for _, tc := range tests {
+					q := &ListQuery{}
+					q.Where(PostTagFilters[tc.mode], tc.tags)
+					l, err := r.Posts.GetAll(q)
+					if err != nil {
+						return err
+					}
+					if len(l.Data) != tc.expected {
+						return fmt.Errorf("%s %q: wrong len:%d!=%d", tc.mode, tc.tags, len(l.Data), tc.expected)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			description: "Tags are counted on posts that aren't deleted",
+			fn: func(r *Repositories) error {
+				posts, err := r.Posts.CreateMany([]*PostInput{
+					{"one", "1", f.UserId1, []string{"go", "db"}, "", nil, ""},
+					{"two", "2", f.UserId1, []string{"go"}, "", nil, ""},
+					{"three", "3", f.UserId2, []string{"sql"}, "", nil, ""},
+				})
+				if err != nil {
+					return err
+				}
+				_, err = r.Posts.Delete(posts[2].Id)
+				if err != nil {
+					return err
+				}
+
+				tags, err := r.Tags.GetAll(nil)
+				if err != nil {
+					return err
+				}
+				got := []string{}
+				for _, t := range tags.Data {
+					got = append(got, fmt.Sprintf("%s:%d", t.Slug, t.Count))
+				}
+				if !slices.Equal(got, []string{"go:2", "db:1"}) {
+					return fmt.Errorf("Wrong counts: %q", got)
+				}
+				tag, err := r.Tags.Get("SQL")
+				if err != nil {
+					return err
+				}
+				if tag == nil || tag.Count != 0 {
+					return fmt.Errorf("Wrong tag: %+v", tag)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Create post with too many tags, expect fail",
+			fn: func(r *Repositories) error {
+				_, err := r.Posts.Create(&PostInput{"one", "1", f.UserId1, strings.Split("a b c d e f g h i j k", " "), "", nil, ""})
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: CheckViolation, Field: "tags"},
+		},
+		{
+			description: "Comments are listed flat or threaded, oldest first",
+			fn: func(r *Repositories) error {
+				c1, err := r.Comments.Create(f.PostId1, &CommentInput{"one", f.UserId1, nil})
+				if err != nil {
+					return err
+				}
+				r1, err := r.Comments.Create(f.PostId1, &CommentInput{"reply", f.UserId2, &c1.Id})
+				if err != nil {
+					return err
+				}
+				_, err = r.Comments.Create(f.PostId1, &CommentInput{"reply to reply", f.UserId1, &r1.Id})
+				if err != nil {
+					return err
+				}
+				c2, err := r.Comments.Create(f.PostId1, &CommentInput{"two", f.UserId2, nil})
+				if err != nil {
+					return err
+				}
+				_, err = r.Comments.Create(f.PostId2, &CommentInput{"elsewhere", f.UserId2, nil})
+				if err != nil {
+					return err
+				}
+
+				q := &ListQuery{}
+				q.Where(CommentListSpec.Filters["post_id"], f.PostId1)
+				flat, err := r.Comments.GetAll(q)
+				if err != nil {
+					return err
+				}
+				if len(flat.Data) != 4 {
+					return fmt.Errorf("Wrong len:%d!=4", len(flat.Data))
+				}
+
+				q.Where(CommentDepthFilter, 0)
+				roots, err := r.Comments.GetAll(q)
+				if err != nil {
+					return err
+				}
+				if len(roots.Data) != 2 || roots.Data[0].Id != c1.Id || roots.Data[1].Id != c2.Id {
+					return fmt.Errorf("Wrong top level comments: %+v", roots.Data)
+				}
+				replies, err := r.Comments.Replies([]uuid.UUID{c1.Id, c2.Id}, 1)
+				if err != nil {
+					return err
+				}
+				CommentTree(roots.Data, replies)
+				if len(c1.Replies) != 0 {
+					return fmt.Errorf("Tree should not change the created comment")
+				}
+				tree := roots.Data[0]
+				if len(tree.Replies) != 1 || tree.Replies[0].Id != r1.Id || len(tree.Replies[0].Replies) != 0 {
+					return fmt.Errorf("Wrong tree: %+v", tree)
+				}
+				if len(roots.Data[1].Replies) != 0 {
+					return fmt.Errorf("Wrong tree: %+v", roots.Data[1])
+				}
+				return nil
+			},
+		},
+		{
+			description: "Deleting a comment deletes its replies, deleted posts hide theirs",
+			fn: func(r *Repositories) error {
+				c1, err := r.Comments.Create(f.PostId1, &CommentInput{"one", f.UserId1, nil})
+				if err != nil {
+					return err
+				}
+				r1, err := r.Comments.Create(f.PostId1, &CommentInput{"reply", f.UserId2, &c1.Id})
+				if err != nil {
+					return err
+				}
+				c2, err := r.Comments.Create(f.PostId2, &CommentInput{"two", f.UserId1, nil})
+				if err != nil {
+					return err
+				}
+
+				_, err = r.Comments.Delete(c1.Id)
+				if err != nil {
+					return err
+				}
+				if c, err := r.Comments.Get(r1.Id); err != nil || c != nil {
+					return fmt.Errorf("Reply should be deleted: %v %v", c, err)
+				}
+				_, err = r.Posts.Delete(f.PostId2)
+				if err != nil {
+					return err
+				}
+				if c, err := r.Comments.Get(c2.Id); err != nil || c != nil {
+					return fmt.Errorf("Comment of a deleted post should be hidden: %v %v", c, err)
+				}
+				_, err = r.Posts.Restore(f.PostId2)
+				if err != nil {
+					return err
+				}
+				if c, err := r.Comments.Get(c2.Id); err != nil || c == nil {
+					return fmt.Errorf("Comment of a restored post should be back: %v %v", c, err)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Reply to a comment of another post, expect fail",
+			fn: func(r *Repositories) error {
+				c, err := r.Comments.Create(f.PostId1, &CommentInput{"one", f.UserId1, nil})
+				if err != nil {
+					return err
+				}
+				_, err = r.Comments.Create(f.PostId2, &CommentInput{"reply", f.UserId2, &c.Id})
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation, Field: "parent_id"},
+		},
+		{
+			description: "Comment by a deleted user, expect fail",
+			fn: func(r *Repositories) error {
+				_, err := r.Users.Delete(f.UserId2)
+				if err != nil {
+					return err
+				}
+				_, err = r.Comments.Create(f.PostId1, &CommentInput{"one", f.UserId2, nil})
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation, Field: "user_id"},
+		},
+		{
+			description: "Follows are idempotent and counted on live users",
+			fn: func(r *Repositories) error {
+				f1, err := r.Follows.Create(f.UserId2, &FollowInput{f.UserId1})
+				if err != nil {
+					return err
+				}
+				f2, err := r.Follows.Create(f.UserId2, &FollowInput{f.UserId1})
+				if err != nil {
+					return err
+				}
+				if !f2.CreatedAt.Equal(f1.CreatedAt) {
+					return fmt.Errorf("Following again should keep the follow")
+				}
+				followers, err := r.Follows.Followers(f.UserId2, nil)
+				if err != nil {
+					return err
+				}
+				if len(followers.Data) != 1 || followers.Data[0].Id != f.UserId1 {
+					return fmt.Errorf("Wrong followers: %+v", followers.Data)
+				}
+				following, err := r.Follows.Following(f.UserId1, nil)
+				if err != nil {
+					return err
+				}
+				if len(following.Data) != 1 || following.Data[0].Id != f.UserId2 {
+					return fmt.Errorf("Wrong following: %+v", following.Data)
+				}
+				counts, err := r.Follows.Counts(f.UserId2)
+				if err != nil {
+					return err
+				}
+				if *counts != (FollowCounts{Followers: 1}) {
+					return fmt.Errorf("Wrong counts: %+v", counts)
+				}
+
+				_, err = r.Users.Delete(f.UserId1)
+				if err != nil {
+					return err
+				}
+				counts, err = r.Follows.Counts(f.UserId2)
+				if err != nil {
+					return err
+				}
+				if *counts != (FollowCounts{}) {
+					return fmt.Errorf("Deleted followers should not count: %+v", counts)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Feed has the posts of followed users, newest first",
+			fn: func(r *Repositories) error {
+				_, err := r.Follows.Create(f.UserId2, &FollowInput{f.UserId1})
+				if err != nil {
+					return err
+				}
+				p1, err := r.Posts.Create(&PostInput{"one", "1", f.UserId2, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				p2, err := r.Posts.Create(&PostInput{"two", "2", f.UserId2, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				_, err = r.Posts.Create(&PostInput{"own", "3", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				_, err = r.Posts.Delete(f.PostId2)
+				if err != nil {
+					return err
+				}
+
+				feed, err := r.Posts.Feed(f.UserId1, &ListQuery{Limit: 1})
+				if err != nil {
+					return err
+				}
+				if len(feed.Data) != 1 || feed.Data[0].Id != p2.Id || feed.NextCursor == nil {
+					return fmt.Errorf("Wrong first page: %+v", feed.Data)
+				}
+				cursor, err := DecodeCursor(*feed.NextCursor)
+				if err != nil {
+					return err
+				}
+				feed, err = r.Posts.Feed(f.UserId1, &ListQuery{Limit: 1, Cursor: cursor})
+				if err != nil {
+					return err
+				}
+				if len(feed.Data) != 1 || feed.Data[0].Id != p1.Id || feed.NextCursor != nil {
+					return fmt.Errorf("Wrong last page: %+v", feed.Data)
+				}
+
+				_, err = r.Follows.Delete(f.UserId1, f.UserId2)
+				if err != nil {
+					return err
+				}
+				feed, err = r.Posts.Feed(f.UserId1, nil)
+				if err != nil {
+					return err
+				}
+				if len(feed.Data) != 0 {
+					return fmt.Errorf("Feed should be empty after unfollowing: %+v", feed.Data)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Follow oneself, expect fail",
+			fn: func(r *Repositories) error {
+				_, err := r.Follows.Create(f.UserId1, &FollowInput{f.UserId1})
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: CheckViolation, Field: "follower_id"},
+		},
+		{
+			description: "Follow a deleted user, expect fail",
+			fn: func(r *Repositories) error {
+				_, err := r.Users.Delete(f.UserId2)
+				if err != nil {
+					return err
+				}
+				_, err = r.Follows.Create(f.UserId2, &FollowInput{f.UserId1})
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation, Field: "followee_id"},
+		},
+		{
+			description: "Reactions are counted on posts until their user is purged",
+			fn: func(r *Repositories) error {
+				for _, userId := range []uuid.UUID{f.UserId1, f.UserId2, f.UserId2} {
+					_, err := r.Reactions.Create(f.PostId1, userId, "like")
+					if err != nil {
+						return err
+					}
+				}
+				_, err := r.Reactions.Create(f.PostId1, f.UserId2, "wow")
+				if err != nil {
+					return err
+				}
+				p, err := r.Posts.Get(f.PostId1)
+				if err != nil {
+					return err
+				}
+				if p.Reactions["like"] != 2 || p.Reactions["wow"] != 1 || len(p.Reactions) != 2 {
+					return fmt.Errorf("Wrong counts: %v", p.Reactions)
+				}
+
+				_, err = r.Users.Delete(f.UserId2)
+				if err != nil {
+					return err
+				}
+				_, err = r.Users.Purge(now().Add(time.Second))
+				if err != nil {
+					return err
+				}
+				p, err = r.Posts.Get(f.PostId1)
+				if err != nil {
+					return err
+				}
+				if p.Reactions["like"] != 1 || len(p.Reactions) != 1 {
+					return fmt.Errorf("Wrong counts after purge: %v", p.Reactions)
+				}
+				return nil
+			},
+		},
+		{
+			description: "React to a deleted post, expect fail",
+			fn: func(r *Repositories) error {
+				_, err := r.Posts.Delete(f.PostId1)
+				if err != nil {
+					return err
+				}
+				_, err = r.Reactions.Create(f.PostId1, f.UserId1, "like")
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation, Field: "post_id"},
+		},
+		{
+			description: "Posts move from draft to published, and only published ones are listed",
+			fn: func(r *Repositories) error {
+				p, err := r.Posts.Create(&PostInput{"draft", "content", f.UserId1, []string{"go"}, PostDraft, nil, ""})
+				if err != nil {
+					return err
+				}
+				if p.Status != PostDraft || p.PublishedAt != nil {
+					return fmt.Errorf("Wrong new draft: %s %v", p.Status, p.PublishedAt)
+				}
+				published := &ListQuery{Filters: []Condition{{PostStatusFilter, PostPublished}}}
+				l, err := r.Posts.GetAll(published)
+				if err != nil {
+					return err
+				}
+				if slices.ContainsFunc(l.Data, func(post *Post) bool { return post.Id == p.Id }) {
+					return fmt.Errorf("Draft listed")
+				}
+				tag, err := r.Tags.Get("go")
+				if err != nil {
+					return err
+				}
+				if tag.Count != 0 {
+					return fmt.Errorf("Draft counted in its tags: %d", tag.Count)
+				}
+
+				p, err = r.Posts.Update(p.Id, &PostInput{"draft", "content", f.UserId1, nil, PostPublished, nil, ""})
+				if err != nil {
+					return err
+				}
+				if p.Status != PostPublished || p.PublishedAt == nil {
+					return fmt.Errorf("Wrong published post: %s %v", p.Status, p.PublishedAt)
+				}
+				publishedAt := *p.PublishedAt
+				p, err = r.Posts.Update(p.Id, &PostInput{"draft", "edited", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				if p.Status != PostPublished || !p.PublishedAt.Equal(publishedAt) {
+					return fmt.Errorf("Update without status changed it: %s %v", p.Status, p.PublishedAt)
+				}
+				l, err = r.Posts.GetAll(published)
+				if err != nil {
+					return err
+				}
+				if !slices.ContainsFunc(l.Data, func(post *Post) bool { return post.Id == p.Id }) {
+					return fmt.Errorf("Published post not listed")
+				}
+				return nil
+			},
+		},
+		{
+			description: "Scheduled posts are published once they are due",
+			fn: func(r *Repositories) error {
+				due := now().Add(-time.Minute)
+				later := now().Add(time.Hour)
+				p, err := r.Posts.Create(&PostInput{"due", "content", f.UserId1, nil, PostScheduled, &due, ""})
+				if err != nil {
+					return err
+				}
+				if p.Status != PostScheduled || p.PublishAt == nil || !p.PublishAt.Equal(due) {
+					return fmt.Errorf("Wrong scheduled post: %s %v", p.Status, p.PublishAt)
+				}
+				_, err = r.Posts.Create(&PostInput{"later", "content", f.UserId1, nil, PostScheduled, &later, ""})
+				if err != nil {
+					return err
+				}
+
+				n, err := r.Posts.Publish(now())
+				if err != nil {
+					return err
+				}
+				if n != 1 {
+					return fmt.Errorf("Wrong published:%d!=1", n)
+				}
+				n, err = r.Posts.Publish(now())
+				if err != nil {
+					return err
+				}
+				if n != 0 {
+					return fmt.Errorf("Published twice:%d", n)
+				}
+				p, err = r.Posts.Get(p.Id)
+				if err != nil {
+					return err
+				}
+				if p.Status != PostPublished || p.PublishAt != nil || p.PublishedAt == nil || p.Version != 2 {
+					return fmt.Errorf("Wrong published post: %s %v %v %d", p.Status, p.PublishAt, p.PublishedAt, p.Version)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Markdown content is rendered, sanitized and kept up to date",
+			fn: func(r *Repositories) error {
+				content := "# Hello\n\n<script>alert(1)</script>[link](javascript:alert(1))"
+				p, err := r.Posts.Create(&PostInput{"one", content, f.UserId1, nil, "", nil, ContentMarkdown})
+				if err != nil {
+					return err
+				}
+				if p.ContentFormat != ContentMarkdown || p.ContentHTML == nil {
+					return fmt.Errorf("Wrong format or missing HTML: %s %v", p.ContentFormat, p.ContentHTML)
+				}
+				html := *p.ContentHTML
+				if !strings.Contains(html, "<h1>Hello</h1>") || strings.Contains(html, "script") || strings.Contains(html, "javascript") {
+					return fmt.Errorf("Wrong HTML: %q", html)
+				}
+				p, err = r.Posts.Get(p.Id)
+				if err != nil {
+					return err
+				}
+				if p.ContentHTML == nil || *p.ContentHTML != html {
+					return fmt.Errorf("Wrong HTML read: %v", p.ContentHTML)
+				}
+
+				// Updates without a format keep it.
+				p, err = r.Posts.Update(p.Id, &PostInput{"two", content, f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				if p.ContentFormat != ContentMarkdown || p.ContentHTML == nil || *p.ContentHTML != html {
+					return fmt.Errorf("Wrong HTML after update: %s %v", p.ContentFormat, p.ContentHTML)
+				}
+				p, err = r.Posts.Update(p.Id, &PostInput{"two", "*plain*", f.UserId1, nil, "", nil, ContentPlain})
+				if err != nil {
+					return err
+				}
+				if p.ContentHTML == nil || *p.ContentHTML != "<p>*plain*</p>\n" {
+					return fmt.Errorf("Wrong plain HTML: %v", p.ContentHTML)
+				}
+				l, err := r.Posts.GetAll(nil)
+				if err != nil {
+					return err
+				}
+				for _, post := range l.Data {
+					if post.ContentHTML == nil {
+						return fmt.Errorf("HTML not loaded: %s", post.Id)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			description: "Slugs and handles are unique and old ones still lead to their rows",
+			fn: func(r *Repositories) error {
+				posts, err := r.Posts.CreateMany([]*PostInput{{"Title 1", "1", f.UserId1, nil, "", nil, ""}, {"Tïtle 1", "1", f.UserId1, nil, "", nil, ""}})
+				if err != nil {
+					return err
+				}
+				if posts[0].Slug != "title-1-2" || posts[1].Slug != "title-1-3" {
+					return fmt.Errorf("Wrong slugs: %q %q", posts[0].Slug, posts[1].Slug)
+				}
+				p, err := r.Posts.Update(posts[0].Id, &PostInput{"Renamed", "1", f.UserId1, nil, "", nil, ""})
+				if err != nil {
+					return err
+				}
+				if p.Slug != "renamed" {
+					return fmt.Errorf("Wrong slug after a rename: %q", p.Slug)
+				}
+				p, err = r.Posts.GetBySlug("title-1-2")
+				if err != nil {
+					return err
+				}
+				if p == nil || p.Id != posts[0].Id || p.Slug != "renamed" {
+					return fmt.Errorf("Old slug should lead to the post: %+v", p)
+				}
+
+				u, err := r.Users.Create(&UserInput{"User 1", "u@example.com"})
+				if err != nil {
+					return err
+				}
+				if u.Handle != "user-1-2" {
+					return fmt.Errorf("Wrong handle: %q", u.Handle)
+				}
+				u, err = r.Users.Update(u.Id, &UserInput{"Renamed", "u@example.com"})
+				if err != nil {
+					return err
+				}
+				u, err = r.Users.GetByHandle("user-1-2")
+				if err != nil {
+					return err
+				}
+				if u == nil || u.Handle != "renamed" {
+					return fmt.Errorf("Old handle should lead to the user: %+v", u)
+				}
+
+				_, err = r.Posts.Delete(posts[1].Id)
+				if err != nil {
+					return err
+				}
+				p, err = r.Posts.GetBySlug("title-1-3")
+				if err != nil {
+					return err
+				}
+				if p != nil {
+					return fmt.Errorf("Deleted post found by slug: %+v", p)
+				}
+				return nil
+			},
+		},
+		{
+			description: "Create post on non existing user, expect fail",
+			fn: func(r *Repositories) error {
+				_, err := r.Posts.Create(&PostInput{"one", "1", missing, nil, "", nil, ""})
+				return err
+			},
+			expectError:      true,
+			expectConstraint: &ConstraintError{Kind: ForeignKeyViolation},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			store, err := newStore()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			err = store.BeginTx(context.Background(), nil, tc.fn)
+			if tc.expectError {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				if c := tc.expectConstraint; c != nil {
+					got, ok := AsConstraintError(err)
+					if !ok || got.Kind != c.Kind || (c.Field != "" && got.Field != c.Field) {
+						t.Errorf("Expected a constraint error on %q, got %v", c.Field, err)
+					}
+				}
+			} else {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+
+	t.Run("Writes are recorded in the audit log", func(t *testing.T) {
+		store, err := newStore()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx := ContextWithAuditor(context.Background(), Auditor{Actor: "admin", RequestId: "request-1", IP: "10.0.0.1"})
+		var postId uuid.UUID
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			p, err := r.Posts.Create(&PostInput{"one", "1", f.UserId1, nil, "", nil, ""})
+			if err != nil {
+				return err
+			}
+			postId = p.Id
+			_, err = r.Posts.Update(p.Id, &PostInput{"two", "2", f.UserId1, nil, "", nil, ""})
+			if err != nil {
+				return err
+			}
+			_, err = r.Users.Delete(f.UserId2)
+			if err != nil {
+				return err
+			}
+			// Neither changes anything.
+			_, err = r.Users.Restore(f.UserId1)
+			if err != nil {
+				return err
+			}
+			_, err = r.Posts.Delete(missing)
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		err = store.BeginTx(context.Background(), nil, func(r *Repositories) error {
+			_, err := r.Users.Update(f.UserId1, &UserInput{"name", "name@example.com"})
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			records, err := r.Audit.GetAll(nil)
+			if err != nil {
+				return err
+			}
+			if len(records.Data) != 3 {
+				return fmt.Errorf("Wrong len:%d!=3", len(records.Data))
+			}
+			byAction := map[string]*AuditRecord{}
+			for _, rec := range records.Data {
+				if rec.Actor != "admin" || rec.RequestId != "request-1" || rec.IP != "10.0.0.1" {
+					return fmt.Errorf("Wrong auditor: %+v", rec)
+				}
+				byAction[rec.EntityType+" "+rec.Action] = rec
+			}
+
+			created := byAction["post create"]
+			if created == nil || created.EntityId != postId || created.Before != nil || !strings.Contains(string(created.After), `"title":"one"`) {
+				return fmt.Errorf("Wrong create: %+v", created)
+			}
+			updated := byAction["post update"]
+			if updated == nil || !strings.Contains(string(updated.Before), `"title":"one"`) || !strings.Contains(string(updated.After), `"title":"two"`) {
+				return fmt.Errorf("Wrong update: %+v", updated)
+			}
+			deleted := byAction["user delete"]
+			if deleted == nil || deleted.EntityId != f.UserId2 || !strings.Contains(string(deleted.After), `"deletedAt":"`) {
+				return fmt.Errorf("Wrong delete: %+v", deleted)
+			}
+
+			q, err := ParseListQuery(url.Values{"entity_id": {postId.String()}, "sort": {"created_at"}}, AuditListSpec, 10)
+			if err != nil {
+				return err
+			}
+			records, err = r.Audit.GetAll(q)
+			if err != nil {
+				return err
+			}
+			if len(records.Data) != 2 {
+				return fmt.Errorf("Wrong len for the post:%d!=2", len(records.Data))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Follows are recorded in the audit log", func(t *testing.T) {
+		store, err := newStore()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx := ContextWithAuditor(context.Background(), Auditor{Actor: "anonymous", RequestId: "request-2", IP: "10.0.0.2"})
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			// Following twice changes nothing the second time.
+			for range 2 {
+				_, err := r.Follows.Create(f.UserId2, &FollowInput{f.UserId1})
+				if err != nil {
+					return err
+				}
+			}
+			_, err := r.Follows.Delete(f.UserId1, f.UserId2)
+			if err != nil {
+				return err
+			}
+			_, err = r.Follows.Delete(f.UserId1, f.UserId2)
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			records, err := r.Audit.GetAll(nil)
+			if err != nil {
+				return err
+			}
+			if len(records.Data) != 2 {
+				return fmt.Errorf("Wrong len:%d!=2", len(records.Data))
+			}
+			byAction := map[string]*AuditRecord{}
+			for _, rec := range records.Data {
+				byAction[rec.EntityType+" "+rec.Action] = rec
+			}
+
+			created := byAction["follow create"]
+			if created == nil || created.EntityId != f.UserId2 || created.Before != nil || created.After == nil {
+				return fmt.Errorf("Wrong follow create: %+v", created)
+			}
+			deleted := byAction["follow delete"]
+			if deleted == nil || deleted.EntityId != f.UserId2 || deleted.Before == nil || deleted.After != nil {
+				return fmt.Errorf("Wrong follow delete: %+v", deleted)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Reactions are recorded in the audit log", func(t *testing.T) {
+		store, err := newStore()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx := ContextWithAuditor(context.Background(), Auditor{Actor: "anonymous", RequestId: "request-3", IP: "10.0.0.3"})
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			// Reacting and removing the reaction twice change nothing the
+			// second time.
+			for range 2 {
+				_, err := r.Reactions.Create(f.PostId2, f.UserId1, "like")
+				if err != nil {
+					return err
+				}
+			}
+			for range 2 {
+				_, err := r.Reactions.Delete(f.PostId2, f.UserId1, "like")
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			records, err := r.Audit.GetAll(nil)
+			if err != nil {
+				return err
+			}
+			if len(records.Data) != 2 {
+				return fmt.Errorf("Wrong len:%d!=2", len(records.Data))
+			}
+			byAction := map[string]*AuditRecord{}
+			for _, rec := range records.Data {
+				byAction[rec.EntityType+" "+rec.Action] = rec
+			}
+
+			for _, key := range []string{"reaction create", "reaction delete"} {
+				if rec := byAction[key]; rec == nil || rec.EntityId != f.PostId2 || !strings.Contains(string(append(rec.Before, rec.After...)), `"kind":"like"`) {
+					return fmt.Errorf("Wrong %s: %+v", key, rec)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Writes to comments are recorded in the audit log", func(t *testing.T) {
+		store, err := newStore()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx := ContextWithAuditor(context.Background(), Auditor{Actor: "anonymous", RequestId: "request-4", IP: "10.0.0.4"})
+		var commentId uuid.UUID
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			c, err := r.Comments.Create(f.PostId1, &CommentInput{"one", f.UserId1, nil})
+			if err != nil {
+				return err
+			}
+			commentId = c.Id
+			_, err = r.Comments.Update(c.Id, &CommentInput{"two", f.UserId1, nil})
+			if err != nil {
+				return err
+			}
+			_, err = r.Comments.Delete(c.Id)
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			records, err := r.Audit.GetAll(nil)
+			if err != nil {
+				return err
+			}
+			if len(records.Data) != 3 {
+				return fmt.Errorf("Wrong len:%d!=3", len(records.Data))
+			}
+			byAction := map[string]*AuditRecord{}
+			for _, rec := range records.Data {
+				byAction[rec.EntityType+" "+rec.Action] = rec
+			}
+
+			created := byAction["comment create"]
+			if created == nil || created.EntityId != commentId || created.Before != nil || !strings.Contains(string(created.After), `"content":"one"`) {
+				return fmt.Errorf("Wrong comment create: %+v", created)
+			}
+			updated := byAction["comment update"]
+			if updated == nil || !strings.Contains(string(updated.Before), `"content":"one"`) || !strings.Contains(string(updated.After), `"content":"two"`) {
+				return fmt.Errorf("Wrong comment update: %+v", updated)
+			}
+			deleted := byAction["comment delete"]
+			if deleted == nil || !strings.Contains(string(deleted.Before), `"content":"two"`) || deleted.After != nil {
+				return fmt.Errorf("Wrong comment delete: %+v", deleted)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Revisions are by the viewer, apart from the owner of the post", func(t *testing.T) {
+		store, err := newStore()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var posts []*Post
+		ctx := ContextWithViewer(context.Background(), f.UserId2)
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			p, err := r.Posts.Create(&PostInput{"one", "1", f.UserId1, nil, "", nil, ""})
+			if err != nil {
+				return err
+			}
+			posts, err = r.Posts.CreateMany([]*PostInput{{"two", "2", f.UserId1, nil, "", nil, ""}})
+			posts = append(posts, p)
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// Without a viewer, nobody is known to have made the change.
+		err = store.BeginTx(context.Background(), nil, func(r *Repositories) error {
+			_, err := r.Posts.Update(posts[0].Id, &PostInput{"three", "3", f.UserId1, nil, "", nil, ""})
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = store.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			for _, p := range posts {
+				rev, err := r.Revisions.Get(p.Id, 1)
+				if err != nil {
+					return err
+				}
+				if rev == nil || rev.UserId != f.UserId1 || rev.AuthorId == nil || *rev.AuthorId != f.UserId2 {
+					return fmt.Errorf("Wrong first revision: %+v", rev)
+				}
+			}
+			rev, err := r.Revisions.Get(posts[0].Id, 2)
+			if err != nil {
+				return err
+			}
+			if rev == nil || rev.UserId != f.UserId1 || rev.AuthorId != nil {
+				return fmt.Errorf("Wrong revision without a viewer: %+v", rev)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Failed transactions are rolled back", func(t *testing.T) {
+		store, err := newStore()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx := context.Background()
+		boom := errors.New("boom")
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			_, err := r.Users.Create(&UserInput{"one", "1"})
+			if err != nil {
+				return err
+			}
+			_, err = r.Posts.Delete(f.PostId1)
+			if err != nil {
+				return err
+			}
+			return boom
+		})
+		if !errors.Is(err, boom) {
+			t.Errorf("Expected boom, got %v", err)
+			return
+		}
+
+		err = store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			users, err := r.Users.GetAll(nil)
+			if err != nil {
+				return err
+			}
+			if len(users.Data) != 2 {
+				return fmt.Errorf("Wrong len:%d!=2", len(users.Data))
+			}
+			p, err := r.Posts.Get(f.PostId1)
+			if err != nil {
+				return err
+			}
+			if p == nil {
+				return fmt.Errorf("Delete was not rolled back")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Commits of writes are reported to the context", func(t *testing.T) {
+		store, err := newStore()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx := ContextWithCommits(context.Background())
+		err = store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			_, err := r.Users.Get(f.UserId1)
+			return err
+		})
+		if err != nil || Committed(ctx) {
+			t.Errorf("Read only transaction reported: %v", err)
+		}
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			_, err := r.Users.Create(&UserInput{"one", "1"})
+			if err != nil {
+				return err
+			}
+			return errors.New("boom")
+		})
+		if err == nil || Committed(ctx) {
+			t.Errorf("Rolled back transaction reported: %v", err)
+		}
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			_, err := r.Users.Create(&UserInput{"one", "1"})
+			return err
+		})
+		if err != nil || !Committed(ctx) {
+			t.Errorf("Commit not reported: %v", err)
+		}
+	})
+
+	t.Run("Commits of writes mark the idempotency key of the context committed", func(t *testing.T) {
+		store, err := newStore()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var k *IdempotencyKey
+		err = store.BeginTx(context.Background(), nil, func(r *Repositories) error {
+			k, err = r.Idempotency.Create("key-1", "hash", now().Add(-time.Second), now().Add(time.Hour))
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ctx := ContextWithIdempotencyKey(context.Background(), k.Key, k.LockedUntil)
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			_, err := r.Users.Create(&UserInput{"one", "1"})
+			return err
+		})
+		if err != nil || !Committed(ctx) {
+			t.Errorf("Commit not reported: %v", err)
+			return
+		}
+		err = store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			k, err := r.Idempotency.Get("key-1")
+			if err != nil {
+				return err
+			}
+			if k == nil || k.CommittedAt == nil || k.Claimable() {
+				return fmt.Errorf("Key not committed: %+v", k)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+
+		// A request whose key was claimed by a retry can't write.
+		err = store.BeginTx(context.Background(), nil, func(r *Repositories) error {
+			k, err = r.Idempotency.Create("key-2", "hash", now().Add(-time.Second), now().Add(time.Hour))
+			if err != nil {
+				return err
+			}
+			_, err = r.Idempotency.Claim("key-2", now().Add(time.Minute))
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ctx = ContextWithIdempotencyKey(context.Background(), k.Key, k.LockedUntil)
+		err = store.BeginTx(ctx, nil, func(r *Repositories) error {
+			_, err := r.Users.Create(&UserInput{"two", "2"})
+			return err
+		})
+		if !errors.Is(err, ErrIdempotencyLeaseLost) || Committed(ctx) {
+			t.Errorf("Wrong write of a lost lease: %v", err)
+		}
+	})
+
+	t.Run("Posts say which kinds the viewer reacted with", func(t *testing.T) {
+		store, err := newStore()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = store.BeginTx(context.Background(), nil, func(r *Repositories) error {
+			_, err := r.Reactions.Create(f.PostId1, f.UserId1, "like")
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx := ContextWithViewer(context.Background(), f.UserId1)
+		err = store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			posts, err := r.Posts.GetAll(nil)
+			if err != nil {
+				return err
+			}
+			for _, p := range posts.Data {
+				expected := []string{}
+				if p.Id == f.PostId1 {
+					expected = []string{"like"}
+				}
+				if !slices.Equal(p.Reacted, expected) {
+					return fmt.Errorf("Wrong reacted of %s: %q", p.Title, p.Reacted)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = store.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true}, func(r *Repositories) error {
+			p, err := r.Posts.Get(f.PostId1)
+			if err != nil {
+				return err
+			}
+			if p.Reacted != nil {
+				return fmt.Errorf("Reacted without a viewer: %q", p.Reacted)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PostRevision is the title and content of a post after one of its changes.
+// UserId is the post's owner at the time, and AuthorId the user who made the
+// change, nil when the request didn't say.
+type PostRevision struct {
+	Id            uuid.UUID  `json:"id" db:"id"`
+	PostId        uuid.UUID  `json:"post_id" db:"post_id"`
+	Rev           int        `json:"rev" db:"rev"`
+	UserId        uuid.UUID  `json:"user_id" db:"user_id"`
+	AuthorId      *uuid.UUID `json:"author_id" db:"author_id"`
+	Title         string     `json:"title" db:"title"`
+	Content       string     `json:"content" db:"content"`
+	ContentFormat string     `json:"content_format" db:"content_format"`
+	CreatedAt     time.Time  `json:"createdAt" db:"created_at"`
+}
+
+const REVISION_FIELDS = "id, post_id, rev, user_id, author_id, title, content, content_format, created_at"
+
+// RevisionListSpec declares the filters and sorts of
+// GET /api/posts/:id/revisions, which sets post_id from the path.
+var RevisionListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"post_id": {Field{"post_id", UUIDField}, "="},
+	},
+	Sorts: map[string]Field{
+		"created_at": {"created_at", TimeField},
+	},
+}
+
+// dest returns the scan destinations for REVISION_FIELDS.
+func (r *PostRevision) dest() []any {
+	return []any{&r.Id, &r.PostId, &r.Rev, &r.UserId, &r.AuthorId, &r.Title, &r.Content, &r.ContentFormat, &r.CreatedAt}
+}
+
+// authorFrom is who makes the changes of a transaction with ctx, as
+// recorded in the revisions of posts: the viewer, if there is one.
+func authorFrom(ctx context.Context) *uuid.UUID {
+	id, ok := ViewerFrom(ctx)
+	if !ok {
+		return nil
+	}
+	return &id
+}
+
+// postsRecordRevisionTx adds a revision for the post as just written by
+// authorId, unless its title, content and content format are the same as in
+// the last revision. The content is rendered as HTML once per revision,
+// which keeps it, and the post gets the HTML of the revision either way.
+func postsRecordRevisionTx(tx *sql.Tx, post *Post, authorId *uuid.UUID) error {
+	last := PostRevision{}
+	var html *string
+	err := tx.QueryRow(`SELECT rev, title, content, content_format, content_html FROM post_revisions WHERE post_id=$1 ORDER BY rev DESC LIMIT 1`, post.Id).Scan(&last.Rev, &last.Title, &last.Content, &last.ContentFormat, &html)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && last.Title == post.Title && last.Content == post.Content && last.ContentFormat == post.ContentFormat {
+		post.setContentHTML(html)
+		return nil
+	}
+
+	createdAt := post.CreatedAt
+	if post.UpdatedAt != nil {
+		createdAt = *post.UpdatedAt
+	}
+	post.setContentHTML(nil)
+	_, err = tx.Exec(`INSERT INTO post_revisions (id, post_id, rev, user_id, author_id, title, content, content_format, content_html, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		uuid.New(), post.Id, last.Rev+1, post.UserId, authorId, post.Title, post.Content, post.ContentFormat, post.ContentHTML, createdAt)
+	return err
+}
+
+// postsRecordFirstRevisionsTx adds revision 1 of posts that authorId just
+// created, in a single insert, and renders their content.
+func postsRecordFirstRevisionsTx(tx *sql.Tx, posts []*Post, authorId *uuid.UUID) error {
+	args := make([]any, 0, 10*len(posts))
+	for _, post := range posts {
+		post.setContentHTML(nil)
+		args = append(args, uuid.New(), post.Id, 1, post.UserId, authorId, post.Title, post.Content, post.ContentFormat, post.ContentHTML, post.CreatedAt)
+	}
+	s := fmt.Sprintf(`INSERT INTO post_revisions (id, post_id, rev, user_id, author_id, title, content, content_format, content_html, created_at) VALUES %s`, valuesList(len(posts), 10))
+	_, err := tx.Exec(s, args...)
+	return err
+}
+
+func RevisionsGetTx(tx *sql.Tx, postId uuid.UUID, rev int) (*PostRevision, error) {
+	revision := PostRevision{}
+	s := fmt.Sprintf(`SELECT %s FROM post_revisions WHERE post_id=$1 AND rev=$2`, REVISION_FIELDS)
+	err := tx.QueryRow(s, postId, rev).Scan(revision.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &revision, err
+}
+
+// RevisionsGetAllTx returns one page of revisions, filtered and sorted by q.
+func RevisionsGetAllTx(tx *sql.Tx, q *ListQuery) (*List[PostRevision], error) {
+	where, args, err := q.where(1)
+	if err != nil {
+		return nil, err
+	}
+	s := fmt.Sprintf(`SELECT %s FROM post_revisions WHERE %s ORDER BY %s LIMIT %d`, REVISION_FIELDS, where, q.orderBy(), q.limit()+1)
+	rows, err := tx.Query(s, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*PostRevision{}
+	for rows.Next() {
+		revision := PostRevision{}
+		err := rows.Scan(revision.dest()...)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return newList(revisions, q, (*PostRevision).field), nil
+}
+
+// field returns the value of a column, for list queries.
+func (r *PostRevision) field(column string) any {
+	switch column {
+	case "id":
+		return r.Id
+	case "post_id":
+		return r.PostId
+	case "created_at":
+		return r.CreatedAt
+	}
+	panic("unknown revision field " + column)
+}
+
+// DiffLine is one line of a diff, with Op "equal", "delete" or "insert".
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// RevisionDiff is how the title and content changed from one revision of a
+// post to another. From is 0 when comparing with the empty post before the
+// first revision.
+type RevisionDiff struct {
+	From    int        `json:"from"`
+	To      int        `json:"to"`
+	Title   []DiffLine `json:"title"`
+	Content []DiffLine `json:"content"`
+}
+
+// NewRevisionDiff diffs two revisions line by line. from may be nil for
+// the empty post.
+func NewRevisionDiff(from, to *PostRevision) *RevisionDiff {
+	if from == nil {
+		from = &PostRevision{}
+	}
+	return &RevisionDiff{
+		From:    from.Rev,
+		To:      to.Rev,
+		Title:   DiffLines(from.Title, to.Title),
+		Content: DiffLines(from.Content, to.Content),
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+// DiffLines is a shortest line diff of a and b, found with Myers' algorithm.
+func DiffLines(a, b string) []DiffLine {
+	x, y := splitLines(a), splitLines(b)
+	n, m := len(x), len(y)
+	max := n + m
+	off := max + 1
+
+	// v[off+k] is the furthest x reached on diagonal k. trace keeps the part
+	// of v each round started from, diagonals -d-1 to d+1, to walk back the
+	// path once the end is reached.
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+	for d := 0; d <= max; d++ {
+		trace = append(trace, slices.Clone(v[off-d-1:off+d+2]))
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				i = v[off+k+1]
+			} else {
+				i = v[off+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			v[off+k] = i
+			if i >= n && j >= m {
+				return backtrackDiff(trace, x, y)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrackDiff(trace [][]int, x, y []string) []DiffLine {
+	lines := []DiffLine{}
+	i, j := len(x), len(y)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := func(k int) int { return trace[d][k+d+1] }
+		k := i - j
+		prevK := k - 1
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		}
+		prevI := v(prevK)
+		prevJ := prevI - prevK
+		for i > prevI && j > prevJ {
+			lines = append(lines, DiffLine{"equal", x[i-1]})
+			i--
+			j--
+		}
+		if d == 0 {
+			break
+		}
+		if i == prevI {
+			lines = append(lines, DiffLine{"insert", y[j-1]})
+		} else {
+			lines = append(lines, DiffLine{"delete", x[i-1]})
+		}
+		i, j = prevI, prevJ
+	}
+	slices.Reverse(lines)
+	return lines
+}
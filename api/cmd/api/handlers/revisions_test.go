@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		description string
+		a           string
+		b           string
+		expected    string
+	}{
+		{
+			description: "Both empty",
+			expected:    "",
+		},
+		{
+			description: "From empty",
+			b:           "one\ntwo",
+			expected:    "+one +two",
+		},
+		{
+			description: "To empty",
+			a:           "one\ntwo",
+			expected:    "-one -two",
+		},
+		{
+			description: "Unchanged",
+			a:           "one\ntwo",
+			b:           "one\ntwo",
+			expected:    "=one =two",
+		},
+		{
+			description: "Changed line in the middle",
+			a:           "one\ntwo\nthree",
+			b:           "one\n2\nthree",
+			expected:    "=one -two +2 =three",
+		},
+		{
+			description: "Myers example",
+			a:           "a\nb\nc\na\nb\nb\na",
+			b:           "c\nb\na\nb\na\nc",
+			expected:    "-a -b =c +b =a =b -b =a +c",
+		},
+	}
+
+	ops := map[string]string{"equal": "=", "delete": "-", "insert": "+"}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			got := ""
+			for i, l := range DiffLines(tc.a, tc.b) {
+				if i > 0 {
+					got += " "
+				}
+				got += fmt.Sprintf("%s%s", ops[l.Op], l.Text)
+			}
+			if got != tc.expected {
+				t.Fatalf("Wrong diff: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+
+	"api/cmd/api/utils"
+)
+
+// PostSearchResult is a post that matches a search, with how well it
+// matches and a snippet of its content with the matches in <b> tags.
+type PostSearchResult struct {
+	Post
+	Rank     float64 `json:"rank"`
+	Headline string  `json:"headline"`
+}
+
+// PostSearchListSpec declares the filters and sorts of GET
+// /api/posts/search. Results come best match first unless sorted
+// otherwise.
+var PostSearchListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"user_id": {Field{"user_id", UUIDField}, "="},
+		"status":  PostStatusFilter,
+	},
+	Sorts: map[string]Field{
+		"rank":       {"rank", FloatField},
+		"created_at": {"created_at", TimeField},
+	},
+	DefaultSort: []SortKey{{Field{"rank", FloatField}, true}},
+}
+
+// postgresSearch ranks the matches with ts_rank, where the title counts
+// more than the content, and pages them before the costly ts_headline,
+// which marks the matches with headlineStart and headlineStop.
+const postgresSearch = `SELECT %[1]s, rank, ts_headline('english', content, query, 'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxFragments=2, MaxWords=30, MinWords=10') FROM (
+	SELECT * FROM (
+		SELECT %[1]s, ts_rank(search, query) AS rank, query
+		FROM posts, websearch_to_tsquery('english', $1) AS query
+		WHERE search @@ query AND deleted_at IS NULL
+	) AS matches WHERE %[2]s ORDER BY %[3]s LIMIT %[4]d
+) AS page ORDER BY %[3]s`
+
+// sqliteSearch is postgresSearch on the FTS5 table. bm25 is lower for
+// better matches, so its opposite is the rank, and weighs the columns like
+// ts_rank weighs A and B. The table has a rank column of its own, hence
+// the score alias until it is out of the way.
+const sqliteSearch = `SELECT * FROM (
+	SELECT %[1]s, score AS rank, headline FROM posts JOIN (
+		SELECT post_id, -bm25(posts_search, 0, 1.0, 0.4) AS score, snippet(posts_search, 2, char(2), char(3), ' ... ', 30) AS headline
+		FROM posts_search WHERE posts_search MATCH $1
+	) AS matches ON matches.post_id = posts.id
+	WHERE deleted_at IS NULL
+) WHERE %[2]s ORDER BY %[3]s LIMIT %[4]d`
+
+// PostsSearchTx returns one page of the posts matching text, in
+// websearch_to_tsquery syntax. SQLite gets text as the equivalent FTS5
+// query.
+func PostsSearchTx(tx *sql.Tx, dialect utils.Dialect, text string, q *ListQuery) (*List[PostSearchResult], error) {
+	q = q.withDefaultSort(PostSearchListSpec)
+	var match any = text
+	search := postgresSearch
+	if dialect == utils.SQLite {
+		expr := parseSearch(text).fts5()
+		if expr == "" {
+			return newList([]*PostSearchResult{}, q, (*PostSearchResult).field), nil
+		}
+		match, search = expr, sqliteSearch
+	}
+
+	where, args, err := q.where(2)
+	if err != nil {
+		return nil, err
+	}
+	s := fmt.Sprintf(search, POST_FIELDS, where, q.orderBy(), q.limit()+1)
+	rows, err := tx.Query(s, append([]any{match}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*PostSearchResult{}
+	for rows.Next() {
+		r := PostSearchResult{}
+		err := rows.Scan(append(r.dest(), &r.Rank, &r.Headline)...)
+		if err != nil {
+			return nil, err
+		}
+		r.Headline = headlineHTML(r.Headline)
+		results = append(results, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Postgres runs one query at a time on the connection of the tx.
+	rows.Close()
+	posts := make([]*Post, len(results))
+	for i, r := range results {
+		posts[i] = &r.Post
+	}
+	err = postsLoadTx(tx, posts...)
+	if err != nil {
+		return nil, err
+	}
+	return newList(results, q, (*PostSearchResult).field), nil
+}
+
+// field returns the value of a column, for list queries.
+func (r *PostSearchResult) field(column string) any {
+	if column == "rank" {
+		return r.Rank
+	}
+	return r.Post.field(column)
+}
+
+// searchTerm is a word, or a quoted phrase, of a search. Negated terms
+// must not match.
+type searchTerm struct {
+	words []string
+	not   bool
+}
+
+// searchQuery is a search in websearch_to_tsquery syntax, for the stores
+// that don't have it: the terms of a group must all match, and any group
+// can. Groups with only negated terms are dropped, as they can't be
+// looked up in an index.
+type searchQuery [][]searchTerm
+
+// parseSearch reads text the way websearch_to_tsquery does: words are
+// ANDed, "quoted text" is a phrase, OR separates alternatives and a
+// leading - negates a term. Anything else is never a syntax error, it only
+// splits words.
+func parseSearch(text string) searchQuery {
+	sq := searchQuery{}
+	group := []searchTerm{}
+	endGroup := func() {
+		if slices.ContainsFunc(group, func(t searchTerm) bool { return !t.not }) {
+			sq = append(sq, group)
+		}
+		group = []searchTerm{}
+	}
+
+	not := false
+	for {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			break
+		}
+		var token string
+		switch text[0] {
+		case '-':
+			not, text = true, text[1:]
+			continue
+		case '"':
+			token, text, _ = strings.Cut(text[1:], `"`)
+		default:
+			end := strings.IndexFunc(text, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+			if end < 0 {
+				end = len(text)
+			}
+			token, text = text[:end], text[end:]
+			if strings.EqualFold(token, "or") {
+				endGroup()
+				not = false
+				continue
+			}
+		}
+		if words := searchWords(token); len(words) > 0 {
+			group = append(group, searchTerm{words, not})
+		}
+		not = false
+	}
+	endGroup()
+	return sq
+}
+
+// searchWords splits text into lower case words, on anything that isn't a
+// letter or a digit.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fts5 writes the search as an FTS5 query. Every term is quoted, so
+// nothing the client types is taken for FTS5 syntax.
+func (sq searchQuery) fts5() string {
+	groups := []string{}
+	for _, group := range sq {
+		and, not := []string{}, []string{}
+		for _, t := range group {
+			phrase := `"` + strings.Join(t.words, " ") + `"`
+			if t.not {
+				not = append(not, phrase)
+			} else {
+				and = append(and, phrase)
+			}
+		}
+		expr := strings.Join(and, " AND ")
+		for _, phrase := range not {
+			expr += " NOT " + phrase
+		}
+		groups = append(groups, "("+expr+")")
+	}
+	return strings.Join(groups, " OR ")
+}
+
+// rank scores the title and content of a post against the search, for the
+// memory store. Like ts_rank with the default weights, a match in the
+// title counts 1 and one in the content 0.4. Words aren't stemmed. ok is
+// false when the post doesn't match.
+func (sq searchQuery) rank(title, content string) (rank float64, ok bool) {
+	titleWords, contentWords := searchWords(title), searchWords(content)
+	for _, group := range sq {
+		score, matched := 0.0, true
+		for _, t := range group {
+			n := 1.0*float64(countPhrase(titleWords, t.words)) + 0.4*float64(countPhrase(contentWords, t.words))
+			if (n > 0) == t.not {
+				matched = false
+				break
+			}
+			score += n
+		}
+		if matched {
+			rank += score
+			ok = true
+		}
+	}
+	return rank, ok
+}
+
+// countPhrase counts where phrase appears in words.
+func countPhrase(words, phrase []string) int {
+	n := 0
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(phrase)], phrase) {
+			n++
+		}
+	}
+	return n
+}
+
+// The characters the databases mark the matches of headlines with. They
+// can't be <b> tags, since the content is text and has to be escaped first.
+const (
+	headlineStart = '\x02'
+	headlineStop  = '\x03'
+)
+
+// headlineHTML escapes a headline the database made from the content of a
+// post and turns its marks into <b> tags. Marks that the content had of its
+// own can't leave a tag open.
+func headlineHTML(headline string) string {
+	var b strings.Builder
+	bold := false
+	for _, r := range html.EscapeString(headline) {
+		switch {
+		case r == headlineStart && !bold:
+			b.WriteString("<b>")
+			bold = true
+		case r == headlineStop && bold:
+			b.WriteString("</b>")
+			bold = false
+		case r != headlineStart && r != headlineStop:
+			b.WriteRune(r)
+		}
+	}
+	if bold {
+		b.WriteString("</b>")
+	}
+	return b.String()
+}
+
+// headline cuts the content around the first word of the search in it, with
+// the words of the search in <b> tags, for the memory store.
+func (sq searchQuery) headline(content string) string {
+	const maxWords = 30
+	searched := map[string]bool{}
+	for _, group := range sq {
+		for _, t := range group {
+			for _, w := range t.words {
+				searched[w] = !t.not
+			}
+		}
+	}
+
+	fields := strings.Fields(content)
+	isMatch := func(field string) bool {
+		return slices.ContainsFunc(searchWords(field), func(w string) bool { return searched[w] })
+	}
+	start := max(0, slices.IndexFunc(fields, isMatch)-maxWords/3)
+	fields = fields[start:min(len(fields), start+maxWords)]
+	for i, field := range fields {
+		if isMatch(field) {
+			fields[i] = "<b>" + html.EscapeString(field) + "</b>"
+		} else {
+			fields[i] = html.EscapeString(field)
+		}
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		description  string
+		text         string
+		expectedFTS5 string
+	}{
+		{
+			description:  "Words are ANDed",
+			text:         "Tomato  soup",
+			expectedFTS5: `("tomato" AND "soup")`,
+		},
+		{
+			description:  "Quotes make a phrase",
+			text:         `"tomato soup" basil`,
+			expectedFTS5: `("tomato soup" AND "basil")`,
+		},
+		{
+			description:  "OR separates alternatives",
+			text:         "tomato soup or basil",
+			expectedFTS5: `("tomato" AND "soup") OR ("basil")`,
+		},
+		{
+			description:  "A dash negates a term",
+			text:         `-frost tomato -"cold night"`,
+			expectedFTS5: `("tomato" NOT "frost" NOT "cold night")`,
+		},
+		{
+			description:  "Only negated alternatives are dropped",
+			text:         "-frost OR tomato",
+			expectedFTS5: `("tomato")`,
+		},
+		{
+			description:  "Punctuation splits words and never is FTS5 syntax",
+			text:         `title-1 NEAR(a*) "unclosed`,
+			expectedFTS5: `("title 1" AND "near a" AND "unclosed")`,
+		},
+		{
+			description:  "Nothing to search",
+			text:         ` -"" or OR `,
+			expectedFTS5: ``,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if got := parseSearch(tc.text).fts5(); got != tc.expectedFTS5 {
+				t.Errorf("Got %s, expected %s", got, tc.expectedFTS5)
+			}
+		})
+	}
+}
+
+func TestHeadlineHTML(t *testing.T) {
+	tests := []struct {
+		headline string
+		expected string
+	}{
+		{"a \x02tomato\x03 soup", "a <b>tomato</b> soup"},
+		{"<img src=x onerror=alert(1)> \x02tomato\x03", "&lt;img src=x onerror=alert(1)&gt; <b>tomato</b>"},
+		{"\x03stray \x02open", "stray <b>open</b>"},
+		{"\x02a\x02b\x03\x03", "<b>ab</b>"},
+	}
+
+	for _, tc := range tests {
+		if got := headlineHTML(tc.headline); got != tc.expected {
+			t.Errorf("headlineHTML(%q)=%q, expected %q", tc.headline, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxSlugLength caps the slugs Slugify makes, which are cut at a dash when
+// there is one. Collision suffixes come on top.
+const maxSlugLength = 80
+
+// transliterations spell the lowercase letters that have no accent to drop
+// in ASCII: those of Latin that aren't a letter and a mark, Cyrillic and
+// Greek. Letters of other scripts are dropped.
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i", 'ŋ': "ng",
+
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ж': "zh", 'з': "z", 'и': "i", 'к': "k",
+	'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f",
+	'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'є': "ye", 'ґ': "g", 'ђ': "dj", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i", 'κ': "k",
+	'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t",
+	'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Slugify makes the slug of a post or the handle of a user from its title
+// or name: lowercase ASCII letters, digits and single dashes, where
+// anything else separates words. Accents are dropped and other scripts
+// transliterated, see transliterations, so "Crème brûlée à Москва" is
+// creme-brulee-a-moskva. Text with nothing to keep makes an empty slug.
+func Slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFKD.String(text) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		t, ok := transliterations[r]
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			t, ok = string(r), true
+		}
+		switch {
+		case ok && t != "":
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteString(t)
+			dash = false
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			dash = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+		if i := strings.LastIndexByte(slug, '-'); i > 0 {
+			slug = slug[:i]
+		}
+	}
+	return slug
+}
+
+// hasBase reports whether slug is base, or base with a collision suffix.
+func hasBase(slug, base string) bool {
+	if slug == base {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(slug, base+"-")
+	return ok && suffix != "" && strings.Trim(suffix, "0123456789") == ""
+}
+
+// slugTable is where the slugs of rows are kept: the current one in a
+// column of the rows, and every one they ever had in a table of their own,
+// which is what makes them unique and old ones lead to the rows.
+type slugTable struct {
+	// rows and column are the current slugs, history, historyColumn and
+	// owner every one of them.
+	rows, column, history, historyColumn, owner string
+	// fallback is the slug of rows whose text makes none.
+	fallback string
+}
+
+var (
+	postSlugs   = slugTable{"posts", "slug", "post_slugs", "slug", "post_id", "post"}
+	userHandles = slugTable{"users", "handle", "user_handles", "handle", "user_id", "user"}
+)
+
+// base is the slug of text, before any collision suffix.
+func (t slugTable) base(text string) string {
+	if base := Slugify(text); base != "" {
+		return base
+	}
+	return t.fallback
+}
+
+// maxSlugClaims is how many times claimTx writes rows again after another
+// transaction took one of their slugs first.
+const maxSlugClaims = 5
+
+// slugger hands out unique slugs in a transaction. It remembers the slugs
+// it handed out, so that the rows of a batch don't get the same one, until
+// recordTx adds them to the history. Slugs are only read, not locked, so
+// rows have to be written with claimTx.
+type slugger struct {
+	slugTable
+	tx *sql.Tx
+	// taken is who had or got the slugs of the bases in queried.
+	taken   map[string]uuid.UUID
+	queried map[string]bool
+	// handedOut are the slugs that aren't in the history yet, by row.
+	handedOut map[uuid.UUID]string
+}
+
+func (t slugTable) slugger(tx *sql.Tx) *slugger {
+	s := &slugger{slugTable: t, tx: tx}
+	s.forget()
+	return s
+}
+
+// forget drops what the slugger read and handed out, so that next reads
+// the slugs again.
+func (s *slugger) forget() {
+	s.taken = map[string]uuid.UUID{}
+	s.queried = map[string]bool{}
+	s.handedOut = map[uuid.UUID]string{}
+}
+
+// claimTx runs write, which hands out slugs with next and writes them, in
+// a savepoint. A transaction running at the same time may have taken one
+// of the slugs since next read them, and then write fails on the unique key
+// of the slugs: claimTx rolls it back, forgets what it read and runs it
+// again, so that next reads the slug as taken and picks the next suffix.
+func (s *slugger) claimTx(write func() error) error {
+	for attempt := 0; ; attempt++ {
+		_, err := s.tx.Exec(`SAVEPOINT slugs`)
+		if err != nil {
+			return err
+		}
+		err = write()
+		if err == nil {
+			_, err = s.tx.Exec(`RELEASE SAVEPOINT slugs`)
+			return err
+		}
+		c, ok := AsConstraintError(err)
+		if !ok || c.Kind != UniqueViolation || (c.Field != s.column && c.Field != s.historyColumn) || attempt+1 >= maxSlugClaims {
+			return err
+		}
+		_, err = s.tx.Exec(`ROLLBACK TO SAVEPOINT slugs`)
+		if err != nil {
+			return err
+		}
+		s.forget()
+	}
+}
+
+// next returns the slug of text for the row, with the first collision
+// suffix, from -2 on, that no other row ever had. The row can get one of
+// its own old slugs back.
+func (s *slugger) next(text string, id uuid.UUID) (string, error) {
+	base := s.base(text)
+	if !s.queried[base] {
+		// Slugs are letters, digits and dashes, there's nothing to escape
+		// for LIKE.
+		q := fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %[1]s = $1 OR %[1]s LIKE $2`, s.historyColumn, s.owner, s.history)
+		rows, err := s.tx.Query(q, base, base+"-%")
+		if err != nil {
+			return "", err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var slug string
+			var owner uuid.UUID
+			err := rows.Scan(&slug, &owner)
+			if err != nil {
+				return "", err
+			}
+			s.taken[slug] = owner
+		}
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		s.queried[base] = true
+	}
+
+	slug := base
+	for n := 2; ; n++ {
+		owner, ok := s.taken[slug]
+		if !ok {
+			s.taken[slug] = id
+			s.handedOut[id] = slug
+			return slug, nil
+		}
+		if owner == id {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// recordTx adds the slugs handed out to the history, once their rows are
+// written.
+func (s *slugger) recordTx(at time.Time) error {
+	if len(s.handedOut) == 0 {
+		return nil
+	}
+	args := make([]any, 0, 3*len(s.handedOut))
+	for id, slug := range s.handedOut {
+		args = append(args, slug, id, at)
+	}
+	q := fmt.Sprintf(`INSERT INTO %s (%s, %s, created_at) VALUES %s`, s.history, s.historyColumn, s.owner, valuesList(len(s.handedOut), 3))
+	_, err := s.tx.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	clear(s.handedOut)
+	return nil
+}
+
+// renameTx gives the row a new slug when text no longer makes the one it
+// has, and returns its slug. Its old slug stays in the history.
+func (s *slugger) renameTx(id uuid.UUID, current, text string) (string, error) {
+	if hasBase(current, s.base(text)) {
+		return current, nil
+	}
+	var slug string
+	err := s.claimTx(func() error {
+		var err error
+		slug, err = s.next(text, id)
+		if err != nil {
+			return err
+		}
+		q := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE id = $2`, s.rows, s.column)
+		_, err = s.tx.Exec(q, slug, id)
+		if err != nil {
+			return err
+		}
+		return s.recordTx(now())
+	})
+	return slug, err
+}
+
+// lookupTx finds the row that has or had the slug.
+func (t slugTable) lookupTx(tx *sql.Tx, slug string) (uuid.UUID, bool, error) {
+	var id uuid.UUID
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE %s = $1`, t.owner, t.history, t.historyColumn)
+	err := tx.QueryRow(q, slug).Scan(&id)
+	if err == sql.ErrNoRows {
+		return id, false, nil
+	}
+	return id, err == nil, err
+}
+
+// backfillTx gives a slug made from textColumn to up to limit of the rows
+// that have their id as one, oldest first, and returns how many it gave.
+// Migration 15 gives every row it finds its id.
+func (t slugTable) backfillTx(tx *sql.Tx, textColumn string, limit int) (int, error) {
+	q := fmt.Sprintf(`SELECT id, %s FROM %s WHERE %s = CAST(id AS TEXT) ORDER BY created_at, id LIMIT %d`, textColumn, t.rows, t.column, limit)
+	rows, err := tx.Query(q)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	type row struct {
+		id   uuid.UUID
+		text string
+	}
+	pending := []row{}
+	for rows.Next() {
+		r := row{}
+		err := rows.Scan(&r.id, &r.text)
+		if err != nil {
+			return 0, err
+		}
+		pending = append(pending, r)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	// Postgres runs one query at a time on the connection of the tx.
+	rows.Close()
+
+	s := t.slugger(tx)
+	for _, r := range pending {
+		_, err := s.renameTx(r.id, r.id.String(), r.text)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(pending), nil
+}
+
+// SlugsBackfillTx gives slugs to up to limit posts and handles to up to
+// limit users that have their id as one, and returns how many it gave.
+// Run it until it gives none.
+func SlugsBackfillTx(tx *sql.Tx, limit int) (posts, users int, err error) {
+	posts, err = postSlugs.backfillTx(tx, "title", limit)
+	if err != nil {
+		return 0, 0, err
+	}
+	users, err = userHandles.backfillTx(tx, "name", limit)
+	return posts, users, err
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"api/cmd/api/utils"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Go 1.22 -- what's new?  ", "go-1-22-what-s-new"},
+		{"Crème brûlée à Москва", "creme-brulee-a-moskva"},
+		{"Straße über Ærø", "strasse-uber-aero"},
+		{"Αθήνα", "athina"},
+		{"ﬁne ①", "fine-1"},
+		{"東京", ""},
+		{"!!!", ""},
+		{strings.Repeat("word ", 20), strings.TrimSuffix(strings.Repeat("word-", 16), "-")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			slug := Slugify(tc.text)
+			if slug != tc.expected {
+				t.Errorf("Slugify(%q)=%q, expected %q", tc.text, slug, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHasBase(t *testing.T) {
+	for _, tc := range []struct {
+		slug, base string
+		expected   bool
+	}{
+		{"title", "title", true},
+		{"title-2", "title", true},
+		{"title-12", "title", true},
+		{"title-", "title", false},
+		{"title-two", "title", false},
+		{"title-1-2", "title", false},
+		{"titles", "title", false},
+	} {
+		if hasBase(tc.slug, tc.base) != tc.expected {
+			t.Errorf("hasBase(%q, %q)!=%v", tc.slug, tc.base, tc.expected)
+		}
+	}
+}
+
+func TestPostsSlugsTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		post, err := PostsCreateTx(tx, &PostInput{"Title 1", "content", db.Fixture.UserId1, nil, "", nil, ""}, nil)
+		if err != nil {
+			return err
+		}
+		if post.Slug != "title-1-2" {
+			return fmt.Errorf("Wrong slug for a taken title: %q", post.Slug)
+		}
+
+		post, err = PostsUpdateTx(tx, post.Id, &PostInput{"Title 1!", "content", db.Fixture.UserId1, nil, "", nil, ""}, nil)
+		if err != nil {
+			return err
+		}
+		if post.Slug != "title-1-2" {
+			return fmt.Errorf("Slug should stay while the title makes it: %q", post.Slug)
+		}
+
+		post, err = PostsUpdateTx(tx, post.Id, &PostInput{"Renamed", "content", db.Fixture.UserId1, nil, "", nil, ""}, nil)
+		if err != nil {
+			return err
+		}
+		if post.Slug != "renamed" {
+			return fmt.Errorf("Wrong slug after a rename: %q", post.Slug)
+		}
+		for _, slug := range []string{"renamed", "title-1-2"} {
+			found, err := PostsGetBySlugTx(tx, slug)
+			if err != nil {
+				return err
+			}
+			if found == nil || found.Id != post.Id || found.Slug != "renamed" {
+				return fmt.Errorf("Slug %q should lead to the post: %+v", slug, found)
+			}
+		}
+
+		// An old slug isn't handed out again, but its post gets it back.
+		other, err := PostsCreateTx(tx, &PostInput{"Title 1", "content", db.Fixture.UserId2, nil, "", nil, ""}, nil)
+		if err != nil {
+			return err
+		}
+		if other.Slug != "title-1-3" {
+			return fmt.Errorf("Old slugs should stay taken: %q", other.Slug)
+		}
+		post, err = PostsUpdateTx(tx, post.Id, &PostInput{"Title 1", "content", db.Fixture.UserId1, nil, "", nil, ""}, nil)
+		if err != nil {
+			return err
+		}
+		if post.Slug != "title-1-2" {
+			return fmt.Errorf("Post should get its old slug back: %q", post.Slug)
+		}
+
+		found, err := PostsGetBySlugTx(tx, "missing")
+		if err != nil {
+			return err
+		}
+		if found != nil {
+			return fmt.Errorf("Missing slug found: %+v", found)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUsersHandlesTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		users, err := UsersCreateManyTx(tx, []*UserInput{{"Zoë", "a@example.com"}, {"Zoe", "b@example.com"}, {"東京", "c@example.com"}})
+		if err != nil {
+			return err
+		}
+		for i, expected := range []string{"zoe", "zoe-2", "user"} {
+			if users[i].Handle != expected {
+				return fmt.Errorf("Wrong handle %d: %q!=%q", i, users[i].Handle, expected)
+			}
+		}
+
+		user, err := UsersUpdateTx(tx, users[0].Id, &UserInput{"Zoë Smith", "a@example.com"})
+		if err != nil {
+			return err
+		}
+		if user.Handle != "zoe-smith" {
+			return fmt.Errorf("Wrong handle after a rename: %q", user.Handle)
+		}
+		found, err := UsersGetByHandleTx(tx, "zoe")
+		if err != nil {
+			return err
+		}
+		if found == nil || found.Id != user.Id || found.Handle != "zoe-smith" {
+			return fmt.Errorf("Old handle should lead to the user: %+v", found)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSlugsBackfillTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		// As migration 15 leaves the rows there were.
+		_, err := tx.Exec(`DELETE FROM post_slugs`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE posts SET slug = CAST(id AS TEXT), title = 'Same title'`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE users SET handle = CAST(id AS TEXT) WHERE id = $1`, db.Fixture.UserId2)
+		if err != nil {
+			return err
+		}
+
+		posts, users, err := SlugsBackfillTx(tx, 1)
+		if err != nil {
+			return err
+		}
+		if posts != 1 || users != 1 {
+			return fmt.Errorf("Wrong first backfill: %d posts, %d users", posts, users)
+		}
+		posts, users, err = SlugsBackfillTx(tx, 1)
+		if err != nil {
+			return err
+		}
+		if posts != 1 || users != 0 {
+			return fmt.Errorf("Wrong second backfill: %d posts, %d users", posts, users)
+		}
+		posts, users, err = SlugsBackfillTx(tx, 1)
+		if err != nil {
+			return err
+		}
+		if posts != 0 || users != 0 {
+			return fmt.Errorf("Backfill should be done: %d posts, %d users", posts, users)
+		}
+
+		// Oldest first.
+		for id, expected := range map[string]string{db.Fixture.PostId1.String(): "same-title", db.Fixture.PostId2.String(): "same-title-2"} {
+			var slug string
+			err := tx.QueryRow(`SELECT slug FROM posts WHERE CAST(id AS TEXT) = $1`, id).Scan(&slug)
+			if err != nil {
+				return err
+			}
+			if slug != expected {
+				return fmt.Errorf("Wrong slug for %s: %q!=%q", id, slug, expected)
+			}
+		}
+		user, err := UsersGetByHandleTx(tx, "user-2")
+		if err != nil {
+			return err
+		}
+		if user == nil || user.Id != db.Fixture.UserId2 {
+			return fmt.Errorf("Backfilled handle should lead to the user: %+v", user)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSlugsClaimTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+		slugs := postSlugs.slugger(tx)
+		id := uuid.New()
+		slug, err := slugs.next("Title 1", id)
+		if err != nil {
+			return err
+		}
+		if slug != "title-1-2" {
+			return fmt.Errorf("Wrong slug: %q", slug)
+		}
+		// Another transaction takes the slug since it was read.
+		other, err := PostsCreateTx(tx, &PostInput{"Title 1", "content", db.Fixture.UserId2, nil, "", nil, ""}, nil)
+		if err != nil {
+			return err
+		}
+		if other.Slug != "title-1-2" {
+			return fmt.Errorf("Wrong slug for the other post: %q", other.Slug)
+		}
+
+		writes := 0
+		err = slugs.claimTx(func() error {
+			writes++
+			slug, err = slugs.next("Title 1", id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO posts (id, title, slug, content, user_id, created_at) VALUES ($1, 'Title 1', $2, 'content', $3, $4)`, id, slug, db.Fixture.UserId1, now())
+			if err != nil {
+				return err
+			}
+			return slugs.recordTx(now())
+		})
+		if err != nil {
+			return err
+		}
+		if writes != 2 || slug != "title-1-3" {
+			return fmt.Errorf("Taken slug should be written again with the next one: %d writes, %q", writes, slug)
+		}
+		post, err := PostsGetBySlugTx(tx, "title-1-3")
+		if err != nil {
+			return err
+		}
+		if post == nil || post.Id != id {
+			return fmt.Errorf("Claimed slug should lead to the post: %+v", post)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// MaxPostTags is how many tags a post can have, and MaxTagLength how long
+// a tag can be.
+const (
+	MaxPostTags  = 10
+	MaxTagLength = 32
+)
+
+// Tag is a tag with the number of posts that carry it, soft deleted posts
+// left out.
+type Tag struct {
+	Id    uuid.UUID `json:"-" db:"id"`
+	Slug  string    `json:"slug" db:"slug"`
+	Count int       `json:"count" db:"count"`
+}
+
+// TagListSpec declares the sorts of GET /api/tags. Tags come most used
+// first unless sorted otherwise.
+var TagListSpec = ListSpec{
+	Sorts: map[string]Field{
+		"count": {"count", IntField},
+		"slug":  {"slug", StringField},
+	},
+	DefaultSort: []SortKey{{Field{"count", IntField}, true}},
+}
+
+// PostTagFilters keep the posts tagged with any, or with all, of a list of
+// tags, by tag_mode.
+var PostTagFilters = map[string]Filter{
+	"any": {Field{"tags", TagsField}, "any"},
+	"all": {Field{"tags", TagsField}, "all"},
+}
+
+// normalizeTag turns a tag into its slug: lowercase letters and digits,
+// with a dash for whatever separates them, so "Go Lang!" is "go-lang".
+func normalizeTag(tag string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(tag) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			dash = true
+			continue
+		}
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		dash = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// NormalizeTags returns the slugs of the tags, sorted and without
+// duplicates, the way posts keep them.
+func NormalizeTags(tags []string) []string {
+	slugs := make([]string, len(tags))
+	for i, tag := range tags {
+		slugs[i] = normalizeTag(tag)
+	}
+	slices.Sort(slugs)
+	return slices.Compact(slugs)
+}
+
+// checkTags is tags_slug_check plus the limits of posts, on normalized
+// tags.
+func checkTags(slugs []string) error {
+	if len(slugs) > MaxPostTags {
+		return &ConstraintError{Kind: CheckViolation, Field: "tags", Err: fmt.Errorf("a post has at most %d tags", MaxPostTags)}
+	}
+	for _, slug := range slugs {
+		if slug == "" || utf8.RuneCountInString(slug) > MaxTagLength {
+			return &ConstraintError{Kind: CheckViolation, Field: "tags", Err: fmt.Errorf("tags have 1 to %d letters or digits", MaxTagLength)}
+		}
+	}
+	return nil
+}
+
+// tagCondition is the SQL of a TagsField filter, on the id of posts.
+func tagCondition(op string, slugs []string, arg func(any) string) string {
+	if len(slugs) == 0 {
+		return "TRUE"
+	}
+	placeholders := make([]string, len(slugs))
+	for i, slug := range slugs {
+		placeholders[i] = arg(slug)
+	}
+	s := fmt.Sprintf(`id IN (SELECT post_tags.post_id FROM post_tags JOIN tags ON tags.id = post_tags.tag_id WHERE tags.slug IN (%s)`, strings.Join(placeholders, ", "))
+	if op == "all" {
+		s += fmt.Sprintf(` GROUP BY post_tags.post_id HAVING COUNT(*) = %d`, len(slugs))
+	}
+	return s + ")"
+}
+
+// postsSetTagsTx replaces the tags of the post, creating the ones that
+// don't exist yet. Too many tags, or empty or too long ones, are a
+// ConstraintError on tags.
+func postsSetTagsTx(tx *sql.Tx, post *Post, tags []string) error {
+	slugs := NormalizeTags(tags)
+	err := checkTags(slugs)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM post_tags WHERE post_id=$1`, post.Id)
+	if err != nil {
+		return err
+	}
+	post.Tags = slugs
+	if len(slugs) == 0 {
+		return nil
+	}
+
+	createdAt := now()
+	args := make([]any, 0, 3*len(slugs))
+	for _, slug := range slugs {
+		args = append(args, uuid.New(), slug, createdAt)
+	}
+	s := fmt.Sprintf(`INSERT INTO tags (id, slug, created_at) VALUES %s ON CONFLICT (slug) DO NOTHING`, valuesList(len(slugs), 3))
+	_, err = tx.Exec(s, args...)
+	if err != nil {
+		return err
+	}
+
+	args = []any{post.Id}
+	placeholders := make([]string, len(slugs))
+	for i, slug := range slugs {
+		args = append(args, slug)
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+	s = fmt.Sprintf(`INSERT INTO post_tags (post_id, tag_id) SELECT $1, id FROM tags WHERE slug IN (%s)`, strings.Join(placeholders, ", "))
+	_, err = tx.Exec(s, args...)
+	return err
+}
+
+// postsLoadTagsTx fills in the tags of the posts.
+func postsLoadTagsTx(tx *sql.Tx, posts ...*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+	byId := make(map[uuid.UUID]*Post, len(posts))
+	args := make([]any, len(posts))
+	placeholders := make([]string, len(posts))
+	for i, p := range posts {
+		p.Tags = []string{}
+		byId[p.Id] = p
+		args[i] = p.Id
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	s := fmt.Sprintf(`SELECT post_tags.post_id, tags.slug FROM post_tags JOIN tags ON tags.id = post_tags.tag_id WHERE post_tags.post_id IN (%s) ORDER BY tags.slug`, strings.Join(placeholders, ", "))
+	rows, err := tx.Query(s, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postId uuid.UUID
+		var slug string
+		err := rows.Scan(&postId, &slug)
+		if err != nil {
+			return err
+		}
+		p := byId[postId]
+		p.Tags = append(p.Tags, slug)
+	}
+	return rows.Err()
+}
+
+// tagCounts counts the published posts of every tag, the deleted ones left
+// out.
+const tagCounts = `SELECT tags.id, tags.slug, COUNT(posts.id) AS count FROM tags
+	LEFT JOIN post_tags ON post_tags.tag_id = tags.id
+	LEFT JOIN posts ON posts.id = post_tags.post_id AND posts.deleted_at IS NULL AND posts.status = 'published'
+	GROUP BY tags.id, tags.slug`
+
+// TagsGetTx finds a tag by slug, normalized first.
+func TagsGetTx(tx *sql.Tx, slug string) (*Tag, error) {
+	tag := Tag{}
+	s := fmt.Sprintf(`SELECT id, slug, count FROM (%s) AS counts WHERE slug=$1`, tagCounts)
+	err := tx.QueryRow(s, normalizeTag(slug)).Scan(&tag.Id, &tag.Slug, &tag.Count)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &tag, err
+}
+
+// TagsGetAllTx returns one page of the tags that posts carry, sorted by q.
+func TagsGetAllTx(tx *sql.Tx, q *ListQuery) (*List[Tag], error) {
+	q = q.withDefaultSort(TagListSpec)
+	where, args, err := q.where(1)
+	if err != nil {
+		return nil, err
+	}
+	s := fmt.Sprintf(`SELECT id, slug, count FROM (%s) AS counts WHERE count > 0 AND %s ORDER BY %s LIMIT %d`, tagCounts, where, q.orderBy(), q.limit()+1)
+	rows, err := tx.Query(s, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*Tag{}
+	for rows.Next() {
+		tag := Tag{}
+		err := rows.Scan(&tag.Id, &tag.Slug, &tag.Count)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return newList(tags, q, (*Tag).field), nil
+}
+
+// field returns the value of a column, for list queries.
+func (t *Tag) field(column string) any {
+	switch column {
+	case "id":
+		return t.Id
+	case "slug":
+		return t.Slug
+	case "count":
+		return t.Count
+	}
+	panic("unknown tag field " + column)
+}
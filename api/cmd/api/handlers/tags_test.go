@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"api/cmd/api/utils"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		description string
+		tags        []string
+		expected    []string
+	}{
+		{
+			description: "Tags are lowercased",
+			tags:        []string{"Go", "DB"},
+			expected:    []string{"db", "go"},
+		},
+		{
+			description: "Anything but letters and digits is a dash",
+			tags:        []string{"  Go Lang!", "c++/cli", "état_2"},
+			expected:    []string{"c-cli", "go-lang", "état-2"},
+		},
+		{
+			description: "Duplicates are dropped",
+			tags:        []string{"go", "Go", "GO!"},
+			expected:    []string{"go"},
+		},
+		{
+			description: "Tags without letters or digits are empty",
+			tags:        []string{"--"},
+			expected:    []string{""},
+		},
+		{
+			description: "No tags",
+			expected:    []string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if got := NormalizeTags(tc.tags); !slices.Equal(got, tc.expected) {
+				t.Errorf("Got %q, expected %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPostsTagsTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	tests := []struct {
+		description  string
+		tags         []string
+		updateTags   []string
+		expectedTags []string
+		expectError  bool
+	}{
+		{
+			description:  "Create post with tags",
+			tags:         []string{"Go", "db"},
+			expectedTags: []string{"db", "go"},
+		},
+		{
+			description:  "Update without tags keeps them",
+			tags:         []string{"go"},
+			expectedTags: []string{"go"},
+		},
+		{
+			description:  "Update replaces the tags",
+			tags:         []string{"go", "db"},
+			updateTags:   []string{"sql"},
+			expectedTags: []string{"sql"},
+		},
+		{
+			description:  "Update with no tags clears them",
+			tags:         []string{"go"},
+			updateTags:   []string{},
+			expectedTags: []string{},
+		},
+		{
+			description: "Create post with too many tags, expect fail",
+			tags:        strings.Split("a b c d e f g h i j k", " "),
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			_, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			ctx := context.Background()
+			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+				p, err := PostsCreateTx(tx, &PostInput{"one", "1", db.Fixture.UserId1, tc.tags, "", nil, ""}, nil)
+				if err != nil {
+					return err
+				}
+				_, err = PostsUpdateTx(tx, p.Id, &PostInput{"two", "2", db.Fixture.UserId1, tc.updateTags, "", nil, ""}, nil)
+				if err != nil {
+					return err
+				}
+				post, err := PostsGetTx(tx, p.Id)
+				if err != nil {
+					return err
+				}
+				if !slices.Equal(post.Tags, tc.expectedTags) {
+					return fmt.Errorf("Wrong tags: %q", post.Tags)
+				}
+				return nil
+			})
+			if tc.expectError {
+				if err == nil {
+					t.Error(err)
+				}
+			} else {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
@@ -3,18 +3,27 @@ package handlers
 import (
 	"database/sql"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	"api/internal/validator"
+
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 )
 
 type User struct {
-	Id        uuid.UUID  `json:"id" db:"id"`
-	Email     string     `json:"email" db:"email"`
-	Name      string     `json:"name" db:"name"`
+	Id    uuid.UUID `json:"id" db:"id"`
+	Email string    `json:"email" db:"email"`
+	Name  string    `json:"name" db:"name"`
+	// Handle is made from the name, and follows it. Id stays the canonical
+	// one, see UsersGetByHandleTx.
+	Handle    string     `json:"handle" db:"handle"`
 	CreatedAt time.Time  `json:"createdAt" db:"created_at"`
 	UpdatedAt *time.Time `json:"updatedAt" db:"updated_at"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+	Version   int64      `json:"version" db:"version"`
 }
 
 type UserInput struct {
@@ -22,51 +31,244 @@ type UserInput struct {
 	Email string `json:"email" db:"email"`
 }
 
-const USER_FIELDS = "id, name, email, created_at, updated_at"
+// Input is the part of the user that clients can change, for PATCH.
+func (u *User) Input() *UserInput {
+	return &UserInput{Name: u.Name, Email: u.Email}
+}
+
+// Validate checks the input before it is written.
+func (in *UserInput) Validate() validator.Validator {
+	v := validator.Validator{}
+	v.CheckField(validator.NotBlank(in.Name), "name", "must not be blank")
+	v.CheckField(validator.IsEmail(in.Email), "email", "must be a valid email address")
+	return v
+}
+
+// normalizeEmail is the form emails are stored and looked up in. Emails are
+// unique among live users in this form, so addresses that only differ in
+// case are the same user.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+const USER_FIELDS = "id, name, handle, email, created_at, updated_at, deleted_at, version"
+
+// UserListSpec declares the filters and sorts of GET /api/users.
+var UserListSpec = ListSpec{
+	Filters: map[string]Filter{
+		"email":          {Field{"email", EmailField}, "="},
+		"name_contains":  {Field{"name", StringField}, "contains"},
+		"created_after":  {Field{"created_at", TimeField}, ">"},
+		"created_before": {Field{"created_at", TimeField}, "<"},
+	},
+	Sorts: map[string]Field{
+		"created_at": {"created_at", TimeField},
+		"name":       {"name", StringField},
+		"email":      {"email", StringField},
+	},
+	SoftDelete: true,
+}
+
+// dest returns the scan destinations for USER_FIELDS.
+func (u *User) dest() []any {
+	return []any{&u.Id, &u.Name, &u.Handle, &u.Email, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt, &u.Version}
+}
 
 func UsersGetTx(tx *sql.Tx, id uuid.UUID) (*User, error) {
+	user := User{}
+	s := fmt.Sprintf(`SELECT %s FROM users WHERE id=$1 AND deleted_at IS NULL`, USER_FIELDS)
+	err := tx.QueryRow(s, id).Scan(user.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &user, err
+}
+
+// UsersGetWithDeletedTx is UsersGetTx that also finds soft deleted users.
+func UsersGetWithDeletedTx(tx *sql.Tx, id uuid.UUID) (*User, error) {
 	user := User{}
 	s := fmt.Sprintf(`SELECT %s FROM users WHERE id=$1`, USER_FIELDS)
-	err := tx.QueryRow(s, id).Scan(&user.Id, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+	err := tx.QueryRow(s, id).Scan(user.dest()...)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	return &user, err
 }
 
+// UsersGetByEmailTx finds the live user with the email, in any case.
+func UsersGetByEmailTx(tx *sql.Tx, email string) (*User, error) {
+	user := User{}
+	s := fmt.Sprintf(`SELECT %s FROM users WHERE email=$1 AND deleted_at IS NULL`, USER_FIELDS)
+	err := tx.QueryRow(s, normalizeEmail(email)).Scan(user.dest()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &user, err
+}
+
+// UsersGetByHandleTx finds the live user that has or had the handle. Users
+// that had it have another one now, which is how callers tell old handles.
+func UsersGetByHandleTx(tx *sql.Tx, handle string) (*User, error) {
+	id, ok, err := userHandles.lookupTx(tx, handle)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return UsersGetTx(tx, id)
+}
+
+// UsersCreateTx creates the user along with their handle.
 func UsersCreateTx(tx *sql.Tx, input *UserInput) (*User, error) {
 	user := &User{}
-	s := fmt.Sprintf(`INSERT INTO users (name, email) VALUES ($1, $2) RETURNING %s`, USER_FIELDS)
-	err := tx.QueryRow(s, input.Name, input.Email).Scan(&user.Id, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
-	return user, err
+	createdAt := now()
+	id := uuid.New()
+	handles := userHandles.slugger(tx)
+	err := handles.claimTx(func() error {
+		handle, err := handles.next(input.Name, id)
+		if err != nil {
+			return err
+		}
+		s := fmt.Sprintf(`INSERT INTO users (id, name, handle, email, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING %s`, USER_FIELDS)
+		err = tx.QueryRow(s, id, input.Name, handle, normalizeEmail(input.Email), createdAt).Scan(user.dest()...)
+		if err != nil {
+			return err
+		}
+		return handles.recordTx(createdAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
+// UsersCreateManyTx creates the users and their handles with multi-row
+// inserts, and returns them in the order of inputs.
+func UsersCreateManyTx(tx *sql.Tx, inputs []*UserInput) ([]*User, error) {
+	users := make([]*User, 0, len(inputs))
+	handles := userHandles.slugger(tx)
+	for chunk := range slices.Chunk(inputs, insertChunkRows) {
+		createdAt := now()
+		ids := make([]uuid.UUID, len(chunk))
+		index := make(map[uuid.UUID]int, len(chunk))
+		for i := range chunk {
+			ids[i] = uuid.New()
+			index[ids[i]] = i
+		}
+		created := make([]*User, len(chunk))
+		err := handles.claimTx(func() error {
+			args := make([]any, 0, 5*len(chunk))
+			for i, input := range chunk {
+				handle, err := handles.next(input.Name, ids[i])
+				if err != nil {
+					return err
+				}
+				args = append(args, ids[i], input.Name, handle, normalizeEmail(input.Email), createdAt)
+			}
+
+			s := fmt.Sprintf(`INSERT INTO users (id, name, handle, email, created_at) VALUES %s RETURNING %s`, valuesList(len(chunk), 5), USER_FIELDS)
+			rows, err := tx.Query(s, args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			// RETURNING doesn't keep the order of VALUES.
+			for rows.Next() {
+				user := User{}
+				err := rows.Scan(user.dest()...)
+				if err != nil {
+					return err
+				}
+				created[index[user.Id]] = &user
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			// Postgres runs one query at a time on the connection of the tx.
+			rows.Close()
+			return handles.recordTx(createdAt)
+		})
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, created...)
+	}
+	return users, nil
+}
+
+// UsersUpdateTx updates the user. A new name makes a new handle, unless it
+// makes the same.
 func UsersUpdateTx(tx *sql.Tx, id uuid.UUID, input *UserInput) (*User, error) {
 	user := &User{}
-	s := fmt.Sprintf(`UPDATE users SET name=$1, email=$2 WHERE id=$3 RETURNING %s`, USER_FIELDS)
-	err := tx.QueryRow(s, input.Name, input.Email, id).Scan(&user.Id, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+	s := fmt.Sprintf(`UPDATE users SET name=$1, email=$2, updated_at=$3, version=version+1 WHERE id=$4 AND deleted_at IS NULL RETURNING %s`, USER_FIELDS)
+	err := tx.QueryRow(s, input.Name, normalizeEmail(input.Email), now(), id).Scan(user.dest()...)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	user.Handle, err = userHandles.slugger(tx).renameTx(user.Id, user.Handle, user.Name)
 	return user, err
 }
 
+// UsersDeleteTx soft deletes the user and their posts. The posts get the
+// same deleted_at as the user, which is how UsersRestoreTx tells them from
+// posts that were deleted on their own.
 func UsersDeleteTx(tx *sql.Tx, id uuid.UUID) (*User, error) {
 	user := &User{}
-	s := fmt.Sprintf(`DELETE FROM users WHERE id=$1 RETURNING %s`, USER_FIELDS)
-	err := tx.QueryRow(s, id).Scan(&user.Id, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+	deletedAt := now()
+	s := fmt.Sprintf(`UPDATE users SET deleted_at=$1, version=version+1 WHERE id=$2 AND deleted_at IS NULL RETURNING %s`, USER_FIELDS)
+	err := tx.QueryRow(s, deletedAt, id).Scan(user.dest()...)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE posts SET deleted_at=$1, version=version+1 WHERE user_id=$2 AND deleted_at IS NULL`, deletedAt, id)
 	return user, err
 }
 
-func UsersGetAllTx(tx *sql.Tx) ([]*User, error) {
-	s := fmt.Sprintf(`SELECT %s FROM users ORDER BY created_at DESC`, USER_FIELDS)
-	rows, err := tx.Query(s)
-	if err == sql.ErrNoRows {
-		return nil, nil
+// UsersRestoreTx undoes UsersDeleteTx, along with the deletion of the posts
+// that went with the user. Restoring a user that isn't deleted changes
+// nothing.
+func UsersRestoreTx(tx *sql.Tx, id uuid.UUID) (*User, error) {
+	user, err := UsersGetWithDeletedTx(tx, id)
+	if err != nil || user == nil || user.DeletedAt == nil {
+		return user, err
+	}
+
+	_, err = tx.Exec(`UPDATE posts SET deleted_at=NULL, version=version+1 WHERE user_id=$1 AND deleted_at=$2`, id, *user.DeletedAt)
+	if err != nil {
+		return nil, err
 	}
+
+	s := fmt.Sprintf(`UPDATE users SET deleted_at=NULL, version=version+1 WHERE id=$1 RETURNING %s`, USER_FIELDS)
+	err = tx.QueryRow(s, id).Scan(user.dest()...)
+	return user, err
+}
+
+// UsersPurgeTx hard deletes the users soft deleted before the given time,
+// and through fk_user all of their posts.
+func UsersPurgeTx(tx *sql.Tx, before time.Time) (int64, error) {
+	res, err := tx.Exec(`DELETE FROM users WHERE deleted_at < $1`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// UsersGetAllTx returns one page of users, filtered and sorted by q.
+func UsersGetAllTx(tx *sql.Tx, q *ListQuery) (*List[User], error) {
+	where, args, err := q.where(1)
+	if err != nil {
+		return nil, err
+	}
+	if !q.includeDeleted() {
+		where += " AND deleted_at IS NULL"
+	}
+	s := fmt.Sprintf(`SELECT %s FROM users WHERE %s ORDER BY %s LIMIT %d`, USER_FIELDS, where, q.orderBy(), q.limit()+1)
+	rows, err := tx.Query(s, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -75,11 +277,34 @@ func UsersGetAllTx(tx *sql.Tx) ([]*User, error) {
 	users := []*User{}
 	for rows.Next() {
 		user := User{}
-		err := rows.Scan(&user.Id, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+		err := rows.Scan(user.dest()...)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, &user)
 	}
-	return users, err
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return newList(users, q, (*User).field), nil
+}
+
+// ETag identifies the version of the user, for conditional requests.
+func (u *User) ETag() string {
+	return fmt.Sprintf(`"%d"`, u.Version)
+}
+
+// field returns the value of a column, for list queries.
+func (u *User) field(column string) any {
+	switch column {
+	case "id":
+		return u.Id
+	case "name":
+		return u.Name
+	case "email":
+		return u.Email
+	case "created_at":
+		return u.CreatedAt
+	}
+	panic("unknown user field " + column)
 }
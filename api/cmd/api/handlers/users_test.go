@@ -43,10 +43,11 @@ func TestUsersGetAllTx(t *testing.T) {
 
 			ctx := context.Background()
 			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
-				users, err := UsersGetAllTx(tx)
+				list, err := UsersGetAllTx(tx, nil)
 				if err != nil {
 					return err
 				}
+				users := list.Data
 				if len(tc.expectedUsers) != len(users) {
 					return fmt.Errorf("Wrong len:%d!=%d", len(tc.expectedUsers), len(users))
 				}
@@ -347,3 +348,96 @@ func TestUsersDeleteTx(t *testing.T) {
 		})
 	}
 }
+
+func TestUsersRestoreTx(t *testing.T) {
+	db := utils.TestNewDB(t)
+
+	id, _ := uuid.Parse("4a2b9c00-9daf-11ed-93ce-0242ac120001")
+	tests := []struct {
+		description   string
+		userToDelete  *uuid.UUID
+		userToRestore uuid.UUID
+		expectedPosts int
+		expectError   bool
+	}{
+		{
+			description:   "Restore deleted user and their posts",
+			userToDelete:  &db.Fixture.UserId1,
+			userToRestore: db.Fixture.UserId1,
+			expectedPosts: 2,
+		},
+		{
+			description:   "Restore user that is not deleted",
+			userToRestore: db.Fixture.UserId2,
+			expectedPosts: 2,
+		},
+		{
+			description:   "Restore non-existing user",
+			userToRestore: id,
+			expectError:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+
+			_, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			ctx := context.Background()
+			err = db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+				if tc.userToDelete != nil {
+					u, err := UsersDeleteTx(tx, *tc.userToDelete)
+					if err != nil {
+						return err
+					}
+					if u == nil {
+						return fmt.Errorf("User not found")
+					}
+					posts, err := PostsGetAllTx(tx, nil)
+					if err != nil {
+						return err
+					}
+					if len(posts.Data) != 1 {
+						return fmt.Errorf("Posts not deleted with the user")
+					}
+				}
+
+				u, err := UsersRestoreTx(tx, tc.userToRestore)
+				if err != nil {
+					return err
+				}
+				if tc.expectError {
+					if u != nil {
+						return fmt.Errorf("Should be not found")
+					}
+					return nil
+				}
+				if u == nil || u.DeletedAt != nil {
+					return fmt.Errorf("User not restored")
+				}
+				user, err := UsersGetTx(tx, tc.userToRestore)
+				if err != nil {
+					return err
+				}
+				if user == nil {
+					return fmt.Errorf("Restored user not found")
+				}
+				posts, err := PostsGetAllTx(tx, nil)
+				if err != nil {
+					return err
+				}
+				if len(posts.Data) != tc.expectedPosts {
+					return fmt.Errorf("Wrong len:%d!=%d", len(posts.Data), tc.expectedPosts)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"api/cmd/api/handlers"
+	"context"
+	"database/sql"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// auditGetAll godoc
+// @Summary      Get the audit log
+// @Description  Returns a page of the writes to users, posts, comments, follows and reactions, newest first, with who made them and the row before and after. Admin only
+// @Tags         audit
+// @Param        limit           query     int     false  "Page size, capped by the server"
+// @Param        cursor          query     string  false  "Cursor from a previous page"
+// @Param        entity_type     query     string  false  "user, post, comment, follow or reaction"
+// @Param        entity_id       query     string  false  "ID of the user, post or comment. Follows are under the followed user and reactions under the post"
+// @Param        action          query     string  false  "create, update, delete or restore"
+// @Param        actor           query     string  false  "ID of a user, admin or anonymous"
+// @Param        created_after   query     string  false  "RFC 3339 time"
+// @Param        created_before  query     string  false  "RFC 3339 time"
+// @Param        sort            query     string  false  "created_at or -created_at. Default -created_at"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.AuditRecord]
+// @Failure      400  {object}  error
+// @Failure      403  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/audit [get]
+func (app *application) auditGetAll(ctx context.Context, _ httprouter.Params, query url.Values) (*handlers.List[handlers.AuditRecord], error) {
+	if !contextIsAdmin(ctx) {
+		return nil, errAuditAdminOnly
+	}
+	q, err := app.parseListQuery(ctx, query, handlers.AuditListSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	var records *handlers.List[handlers.AuditRecord]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		l, err := r.Audit.GetAll(q)
+		if err != nil {
+			return err
+		}
+		records = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
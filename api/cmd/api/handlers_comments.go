@@ -0,0 +1,315 @@
+package main
+
+import (
+	"api/cmd/api/handlers"
+	"api/internal/validator"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// postsCommentsGetAll godoc
+// @Summary      Get post comments
+// @Description  Returns a page of the comments of a post, oldest first unless sorted otherwise. In flat mode the page holds comments of every depth. In tree mode it holds the top level comments, each with its replies nested under replies
+// @Tags         comments
+// @Param        id       path      string  true   "Post ID"
+// @Param        mode     query     string  false  "flat or tree. Default flat"
+// @Param        depth    query     int     false  "Deepest replies to return, 0 for top level comments only. Default and at most 10"
+// @Param        limit    query     int     false  "Page size, capped by the server"
+// @Param        cursor   query     string  false  "Cursor from a previous page"
+// @Param        user_id  query     string  false  "Author ID"
+// @Param        sort     query     string  false  "created_at or -created_at. Default created_at"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.Comment]
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/posts/{id}/comments [get]
+func (app *application) postsCommentsGetAll(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.List[handlers.Comment], error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = "flat"
+	}
+	depth := handlers.MaxCommentDepth
+	v := validator.Validator{}
+	v.CheckField(validator.In(mode, "flat", "tree"), "mode", "must be flat or tree")
+	if s := query.Get("depth"); s != "" {
+		depth, err = strconv.Atoi(s)
+		v.CheckField(err == nil && validator.Between(depth, 0, handlers.MaxCommentDepth), "depth", fmt.Sprintf("must be an integer from 0 to %d", handlers.MaxCommentDepth))
+	}
+	if v.HasErrors() {
+		return nil, handlers.NewValidationError(v)
+	}
+	query = maps.Clone(query)
+	query.Del("mode")
+	query.Del("depth")
+
+	q, err := app.parseListQuery(ctx, query, handlers.CommentListSpec.Without("post_id"))
+	if err != nil {
+		return nil, err
+	}
+	q.Where(handlers.CommentListSpec.Filters["post_id"], id)
+	if mode == "tree" {
+		q.Where(handlers.CommentDepthFilter, 0)
+	} else {
+		q.Where(handlers.CommentDepthFilter, depth)
+	}
+
+	var comments *handlers.List[handlers.Comment]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		p, err := r.Posts.Get(id)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+
+		l, err := r.Comments.GetAll(q)
+		if err != nil {
+			return err
+		}
+		if mode == "tree" {
+			rootIds := make([]uuid.UUID, len(l.Data))
+			for i, c := range l.Data {
+				rootIds[i] = c.Id
+			}
+			replies, err := r.Comments.Replies(rootIds, depth)
+			if err != nil {
+				return err
+			}
+			handlers.CommentTree(l.Data, replies)
+		}
+		comments = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// postsCommentsCreate godoc
+// @Summary      Create post comment
+// @Description  Comments on a post, or replies to one of its comments with parent_id. Replies nest at most 10 levels deep
+// @Tags         comments
+// @Accept       json
+// @Produce      json
+// @Param        id               path      string                 true   "Post ID"
+// @Param        comment          body      handlers.CommentInput  true   "Comment Input"
+// @Param        Idempotency-Key  header    string                 false  "Replays the response of an earlier request with the same key"
+// @Success      201              {object}  handlers.Comment
+// @Failure      400              {object}  error
+// @Failure      404              {object}  error
+// @Failure      422              {object}  error
+// @Failure      500              {object}  error
+// @Router       /api/posts/{id}/comments [post]
+func (app *application) postsCommentsCreate(ctx context.Context, params httprouter.Params, body []byte) (*handlers.Comment, error) {
+	var input *handlers.CommentInput
+	err := json.Unmarshal(body, &input)
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if input == nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("missing comment"))
+	}
+	if v := input.Validate(); v.HasErrors() {
+		return nil, handlers.NewUnprocessableError(v)
+	}
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var comment *handlers.Comment
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		p, err := r.Posts.Get(id)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+
+		c, err := r.Comments.Create(id, input)
+		if err != nil {
+			return err
+		}
+		comment = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// commentsGet godoc
+// @Summary      Get comment by ID
+// @Description  Returns a single comment by UUID, without its replies
+// @Tags         comments
+// @Param        id             path      string  true   "Comment ID"
+// @Param        If-None-Match  header    string  false  "ETag from a previous response, answered with 304 if unchanged"
+// @Produce      json
+// @Success      200  {object}  handlers.Comment
+// @Success      304  "Not modified"
+// @Header       200  {string}  ETag  "Version of the comment"
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/comments/{id} [get]
+func (app *application) commentsGet(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.Comment, error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var comment *handlers.Comment
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		c, err := r.Comments.Get(id)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("comment does not exist"))
+		}
+		comment = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// commentsUpdate godoc
+// @Summary      Update comment
+// @Description  Changes the content of a comment. Its author and parent stay
+// @Tags         comments
+// @Accept       json
+// @Produce      json
+// @Param        id        path      string                 true   "Comment ID"
+// @Param        If-Match  header    string                 false  "ETag from a previous response"
+// @Param        comment   body      handlers.CommentInput  true   "Updated Comment"
+// @Success      200   {object}  handlers.Comment
+// @Header       200   {string}  ETag  "Version of the comment"
+// @Failure      400   {object}  error
+// @Failure      404   {object}  error
+// @Failure      412   {object}  error
+// @Failure      422   {object}  error
+// @Failure      428   {object}  error
+// @Failure      500   {object}  error
+// @Router       /api/comments/{id} [put]
+func (app *application) commentsUpdate(ctx context.Context, params httprouter.Params, body []byte) (*handlers.Comment, error) {
+	var input *handlers.CommentInput
+	err := json.Unmarshal(body, &input)
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if input == nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("missing comment"))
+	}
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var comment *handlers.Comment
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		current, err := r.Comments.Get(id)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("comment does not exist"))
+		}
+		err = app.checkIfMatch(ctx, current.ETag())
+		if err != nil {
+			return err
+		}
+
+		input.UserId = current.UserId
+		input.ParentId = current.ParentId
+		if v := input.Validate(); v.HasErrors() {
+			return handlers.NewUnprocessableError(v)
+		}
+
+		c, err := r.Comments.Update(id, input)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("comment does not exist"))
+		}
+		comment = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// commentsDelete godoc
+// @Summary      Delete comment
+// @Description  Deletes a comment by ID for good, along with its replies
+// @Tags         comments
+// @Produce      json
+// @Param        id        path      string  true   "Comment ID"
+// @Param        If-Match  header    string  false  "ETag from a previous response"
+// @Success      200   {object}  handlers.Comment
+// @Failure      404   {object}  error
+// @Failure      412   {object}  error
+// @Failure      428   {object}  error
+// @Failure      500   {object}  error
+// @Router       /api/comments/{id} [delete]
+func (app *application) commentsDelete(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.Comment, error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("comment does not exist"))
+	}
+
+	var comment *handlers.Comment
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		current, err := r.Comments.Get(id)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("comment does not exist"))
+		}
+		err = app.checkIfMatch(ctx, current.ETag())
+		if err != nil {
+			return err
+		}
+
+		c, err := r.Comments.Delete(id)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("comment does not exist"))
+		}
+		comment = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"api/cmd/api/handlers"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// usersFollow godoc
+// @Summary      Follow user
+// @Description  Makes the user of X-User-Id follow the user, whose posts then show up in the follower's feed. Following a user again returns the existing follow
+// @Tags         follows
+// @Produce      json
+// @Param        id               path      string  true   "ID of the user to follow"
+// @Param        X-User-Id        header    string  true   "ID of the user following"
+// @Param        Idempotency-Key  header    string  false  "Replays the response of an earlier request with the same key"
+// @Success      201              {object}  handlers.Follow
+// @Failure      400              {object}  error
+// @Failure      404              {object}  error
+// @Failure      422              {object}  error
+// @Failure      500              {object}  error
+// @Router       /api/users/{id}/follow [post]
+func (app *application) usersFollow(ctx context.Context, params httprouter.Params, _ []byte) (*handlers.Follow, error) {
+	followerId, ok := handlers.ViewerFrom(ctx)
+	if !ok {
+		return nil, errViewerRequired
+	}
+	input := &handlers.FollowInput{FollowerId: followerId}
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var follow *handlers.Follow
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		u, err := r.Users.Get(id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+
+		f, err := r.Follows.Create(id, input)
+		if err != nil {
+			return err
+		}
+		follow = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return follow, nil
+}
+
+// usersUnfollow godoc
+// @Summary      Unfollow user
+// @Description  Makes the user of X-User-Id stop following the user
+// @Tags         follows
+// @Produce      json
+// @Param        id         path      string  true  "ID of the followed user"
+// @Param        X-User-Id  header    string  true  "ID of the user following"
+// @Success      200  {object}  handlers.Follow
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/users/{id}/follow [delete]
+func (app *application) usersUnfollow(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.Follow, error) {
+	followerId, ok := handlers.ViewerFrom(ctx)
+	if !ok {
+		return nil, errViewerRequired
+	}
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+	}
+
+	var follow *handlers.Follow
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		f, err := r.Follows.Delete(followerId, id)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("follow does not exist"))
+		}
+		follow = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return follow, nil
+}
+
+// usersFollowersGetAll godoc
+// @Summary      Get followers
+// @Description  Returns a page of the users following the user, most recently followed first unless sorted otherwise, with their total number in total. Deleted users are left out
+// @Tags         follows
+// @Param        id      path      string  true   "User ID"
+// @Param        limit   query     int     false  "Page size, capped by the server"
+// @Param        cursor  query     string  false  "Cursor from a previous page"
+// @Param        sort    query     string  false  "Comma separated fields, - for descending: followed_at, name. Default -followed_at"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.FollowUser]
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/users/{id}/followers [get]
+func (app *application) usersFollowersGetAll(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.List[handlers.FollowUser], error) {
+	return app.followUsers(ctx, params, query, true)
+}
+
+// usersFollowingGetAll godoc
+// @Summary      Get followed users
+// @Description  Returns a page of the users the user follows, most recently followed first unless sorted otherwise, with their total number in total. Deleted users are left out
+// @Tags         follows
+// @Param        id      path      string  true   "User ID"
+// @Param        limit   query     int     false  "Page size, capped by the server"
+// @Param        cursor  query     string  false  "Cursor from a previous page"
+// @Param        sort    query     string  false  "Comma separated fields, - for descending: followed_at, name. Default -followed_at"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.FollowUser]
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/users/{id}/following [get]
+func (app *application) usersFollowingGetAll(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.List[handlers.FollowUser], error) {
+	return app.followUsers(ctx, params, query, false)
+}
+
+// followUsers lists the followers of the user of the path, or the users
+// they follow, with the count of them.
+func (app *application) followUsers(ctx context.Context, params httprouter.Params, query url.Values, followers bool) (*handlers.List[handlers.FollowUser], error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	q, err := app.parseListQuery(ctx, query, handlers.FollowListSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	var users *handlers.List[handlers.FollowUser]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		u, err := r.Users.Get(id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+
+		list := r.Follows.Following
+		if followers {
+			list = r.Follows.Followers
+		}
+		l, err := list(id, q)
+		if err != nil {
+			return err
+		}
+		counts, err := r.Follows.Counts(id)
+		if err != nil {
+			return err
+		}
+		total := counts.Following
+		if followers {
+			total = counts.Followers
+		}
+		l.Total = &total
+		users = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// usersFeedGetAll godoc
+// @Summary      Get user feed
+// @Description  Returns a page of the published posts of the users the user follows, newest first, with keyset paging. Deleted posts are left out
+// @Tags         follows
+// @Param        id               path      string    true   "User ID"
+// @Param        limit            query     int       false  "Page size, capped by the server"
+// @Param        cursor           query     string    false  "Cursor from a previous page"
+// @Param        title_contains   query     string    false  "Case-insensitive substring of the title"
+// @Param        created_after    query     string    false  "RFC 3339 time"
+// @Param        created_before   query     string    false  "RFC 3339 time"
+// @Param        tag              query     []string  false  "Tag, repeat for more" collectionFormat(multi)
+// @Param        tag_mode         query     string    false  "any or all of the tags. Default any"
+// @Param        status           query     string    false  "draft, scheduled, published or archived. Default published, the others admin only"
+// @Param        sort             query     string    false  "created_at or -created_at. Default -created_at"
+// @Param        content          query     string    false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.Post]
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/users/{id}/feed [get]
+func (app *application) usersFeedGetAll(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.List[handlers.Post], error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	q, err := app.parsePostListQuery(ctx, query, handlers.FeedListSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	var posts *handlers.List[handlers.Post]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		u, err := r.Users.Get(id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+
+		l, err := r.Posts.Feed(id, q)
+		if err != nil {
+			return err
+		}
+		posts = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
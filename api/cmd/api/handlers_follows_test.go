@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"api/cmd/api/handlers"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestUsersFollowViewer(t *testing.T) {
+	app := &application{store: handlers.NewMemoryStore()}
+
+	var follower, followee *handlers.User
+	err := app.store.BeginTx(context.Background(), nil, func(r *handlers.Repositories) error {
+		var err error
+		follower, err = r.Users.Create(&handlers.UserInput{Name: "one", Email: "one@example.com"})
+		if err != nil {
+			return err
+		}
+		followee, err = r.Users.Create(&handlers.UserInput{Name: "two", Email: "two@example.com"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := httprouter.Params{{Key: "id", Value: followee.Id.String()}}
+
+	// The follower comes from X-User-Id, never from the body or the query.
+	_, err = app.usersFollow(context.Background(), params, []byte(`{"follower_id":"`+follower.Id.String()+`"}`))
+	if err != errViewerRequired {
+		t.Errorf("Follow without a viewer: %v", err)
+	}
+	_, err = app.usersUnfollow(context.Background(), params, nil)
+	if err != errViewerRequired {
+		t.Errorf("Unfollow without a viewer: %v", err)
+	}
+
+	ctx := handlers.ContextWithViewer(context.Background(), follower.Id)
+	f, err := app.usersFollow(ctx, params, nil)
+	if err != nil || f.FollowerId != follower.Id || f.FolloweeId != followee.Id {
+		t.Errorf("Wrong follow: %+v %v", f, err)
+	}
+	f, err = app.usersUnfollow(ctx, params, nil)
+	if err != nil || f.FollowerId != follower.Id {
+		t.Errorf("Wrong unfollow: %+v %v", f, err)
+	}
+	_, err = app.usersUnfollow(ctx, params, nil)
+	if errorStatus(err) != http.StatusNotFound {
+		t.Errorf("Unfollow twice: %v", err)
+	}
+}
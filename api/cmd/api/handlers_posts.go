@@ -2,12 +2,16 @@ package main
 
 import (
 	"api/cmd/api/handlers"
+	"api/internal/validator"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -15,20 +19,38 @@ import (
 
 // postsGetAll godoc
 // @Summary      Get all posts
-// @Description  Returns a list of all posts
+// @Description  Returns a page of published posts, newest first unless sorted otherwise. Follow next_cursor or prev_cursor, or the Link header, to get the other pages.
 // @Tags         posts
+// @Param        limit            query     int     false  "Page size, capped by the server"
+// @Param        cursor           query     string  false  "Cursor from a previous page"
+// @Param        user_id          query     string  false  "Author ID"
+// @Param        title_contains   query     string  false  "Case-insensitive substring of the title"
+// @Param        created_after    query     string  false  "RFC 3339 time"
+// @Param        created_before   query     string  false  "RFC 3339 time"
+// @Param        tag              query     []string  false  "Tag, repeat for more" collectionFormat(multi)
+// @Param        tag_mode         query     string  false  "any or all of the tags. Default any"
+// @Param        status           query     string  false  "draft, scheduled, published or archived. Default published, the others admin only"
+// @Param        include_deleted  query     bool    false  "Include soft deleted rows, admin only"
+// @Param        sort             query     string  false  "Comma separated fields, - for descending: created_at, title. Default -created_at"
+// @Param        content          query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
 // @Produce      json
-// @Success      200  {array}  handlers.Post
+// @Success      200  {object}  handlers.List[handlers.Post]
+// @Failure      400  {object}  error
 // @Failure      500  {object}  error
 // @Router       /api/posts [get]
-func (app *application) postsGetAll(ctx context.Context, _ httprouter.Params, _ url.Values) ([]*handlers.Post, error) {
-	posts := []*handlers.Post{}
-	err := app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		u, err := handlers.PostsGetAllTx(tx)
+func (app *application) postsGetAll(ctx context.Context, _ httprouter.Params, query url.Values) (*handlers.List[handlers.Post], error) {
+	q, err := app.parsePostListQuery(ctx, query, handlers.PostListSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	var posts *handlers.List[handlers.Post]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		l, err := r.Posts.GetAll(q)
 		if err != nil {
 			return err
 		}
-		posts = u
+		posts = l
 		return nil
 	})
 	if err != nil {
@@ -37,28 +59,93 @@ func (app *application) postsGetAll(ctx context.Context, _ httprouter.Params, _
 	return posts, nil
 }
 
+// postsSearch godoc
+// @Summary      Search posts
+// @Description  Full-text search over the titles and contents of posts, best match first unless sorted otherwise. Title matches rank higher. Each result has a headline, a snippet of its content with the matches in <b> tags.
+// @Tags         posts
+// @Param        q        query     string  true   "Search in websearch_to_tsquery syntax: words, \"quoted phrases\", OR and -negation"
+// @Param        limit    query     int     false  "Page size, capped by the server"
+// @Param        cursor   query     string  false  "Cursor from a previous page"
+// @Param        user_id  query     string  false  "Author ID"
+// @Param        status   query     string  false  "draft, scheduled, published or archived. Default published, the others admin only"
+// @Param        sort     query     string  false  "Comma separated fields, - for descending: rank, created_at. Default -rank"
+// @Param        content  query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.PostSearchResult]
+// @Failure      400  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/posts/search [get]
+func (app *application) postsSearch(ctx context.Context, _ httprouter.Params, query url.Values) (*handlers.List[handlers.PostSearchResult], error) {
+	text := query.Get("q")
+	v := validator.Validator{}
+	v.CheckField(validator.NotBlank(text), "q", "must not be blank")
+	if v.HasErrors() {
+		return nil, handlers.NewValidationError(v)
+	}
+	query = maps.Clone(query)
+	query.Del("q")
+	q, err := app.parseListQuery(ctx, query, handlers.PostSearchListSpec)
+	if err != nil {
+		return nil, err
+	}
+	err = app.publishedOnly(ctx, query, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var results *handlers.List[handlers.PostSearchResult]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		l, err := r.Posts.Search(text, q)
+		if err != nil {
+			return err
+		}
+		results = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
 // postsGet godoc
 // @Summary      Get post by ID
-// @Description  Returns a single post by UUID
+// @Description  Returns a single post by UUID. Posts that aren't published are only found by admins
 // @Tags         posts
-// @Param        id   path      string  true  "Post ID"
+// @Param        id               path      string  true   "Post ID"
+// @Param        include_deleted  query     bool    false  "Find soft deleted posts too, admin only"
+// @Param        If-None-Match    header    string  false  "ETag from a previous response, answered with 304 if unchanged"
+// @Param        content          query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
 // @Produce      json
 // @Success      200  {object}  handlers.Post
+// @Success      304  "Not modified"
+// @Header       200  {string}  ETag  "Version of the post and hash of its reactions"
 // @Failure      404  {object}  error
 // @Failure      500  {object}  error
 // @Router       /api/posts/{id} [get]
-func (app *application) postsGet(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.Post, error) {
+func (app *application) postsGet(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.Post, error) {
 	var post *handlers.Post
 	id, err := uuid.Parse(params.ByName("id"))
 	if err != nil {
 		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
 	}
+	includeDeleted, err := app.includeDeleted(ctx, query)
+	if err != nil {
+		return nil, err
+	}
 
-	err = app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		u, err := handlers.PostsGetTx(tx, id)
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		get := r.Posts.Get
+		if includeDeleted {
+			get = r.Posts.GetWithDeleted
+		}
+		u, err := get(id)
 		if err != nil {
 			return err
 		}
+		if u == nil || !postVisible(ctx, u) {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
 		post = u
 		return nil
 	})
@@ -68,17 +155,60 @@ func (app *application) postsGet(ctx context.Context, params httprouter.Params,
 	return post, nil
 }
 
+// postsGetBySlug godoc
+// @Summary      Get post by slug
+// @Description  Returns the live post with the slug. Posts that aren't published are only found by admins. Slugs the post had before its title changed redirect to the current one
+// @Tags         posts
+// @Param        slug           path      string  true   "Slug"
+// @Param        If-None-Match  header    string  false  "ETag from a previous response, answered with 304 if unchanged"
+// @Param        content        query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Produce      json
+// @Success      200  {object}  handlers.Post
+// @Success      301  "Moved to the current slug"
+// @Success      304  "Not modified"
+// @Header       200  {string}  ETag      "Version of the post and hash of its reactions"
+// @Header       301  {string}  Location  "URL of the current slug"
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/posts/by-slug/{slug} [get]
+func (app *application) postsGetBySlug(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.Post, error) {
+	slug := params.ByName("slug")
+
+	var post *handlers.Post
+	err := app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		p, err := r.Posts.GetBySlug(slug)
+		if err != nil {
+			return err
+		}
+		if p == nil || !postVisible(ctx, p) {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+		post = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if post.Slug != slug {
+		return nil, &movedError{"/api/posts/by-slug/" + url.PathEscape(post.Slug)}
+	}
+	return post, nil
+}
+
 // postsCreate godoc
 // @Summary      Create post
-// @Description  Creates a new post
+// @Description  Creates a new post. Tags are turned into lowercase slugs, at most 10 of them. Posts are published unless created as a draft, or scheduled for publish_at
 // @Tags         posts
 // @Accept       json
 // @Produce      json
-// @Param        post  body      handlers.PostInput  true  "Post Input"
-// @Success      201   {object}  handlers.Post
-// @Failure      400  {object}  error
-// @Failure      404  {object}  error
-// @Failure      500   {object}  error
+// @Param        post             body      handlers.PostInput  true   "Post Input"
+// @Param        Idempotency-Key  header    string              false  "Replays the response of an earlier request with the same key"
+// @Param        content          query     string              false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Success      201              {object}  handlers.Post
+// @Failure      400              {object}  error
+// @Failure      409              {object}  error
+// @Failure      422              {object}  error
+// @Failure      500              {object}  error
 // @Router       /api/posts [post]
 func (app *application) postsCreate(ctx context.Context, params httprouter.Params, body []byte) (*handlers.Post, error) {
 	var input *handlers.PostInput
@@ -86,18 +216,16 @@ func (app *application) postsCreate(ctx context.Context, params httprouter.Param
 	if err != nil {
 		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
 	}
+	if input == nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("missing post"))
+	}
+	if v := input.Validate(); v.HasErrors() {
+		return nil, handlers.NewUnprocessableError(v)
+	}
 
 	var post *handlers.Post
-	err = app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		u, err := handlers.UsersGetTx(tx, input.UserId)
-		if err != nil {
-			return err
-		}
-		if u == nil {
-			return handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("user does not exist"))
-		}
-
-		p, err := handlers.PostsCreateTx(tx, input)
+	err = app.store.BeginTx(ctx, &sql.TxOptions{}, func(r *handlers.Repositories) error {
+		p, err := r.Posts.Create(input)
 		if err != nil {
 			return err
 		}
@@ -110,17 +238,49 @@ func (app *application) postsCreate(ctx context.Context, params httprouter.Param
 	return post, nil
 }
 
+// postsBatchCreate godoc
+// @Summary      Create posts in a batch
+// @Description  Creates many posts at once. Atomic batches, the default, create every post or none. With atomic=false every valid post is created and the others fail on their own. Each item reports its index, status and errors
+// @Tags         posts
+// @Accept       json
+// @Produce      json
+// @Param        atomic           query     bool                  false  "All or nothing, true by default"
+// @Param        posts            body      []handlers.PostInput  true   "Posts"
+// @Param        Idempotency-Key  header    string                false  "Replays the response of an earlier request with the same key"
+// @Param        content          query     string                false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Success      200              {object}  handlers.BatchResult[handlers.Post]
+// @Success      207              {object}  handlers.BatchResult[handlers.Post]
+// @Failure      400              {object}  error
+// @Failure      409              {object}  error
+// @Failure      413              {object}  error
+// @Failure      422              {object}  handlers.BatchResult[handlers.Post]
+// @Failure      500              {object}  error
+// @Router       /api/posts/batch [post]
+func (app *application) postsBatchCreate(ctx context.Context, _ httprouter.Params, body []byte) (*handlers.BatchResult[handlers.Post], error) {
+	inputs, atomic, err := parseBatch[handlers.PostInput](app, ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	return handlers.PostBatch.Run(ctx, app.store, inputs, atomic)
+}
+
 // postsUpdate godoc
 // @Summary      Update post
-// @Description  Updates an existing post by ID
+// @Description  Updates an existing post by ID. Tags and status are replaced when given and kept when left out
 // @Tags         posts
 // @Accept       json
 // @Produce      json
-// @Param        id    path      string               true  "Post ID"
-// @Param        post  body      handlers.PostInput   true  "Updated Post"
+// @Param        id        path      string              true   "Post ID"
+// @Param        If-Match  header    string              false  "ETag from a previous response"
+// @Param        post      body      handlers.PostInput  true   "Updated Post"
+// @Param        content   query     string              false  "raw, html or both: the content as written, rendered or both. Default both"
 // @Success      200   {object}  handlers.Post
+// @Header       200   {string}  ETag  "Version of the post and hash of its reactions"
 // @Failure      400   {object}  error
 // @Failure      404   {object}  error
+// @Failure      412   {object}  error
+// @Failure      422   {object}  error
+// @Failure      428   {object}  error
 // @Failure      500   {object}  error
 // @Router       /api/posts/{id} [put]
 func (app *application) postsUpdate(ctx context.Context, params httprouter.Params, body []byte) (*handlers.Post, error) {
@@ -129,6 +289,12 @@ func (app *application) postsUpdate(ctx context.Context, params httprouter.Param
 	if err != nil {
 		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
 	}
+	if input == nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("missing post"))
+	}
+	if v := input.Validate(); v.HasErrors() {
+		return nil, handlers.NewUnprocessableError(v)
+	}
 
 	id, err := uuid.Parse(params.ByName("id"))
 	if err != nil {
@@ -136,16 +302,20 @@ func (app *application) postsUpdate(ctx context.Context, params httprouter.Param
 	}
 
 	var post *handlers.Post
-	err = app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		u, err := handlers.UsersGetTx(tx, input.UserId)
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		current, err := r.Posts.Get(id)
 		if err != nil {
 			return err
 		}
-		if u == nil {
-			return handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("user does not exist"))
+		if current == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+		err = app.checkIfMatch(ctx, current.ETag())
+		if err != nil {
+			return err
 		}
 
-		p, err := handlers.PostsUpdateTx(tx, id, input)
+		p, err := r.Posts.Update(id, input)
 		if err != nil {
 			return err
 		}
@@ -161,14 +331,82 @@ func (app *application) postsUpdate(ctx context.Context, params httprouter.Param
 	return post, nil
 }
 
+// postsPatch godoc
+// @Summary      Patch post
+// @Description  Changes some fields of a post, with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) of its title, content, user_id, tags, status and publish_at
+// @Tags         posts
+// @Accept       application/merge-patch+json
+// @Accept       application/json-patch+json
+// @Produce      json
+// @Param        id        path      string  true   "Post ID"
+// @Param        If-Match  header    string  false  "ETag from a previous response"
+// @Param        patch     body      object  true   "Merge patch or JSON patch"
+// @Param        content   query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Success      200   {object}  handlers.Post
+// @Header       200   {string}  ETag  "Version of the post and hash of its reactions"
+// @Failure      400   {object}  error
+// @Failure      404   {object}  error
+// @Failure      409   {object}  error
+// @Failure      412   {object}  error
+// @Failure      415   {object}  error
+// @Failure      422   {object}  error
+// @Failure      428   {object}  error
+// @Failure      500   {object}  error
+// @Router       /api/posts/{id} [patch]
+func (app *application) postsPatch(ctx context.Context, params httprouter.Params, body []byte) (*handlers.Post, error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var post *handlers.Post
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		current, err := r.Posts.Get(id)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+		err = app.checkIfMatch(ctx, current.ETag())
+		if err != nil {
+			return err
+		}
+
+		input, err := handlers.Patch(contextContentType(ctx), current.Input(), body)
+		if err != nil {
+			return err
+		}
+		if v := input.Validate(); v.HasErrors() {
+			return handlers.NewUnprocessableError(v)
+		}
+
+		p, err := r.Posts.Update(id, input)
+		if err != nil {
+			return err
+		}
+		post = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
 // postsDelete godoc
 // @Summary      Delete post
-// @Description  Deletes a post by ID
+// @Description  Soft deletes a post by ID. Restore it with /api/posts/{id}/restore until it is purged
 // @Tags         posts
 // @Produce      json
-// @Param        id    path      string  true  "Post ID"
+// @Param        id        path      string  true   "Post ID"
+// @Param        If-Match  header    string  false  "ETag from a previous response"
+// @Param        content   query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
 // @Success      200   {object}  handlers.Post
+// @Header       200   {string}  ETag  "Version of the post and hash of its reactions"
 // @Failure      404   {object}  error
+// @Failure      412   {object}  error
+// @Failure      428   {object}  error
 // @Failure      500   {object}  error
 // @Router       /api/posts/{id} [delete]
 func (app *application) postsDelete(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.Post, error) {
@@ -178,8 +416,20 @@ func (app *application) postsDelete(ctx context.Context, params httprouter.Param
 	}
 
 	var post *handlers.Post
-	err = app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		p, err := handlers.PostsDeleteTx(tx, id)
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		current, err := r.Posts.Get(id)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+		err = app.checkIfMatch(ctx, current.ETag())
+		if err != nil {
+			return err
+		}
+
+		p, err := r.Posts.Delete(id)
 		if err != nil {
 			return err
 		}
@@ -194,3 +444,261 @@ func (app *application) postsDelete(ctx context.Context, params httprouter.Param
 	}
 	return post, nil
 }
+
+// postsRestore godoc
+// @Summary      Restore post
+// @Description  Restores a soft deleted post. Posts of a deleted user come back when the user is restored
+// @Tags         posts
+// @Produce      json
+// @Param        id       path      string  true   "Post ID"
+// @Param        content  query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Success      200   {object}  handlers.Post
+// @Failure      404   {object}  error
+// @Failure      409   {object}  error
+// @Failure      500   {object}  error
+// @Router       /api/posts/{id}/restore [post]
+func (app *application) postsRestore(ctx context.Context, params httprouter.Params, _ []byte) (*handlers.Post, error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var post *handlers.Post
+	err = app.store.BeginTx(ctx, &sql.TxOptions{}, func(r *handlers.Repositories) error {
+		p, err := r.Posts.Restore(id)
+		if errors.Is(err, handlers.ErrUserDeleted) {
+			return handlers.NewHTTPError(http.StatusConflict, err)
+		}
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+		post = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// postsRevisionsGetAll godoc
+// @Summary      Get post revisions
+// @Description  Returns a page of the revisions of a post, newest first. Each one is the title and content after a change
+// @Tags         posts
+// @Param        id      path      string  true   "Post ID"
+// @Param        limit   query     int     false  "Page size, capped by the server"
+// @Param        cursor  query     string  false  "Cursor from a previous page"
+// @Param        sort    query     string  false  "created_at or -created_at. Default -created_at"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.PostRevision]
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/posts/{id}/revisions [get]
+func (app *application) postsRevisionsGetAll(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.List[handlers.PostRevision], error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	q, err := app.parseListQuery(ctx, query, handlers.RevisionListSpec.Without("post_id"))
+	if err != nil {
+		return nil, err
+	}
+	q.Where(handlers.RevisionListSpec.Filters["post_id"], id)
+
+	var revisions *handlers.List[handlers.PostRevision]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		p, err := r.Posts.Get(id)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+
+		l, err := r.Revisions.GetAll(q)
+		if err != nil {
+			return err
+		}
+		revisions = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// parseRevisionParams reads the post ID and revision number of the
+// revision routes.
+func parseRevisionParams(params httprouter.Params) (uuid.UUID, int, error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return uuid.Nil, 0, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+	rev, err := strconv.Atoi(params.ByName("rev"))
+	if err != nil || rev < 1 {
+		return uuid.Nil, 0, handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("revision must be a positive integer"))
+	}
+	return id, rev, nil
+}
+
+// getRevision finds a revision of a post that isn't deleted.
+func getRevision(r *handlers.Repositories, id uuid.UUID, rev int) (*handlers.PostRevision, error) {
+	p, err := r.Posts.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+	}
+	revision, err := r.Revisions.Get(id, rev)
+	if err != nil {
+		return nil, err
+	}
+	if revision == nil {
+		return nil, handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("revision %d does not exist", rev))
+	}
+	return revision, nil
+}
+
+// postsRevisionsGet godoc
+// @Summary      Get post revision
+// @Description  Returns one revision of a post by number
+// @Tags         posts
+// @Param        id   path      string  true  "Post ID"
+// @Param        rev  path      int     true  "Revision number, from 1"
+// @Produce      json
+// @Success      200  {object}  handlers.PostRevision
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/posts/{id}/revisions/{rev} [get]
+func (app *application) postsRevisionsGet(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.PostRevision, error) {
+	id, rev, err := parseRevisionParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var revision *handlers.PostRevision
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		rv, err := getRevision(r, id, rev)
+		if err != nil {
+			return err
+		}
+		revision = rv
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// postsRevisionsDiff godoc
+// @Summary      Diff post revisions
+// @Description  Returns a line diff of the title and content between two revisions of a post, by default the given one and the one before it
+// @Tags         posts
+// @Param        id    path      string  true   "Post ID"
+// @Param        rev   path      int     true   "Revision number, from 1"
+// @Param        from  query     int     false  "Revision to compare with, by default rev - 1"
+// @Produce      json
+// @Success      200  {object}  handlers.RevisionDiff
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/posts/{id}/revisions/{rev}/diff [get]
+func (app *application) postsRevisionsDiff(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.RevisionDiff, error) {
+	id, rev, err := parseRevisionParams(params)
+	if err != nil {
+		return nil, err
+	}
+	from := rev - 1
+	if s := query.Get("from"); s != "" {
+		from, err = strconv.Atoi(s)
+		if err != nil || from < 1 {
+			return nil, handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("from must be a positive integer"))
+		}
+	}
+
+	var diff *handlers.RevisionDiff
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		to, err := getRevision(r, id, rev)
+		if err != nil {
+			return err
+		}
+		// Revision 1 is compared with the empty post.
+		var fromRevision *handlers.PostRevision
+		if from > 0 {
+			fromRevision, err = getRevision(r, id, from)
+			if err != nil {
+				return err
+			}
+		}
+		diff = handlers.NewRevisionDiff(fromRevision, to)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// postsRevisionsRevert godoc
+// @Summary      Revert post
+// @Description  Sets the title and content of a post back to those of a revision, which adds a new revision
+// @Tags         posts
+// @Produce      json
+// @Param        id        path      string  true   "Post ID"
+// @Param        rev       path      int     true   "Revision number, from 1"
+// @Param        If-Match  header    string  false  "ETag from a previous response"
+// @Param        content   query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Success      200   {object}  handlers.Post
+// @Header       200   {string}  ETag  "Version of the post and hash of its reactions"
+// @Failure      400   {object}  error
+// @Failure      404   {object}  error
+// @Failure      412   {object}  error
+// @Failure      428   {object}  error
+// @Failure      500   {object}  error
+// @Router       /api/posts/{id}/revisions/{rev}/revert [post]
+func (app *application) postsRevisionsRevert(ctx context.Context, params httprouter.Params, _ []byte) (*handlers.Post, error) {
+	id, rev, err := parseRevisionParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var post *handlers.Post
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		revision, err := getRevision(r, id, rev)
+		if err != nil {
+			return err
+		}
+		current, err := r.Posts.Get(id)
+		if err != nil {
+			return err
+		}
+		err = app.checkIfMatch(ctx, current.ETag())
+		if err != nil {
+			return err
+		}
+
+		input := current.Input()
+		input.Title = revision.Title
+		input.Content = revision.Content
+		input.ContentFormat = revision.ContentFormat
+		p, err := r.Posts.Update(id, input)
+		if err != nil {
+			return err
+		}
+		post = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api/cmd/api/handlers"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestPostsGetUnpublished(t *testing.T) {
+	app := &application{store: handlers.NewMemoryStore()}
+
+	var post *handlers.Post
+	err := app.store.BeginTx(context.Background(), nil, func(r *handlers.Repositories) error {
+		u, err := r.Users.Create(&handlers.UserInput{Name: "one", Email: "one@example.com"})
+		if err != nil {
+			return err
+		}
+		post, err = r.Posts.Create(&handlers.PostInput{Title: "Draft", Content: "content", UserId: u.Id, Status: handlers.PostDraft})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := contextSetAdmin(httptest.NewRequest(http.MethodGet, "/", nil)).Context()
+	tests := []struct {
+		ctx      context.Context
+		expected int
+	}{
+		{context.Background(), http.StatusNotFound},
+		{admin, http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		_, err := app.postsGet(tc.ctx, httprouter.Params{{Key: "id", Value: post.Id.String()}}, nil)
+		if status := errorStatus(err); status != tc.expected {
+			t.Errorf("Wrong status of postsGet: %d, expected %d (%v)", status, tc.expected, err)
+		}
+		_, err = app.postsGetBySlug(tc.ctx, httprouter.Params{{Key: "slug", Value: post.Slug}}, nil)
+		if status := errorStatus(err); status != tc.expected {
+			t.Errorf("Wrong status of postsGetBySlug: %d, expected %d (%v)", status, tc.expected, err)
+		}
+	}
+}
+
+// errorStatus is the status of the response to a handler that returned err.
+func errorStatus(err error) int {
+	var httpErr *handlers.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
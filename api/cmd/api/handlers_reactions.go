@@ -0,0 +1,126 @@
+package main
+
+import (
+	"api/cmd/api/handlers"
+	"api/internal/validator"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// parseReaction reads the post and kind of a reaction route, and the user
+// reacting from X-User-Id.
+func (app *application) parseReaction(ctx context.Context, params httprouter.Params) (uuid.UUID, uuid.UUID, string, error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, "", handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+	kind := params.ByName("kind")
+	v := validator.Validator{}
+	v.CheckField(validator.In(kind, app.config.reactionKinds...), "kind", "must be one of "+strings.Join(app.config.reactionKinds, ", "))
+	if v.HasErrors() {
+		return uuid.Nil, uuid.Nil, "", handlers.NewValidationError(v)
+	}
+	userId, ok := handlers.ViewerFrom(ctx)
+	if !ok {
+		return uuid.Nil, uuid.Nil, "", errViewerRequired
+	}
+	return id, userId, kind, nil
+}
+
+// postsReactionsPut godoc
+// @Summary      React to post
+// @Description  Adds the reaction of the user of X-User-Id to a post. Reacting again with the same kind changes nothing. Returns the post with its reaction counts
+// @Tags         reactions
+// @Produce      json
+// @Param        id               path      string  true   "Post ID"
+// @Param        kind             path      string  true   "like, or one of the kinds of REACTION_KINDS"
+// @Param        X-User-Id        header    string  true   "ID of the user reacting"
+// @Param        Idempotency-Key  header    string  false  "Replays the response of an earlier request with the same key"
+// @Param        content          query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Success      200              {object}  handlers.Post
+// @Failure      400              {object}  error
+// @Failure      404              {object}  error
+// @Failure      422              {object}  error
+// @Failure      500              {object}  error
+// @Router       /api/posts/{id}/reactions/{kind} [put]
+func (app *application) postsReactionsPut(ctx context.Context, params httprouter.Params, _ []byte) (*handlers.Post, error) {
+	id, userId, kind, err := app.parseReaction(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var post *handlers.Post
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		p, err := r.Posts.Get(id)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+
+		_, err = r.Reactions.Create(id, userId, kind)
+		if err != nil {
+			return err
+		}
+		post, err = r.Posts.Get(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// postsReactionsDelete godoc
+// @Summary      Remove post reaction
+// @Description  Removes the reaction of the user of X-User-Id to a post. Returns the post with its reaction counts
+// @Tags         reactions
+// @Produce      json
+// @Param        id         path      string  true  "Post ID"
+// @Param        kind       path      string  true  "like, or one of the kinds of REACTION_KINDS"
+// @Param        X-User-Id  header    string  true  "ID of the user who reacted"
+// @Param        content    query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Success      200  {object}  handlers.Post
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/posts/{id}/reactions/{kind} [delete]
+func (app *application) postsReactionsDelete(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.Post, error) {
+	id, userId, kind, err := app.parseReaction(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var post *handlers.Post
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		p, err := r.Posts.Get(id)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("post does not exist"))
+		}
+
+		reaction, err := r.Reactions.Delete(id, userId, kind)
+		if err != nil {
+			return err
+		}
+		if reaction == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("reaction does not exist"))
+		}
+		post, err = r.Posts.Get(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
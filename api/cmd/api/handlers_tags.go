@@ -0,0 +1,99 @@
+package main
+
+import (
+	"api/cmd/api/handlers"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// tagsGetAll godoc
+// @Summary      Get all tags
+// @Description  Returns a page of the tags of posts with the number of posts that carry them, most used first unless sorted otherwise. Deleted posts don't count
+// @Tags         tags
+// @Param        limit   query     int     false  "Page size, capped by the server"
+// @Param        cursor  query     string  false  "Cursor from a previous page"
+// @Param        sort    query     string  false  "Comma separated fields, - for descending: count, slug. Default -count"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.Tag]
+// @Failure      400  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/tags [get]
+func (app *application) tagsGetAll(ctx context.Context, _ httprouter.Params, query url.Values) (*handlers.List[handlers.Tag], error) {
+	q, err := app.parseListQuery(ctx, query, handlers.TagListSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags *handlers.List[handlers.Tag]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		l, err := r.Tags.GetAll(q)
+		if err != nil {
+			return err
+		}
+		tags = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// tagsPostsGetAll godoc
+// @Summary      Get tagged posts
+// @Description  Returns a page of the posts with a tag, with the same paging, filters and sorting as /api/posts
+// @Tags         tags
+// @Param        slug             path      string  true   "Tag"
+// @Param        limit            query     int     false  "Page size, capped by the server"
+// @Param        cursor           query     string  false  "Cursor from a previous page"
+// @Param        user_id          query     string  false  "Author ID"
+// @Param        title_contains   query     string  false  "Case-insensitive substring of the title"
+// @Param        created_after    query     string  false  "RFC 3339 time"
+// @Param        created_before   query     string  false  "RFC 3339 time"
+// @Param        tag              query     []string  false  "More tags, repeat for more" collectionFormat(multi)
+// @Param        tag_mode         query     string  false  "any or all of the other tags. Default any"
+// @Param        status           query     string  false  "draft, scheduled, published or archived. Default published, the others admin only"
+// @Param        include_deleted  query     bool    false  "Include soft deleted rows, admin only"
+// @Param        sort             query     string  false  "Comma separated fields, - for descending: created_at, title. Default -created_at"
+// @Param        content          query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.Post]
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/tags/{slug}/posts [get]
+func (app *application) tagsPostsGetAll(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.List[handlers.Post], error) {
+	slug := params.ByName("slug")
+	q, err := app.parsePostListQuery(ctx, query, handlers.PostListSpec)
+	if err != nil {
+		return nil, err
+	}
+	q.Where(handlers.PostTagFilters["all"], handlers.NormalizeTags([]string{slug}))
+
+	var posts *handlers.List[handlers.Post]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		tag, err := r.Tags.Get(slug)
+		if err != nil {
+			return err
+		}
+		if tag == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("tag does not exist"))
+		}
+
+		l, err := r.Posts.GetAll(q)
+		if err != nil {
+			return err
+		}
+		posts = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
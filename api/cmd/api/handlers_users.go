@@ -2,12 +2,14 @@ package main
 
 import (
 	"api/cmd/api/handlers"
+	"api/internal/validator"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -15,20 +17,34 @@ import (
 
 // usersGetAll godoc
 // @Summary      Get all users
-// @Description  Returns a list of all users
+// @Description  Returns a page of users, newest first unless sorted otherwise. Follow next_cursor or prev_cursor, or the Link header, to get the other pages.
 // @Tags         users
+// @Param        limit            query     int     false  "Page size, capped by the server"
+// @Param        cursor           query     string  false  "Cursor from a previous page"
+// @Param        email            query     string  false  "Email, in any case"
+// @Param        name_contains    query     string  false  "Case-insensitive substring of the name"
+// @Param        created_after    query     string  false  "RFC 3339 time"
+// @Param        created_before   query     string  false  "RFC 3339 time"
+// @Param        include_deleted  query     bool    false  "Include soft deleted rows, admin only"
+// @Param        sort             query     string  false  "Comma separated fields, - for descending: created_at, name, email. Default -created_at"
 // @Produce      json
-// @Success      200  {array}  handlers.User
+// @Success      200  {object}  handlers.List[handlers.User]
+// @Failure      400  {object}  error
 // @Failure      500  {object}  error
 // @Router       /api/users [get]
-func (app *application) usersGetAll(ctx context.Context, _ httprouter.Params, _ url.Values) ([]*handlers.User, error) {
-	users := []*handlers.User{}
-	err := app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		u, err := handlers.UsersGetAllTx(tx)
+func (app *application) usersGetAll(ctx context.Context, _ httprouter.Params, query url.Values) (*handlers.List[handlers.User], error) {
+	q, err := app.parseListQuery(ctx, query, handlers.UserListSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	var users *handlers.List[handlers.User]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		l, err := r.Users.GetAll(q)
 		if err != nil {
 			return err
 		}
-		users = u
+		users = l
 		return nil
 	})
 	if err != nil {
@@ -41,21 +57,33 @@ func (app *application) usersGetAll(ctx context.Context, _ httprouter.Params, _
 // @Summary      Get user by ID
 // @Description  Returns a single user by UUID
 // @Tags         users
-// @Param        id   path      string  true  "User ID"
+// @Param        id               path      string  true   "User ID"
+// @Param        include_deleted  query     bool    false  "Find soft deleted users too, admin only"
+// @Param        If-None-Match    header    string  false  "ETag from a previous response, answered with 304 if unchanged"
 // @Produce      json
 // @Success      200  {object}  handlers.User
+// @Success      304  "Not modified"
+// @Header       200  {string}  ETag  "Version of the user"
 // @Failure      404  {object}  error
 // @Failure      500  {object}  error
 // @Router       /api/users/{id} [get]
-func (app *application) usersGet(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.User, error) {
+func (app *application) usersGet(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.User, error) {
 	var user *handlers.User
 	id, err := uuid.Parse(params.ByName("id"))
 	if err != nil {
 		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
 	}
+	includeDeleted, err := app.includeDeleted(ctx, query)
+	if err != nil {
+		return nil, err
+	}
 
-	err = app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		u, err := handlers.UsersGetTx(tx, id)
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		get := r.Users.Get
+		if includeDeleted {
+			get = r.Users.GetWithDeleted
+		}
+		u, err := get(id)
 		if err != nil {
 			return err
 		}
@@ -71,16 +99,98 @@ func (app *application) usersGet(ctx context.Context, params httprouter.Params,
 	return user, nil
 }
 
+// usersGetByEmail godoc
+// @Summary      Get user by email
+// @Description  Returns the live user with the email, compared case-insensitively
+// @Tags         users
+// @Param        email          path      string  true   "Email"
+// @Param        If-None-Match  header    string  false  "ETag from a previous response, answered with 304 if unchanged"
+// @Produce      json
+// @Success      200  {object}  handlers.User
+// @Success      304  "Not modified"
+// @Header       200  {string}  ETag  "Version of the user"
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/users/by-email/{email} [get]
+func (app *application) usersGetByEmail(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.User, error) {
+	email := strings.TrimSpace(params.ByName("email"))
+	v := validator.Validator{}
+	v.CheckField(validator.IsEmail(email), "email", "must be a valid email address")
+	if v.HasErrors() {
+		return nil, handlers.NewValidationError(v)
+	}
+
+	var user *handlers.User
+	err := app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		u, err := r.Users.GetByEmail(email)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+		user = u
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// usersGetByHandle godoc
+// @Summary      Get user by handle
+// @Description  Returns the live user with the handle. Handles the user had before their name changed redirect to the current one
+// @Tags         users
+// @Param        handle         path      string  true   "Handle"
+// @Param        If-None-Match  header    string  false  "ETag from a previous response, answered with 304 if unchanged"
+// @Produce      json
+// @Success      200  {object}  handlers.User
+// @Success      301  "Moved to the current handle"
+// @Success      304  "Not modified"
+// @Header       200  {string}  ETag      "Version of the user"
+// @Header       301  {string}  Location  "URL of the current handle"
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/users/by-handle/{handle} [get]
+func (app *application) usersGetByHandle(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.User, error) {
+	handle := params.ByName("handle")
+
+	var user *handlers.User
+	err := app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		u, err := r.Users.GetByHandle(handle)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+		user = u
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user.Handle != handle {
+		return nil, &movedError{"/api/users/by-handle/" + url.PathEscape(user.Handle)}
+	}
+	return user, nil
+}
+
 // usersCreate godoc
 // @Summary      Create user
 // @Description  Creates a new user
 // @Tags         users
 // @Accept       json
 // @Produce      json
-// @Param        user  body      handlers.UserInput  true  "User Input"
-// @Success      201   {object}  handlers.User
-// @Failure      404  {object}  error
-// @Failure      500   {object}  error
+// @Param        user             body      handlers.UserInput  true   "User Input"
+// @Param        Idempotency-Key  header    string              false  "Replays the response of an earlier request with the same key"
+// @Success      201              {object}  handlers.User
+// @Failure      400              {object}  error
+// @Failure      409              {object}  error
+// @Failure      422              {object}  error
+// @Failure      500              {object}  error
 // @Router       /api/users [post]
 func (app *application) usersCreate(ctx context.Context, params httprouter.Params, body []byte) (*handlers.User, error) {
 	var input *handlers.UserInput
@@ -88,10 +198,16 @@ func (app *application) usersCreate(ctx context.Context, params httprouter.Param
 	if err != nil {
 		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
 	}
+	if input == nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("missing user"))
+	}
+	if v := input.Validate(); v.HasErrors() {
+		return nil, handlers.NewUnprocessableError(v)
+	}
 
 	var user *handlers.User
-	err = app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		u, err := handlers.UsersCreateTx(tx, input)
+	err = app.store.BeginTx(ctx, &sql.TxOptions{}, func(r *handlers.Repositories) error {
+		u, err := r.Users.Create(input)
 		if err != nil {
 			return err
 		}
@@ -104,16 +220,48 @@ func (app *application) usersCreate(ctx context.Context, params httprouter.Param
 	return user, nil
 }
 
+// usersBatchCreate godoc
+// @Summary      Create users in a batch
+// @Description  Creates many users at once. Atomic batches, the default, create every user or none. With atomic=false every valid user is created and the others fail on their own. Each item reports its index, status and errors
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        atomic           query     bool                  false  "All or nothing, true by default"
+// @Param        users            body      []handlers.UserInput  true   "Users"
+// @Param        Idempotency-Key  header    string                false  "Replays the response of an earlier request with the same key"
+// @Success      200              {object}  handlers.BatchResult[handlers.User]
+// @Success      207              {object}  handlers.BatchResult[handlers.User]
+// @Failure      400              {object}  error
+// @Failure      409              {object}  handlers.BatchResult[handlers.User]
+// @Failure      413              {object}  error
+// @Failure      422              {object}  handlers.BatchResult[handlers.User]
+// @Failure      500              {object}  error
+// @Router       /api/users/batch [post]
+func (app *application) usersBatchCreate(ctx context.Context, _ httprouter.Params, body []byte) (*handlers.BatchResult[handlers.User], error) {
+	inputs, atomic, err := parseBatch[handlers.UserInput](app, ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	return handlers.UserBatch.Run(ctx, app.store, inputs, atomic)
+}
+
 // usersUpdate godoc
 // @Summary      Update user
 // @Description  Updates an existing user by ID
 // @Tags         users
 // @Accept       json
 // @Produce      json
-// @Param        id    path      string               true  "User ID"
-// @Param        user  body      handlers.UserInput   true  "Updated User"
+// @Param        id        path      string              true   "User ID"
+// @Param        If-Match  header    string              false  "ETag from a previous response"
+// @Param        user      body      handlers.UserInput  true   "Updated User"
 // @Success      200   {object}  handlers.User
+// @Header       200   {string}  ETag  "Version of the user"
+// @Failure      400   {object}  error
 // @Failure      404   {object}  error
+// @Failure      409   {object}  error
+// @Failure      412   {object}  error
+// @Failure      422   {object}  error
+// @Failure      428   {object}  error
 // @Failure      500   {object}  error
 // @Router       /api/users/{id} [put]
 func (app *application) usersUpdate(ctx context.Context, params httprouter.Params, body []byte) (*handlers.User, error) {
@@ -122,6 +270,12 @@ func (app *application) usersUpdate(ctx context.Context, params httprouter.Param
 	if err != nil {
 		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
 	}
+	if input == nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("missing user"))
+	}
+	if v := input.Validate(); v.HasErrors() {
+		return nil, handlers.NewUnprocessableError(v)
+	}
 
 	id, err := uuid.Parse(params.ByName("id"))
 	if err != nil {
@@ -129,8 +283,20 @@ func (app *application) usersUpdate(ctx context.Context, params httprouter.Param
 	}
 
 	var user *handlers.User
-	err = app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		u, err := handlers.UsersUpdateTx(tx, id, input)
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		current, err := r.Users.Get(id)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+		err = app.checkIfMatch(ctx, current.ETag())
+		if err != nil {
+			return err
+		}
+
+		u, err := r.Users.Update(id, input)
 		if err != nil {
 			return err
 		}
@@ -146,14 +312,80 @@ func (app *application) usersUpdate(ctx context.Context, params httprouter.Param
 	return user, nil
 }
 
+// usersPatch godoc
+// @Summary      Patch user
+// @Description  Changes some fields of a user, with a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) of its name and email
+// @Tags         users
+// @Accept       application/merge-patch+json
+// @Accept       application/json-patch+json
+// @Produce      json
+// @Param        id        path      string  true   "User ID"
+// @Param        If-Match  header    string  false  "ETag from a previous response"
+// @Param        patch     body      object  true   "Merge patch or JSON patch"
+// @Success      200   {object}  handlers.User
+// @Header       200   {string}  ETag  "Version of the user"
+// @Failure      400   {object}  error
+// @Failure      404   {object}  error
+// @Failure      409   {object}  error
+// @Failure      412   {object}  error
+// @Failure      415   {object}  error
+// @Failure      422   {object}  error
+// @Failure      428   {object}  error
+// @Failure      500   {object}  error
+// @Router       /api/users/{id} [patch]
+func (app *application) usersPatch(ctx context.Context, params httprouter.Params, body []byte) (*handlers.User, error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var user *handlers.User
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		current, err := r.Users.Get(id)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+		err = app.checkIfMatch(ctx, current.ETag())
+		if err != nil {
+			return err
+		}
+
+		input, err := handlers.Patch(contextContentType(ctx), current.Input(), body)
+		if err != nil {
+			return err
+		}
+		if v := input.Validate(); v.HasErrors() {
+			return handlers.NewUnprocessableError(v)
+		}
+
+		u, err := r.Users.Update(id, input)
+		if err != nil {
+			return err
+		}
+		user = u
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 // usersDelete godoc
 // @Summary      Delete user
-// @Description  Deletes a user by ID
+// @Description  Soft deletes a user by ID, along with their posts. Restore them with /api/users/{id}/restore until they are purged
 // @Tags         users
 // @Produce      json
-// @Param        id    path      string  true  "User ID"
+// @Param        id        path      string  true   "User ID"
+// @Param        If-Match  header    string  false  "ETag from a previous response"
 // @Success      200   {object}  handlers.User
+// @Header       200   {string}  ETag  "Version of the user"
 // @Failure      404   {object}  error
+// @Failure      412   {object}  error
+// @Failure      428   {object}  error
 // @Failure      500   {object}  error
 // @Router       /api/users/{id} [delete]
 func (app *application) usersDelete(ctx context.Context, params httprouter.Params, _ url.Values) (*handlers.User, error) {
@@ -163,8 +395,20 @@ func (app *application) usersDelete(ctx context.Context, params httprouter.Param
 	}
 
 	var user *handlers.User
-	err = app.db.BeginTx(ctx, &sql.TxOptions{}, func(tx *sql.Tx) error {
-		u, err := handlers.UsersDeleteTx(tx, id)
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		current, err := r.Users.Get(id)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+		err = app.checkIfMatch(ctx, current.ETag())
+		if err != nil {
+			return err
+		}
+
+		u, err := r.Users.Delete(id)
 		if err != nil {
 			return err
 		}
@@ -179,3 +423,164 @@ func (app *application) usersDelete(ctx context.Context, params httprouter.Param
 	}
 	return user, nil
 }
+
+// usersRestore godoc
+// @Summary      Restore user
+// @Description  Restores a soft deleted user and the posts that were deleted with them. Fails with 409 if another user has taken their email since
+// @Tags         users
+// @Produce      json
+// @Param        id    path      string  true  "User ID"
+// @Success      200   {object}  handlers.User
+// @Failure      404   {object}  error
+// @Failure      409   {object}  error
+// @Failure      500   {object}  error
+// @Router       /api/users/{id}/restore [post]
+func (app *application) usersRestore(ctx context.Context, params httprouter.Params, _ []byte) (*handlers.User, error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	var user *handlers.User
+	err = app.store.BeginTx(ctx, &sql.TxOptions{}, func(r *handlers.Repositories) error {
+		u, err := r.Users.Restore(id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+		user = u
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// usersPostsGetAll godoc
+// @Summary      Get posts by user
+// @Description  Returns a page of the user's posts, with the same paging, filters and sorting as /api/posts, and the total number of their posts matching the filters
+// @Tags         users
+// @Param        id               path      string  true   "User ID"
+// @Param        limit            query     int     false  "Page size, capped by the server"
+// @Param        cursor           query     string  false  "Cursor from a previous page"
+// @Param        title_contains   query     string  false  "Case-insensitive substring of the title"
+// @Param        created_after    query     string  false  "RFC 3339 time"
+// @Param        created_before   query     string  false  "RFC 3339 time"
+// @Param        tag              query     []string  false  "Tag, repeat for more" collectionFormat(multi)
+// @Param        tag_mode         query     string  false  "any or all of the tags. Default any"
+// @Param        status           query     string  false  "draft, scheduled, published or archived. Default published, the others admin only"
+// @Param        include_deleted  query     bool    false  "Include soft deleted rows, admin only"
+// @Param        sort             query     string  false  "Comma separated fields, - for descending: created_at, title. Default -created_at"
+// @Param        content          query     string  false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Produce      json
+// @Success      200  {object}  handlers.List[handlers.Post]
+// @Failure      400  {object}  error
+// @Failure      404  {object}  error
+// @Failure      500  {object}  error
+// @Router       /api/users/{id}/posts [get]
+func (app *application) usersPostsGetAll(ctx context.Context, params httprouter.Params, query url.Values) (*handlers.List[handlers.Post], error) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	q, err := app.parsePostListQuery(ctx, query, handlers.PostListSpec.Without("user_id"))
+	if err != nil {
+		return nil, err
+	}
+	q.Where(handlers.PostListSpec.Filters["user_id"], id)
+
+	var posts *handlers.List[handlers.Post]
+	err = app.store.BeginTx(ctx, &sql.TxOptions{ReadOnly: true}, func(r *handlers.Repositories) error {
+		get := r.Users.Get
+		if q.IncludeDeleted {
+			get = r.Users.GetWithDeleted
+		}
+		u, err := get(id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+
+		l, err := r.Posts.GetAll(q)
+		if err != nil {
+			return err
+		}
+		total, err := r.Posts.Count(q)
+		if err != nil {
+			return err
+		}
+		l.Total = &total
+		posts = l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// usersPostsCreate godoc
+// @Summary      Create post for user
+// @Description  Creates a new post by the user in the path. The user_id in the body is ignored
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Param        id               path      string              true   "User ID"
+// @Param        post             body      handlers.PostInput  true   "Post Input"
+// @Param        Idempotency-Key  header    string              false  "Replays the response of an earlier request with the same key"
+// @Param        content          query     string              false  "raw, html or both: the content as written, rendered or both. Default both"
+// @Success      201              {object}  handlers.Post
+// @Failure      400              {object}  error
+// @Failure      404              {object}  error
+// @Failure      409              {object}  error
+// @Failure      422              {object}  error
+// @Failure      500              {object}  error
+// @Router       /api/users/{id}/posts [post]
+func (app *application) usersPostsCreate(ctx context.Context, params httprouter.Params, body []byte) (*handlers.Post, error) {
+	var input *handlers.PostInput
+	err := json.Unmarshal(body, &input)
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+
+	if input == nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, fmt.Errorf("missing post"))
+	}
+
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		return nil, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+	input.UserId = id
+	if v := input.Validate(); v.HasErrors() {
+		return nil, handlers.NewUnprocessableError(v)
+	}
+
+	var post *handlers.Post
+	err = app.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable}, func(r *handlers.Repositories) error {
+		u, err := r.Users.Get(id)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return handlers.NewHTTPError(http.StatusNotFound, fmt.Errorf("user does not exist"))
+		}
+
+		p, err := r.Posts.Create(input)
+		if err != nil {
+			return err
+		}
+		post = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return post, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"api/cmd/api/handlers"
+	"api/internal/validator"
+)
+
+var (
+	errAdminOnly          = handlers.NewHTTPError(http.StatusForbidden, errors.New("include_deleted requires the admin token"))
+	errAuditAdminOnly     = handlers.NewHTTPError(http.StatusForbidden, errors.New("the audit log requires the admin token"))
+	errDebugAdminOnly     = handlers.NewHTTPError(http.StatusForbidden, errors.New("debug routes require the admin token"))
+	errStatusAdminOnly    = handlers.NewHTTPError(http.StatusForbidden, errors.New("posts that aren't published are only listed for the admin token"))
+	errPreconditionFailed = handlers.NewHTTPError(http.StatusPreconditionFailed, errors.New("the resource has changed, fetch it again"))
+	errIfMatchRequired    = handlers.NewHTTPError(http.StatusPreconditionRequired, errors.New("the If-Match header is required"))
+	errEmptyBatch         = handlers.NewHTTPError(http.StatusBadRequest, errors.New("the batch has no items"))
+	errViewerRequired     = handlers.NewHTTPError(http.StatusBadRequest, errors.New("the X-User-Id header is required"))
+	errContentView        = handlers.NewHTTPError(http.StatusBadRequest, errors.New("content must be raw, html or both"))
+)
+
+// contentView reads the content query parameter, which picks the parts of
+// the content of posts that responses carry. Both is the default.
+func contentView(query url.Values) (string, error) {
+	view := query.Get("content")
+	if view == "" {
+		return handlers.ContentViewBoth, nil
+	}
+	if !slices.Contains(handlers.ContentViews, view) {
+		return "", errContentView
+	}
+	return view, nil
+}
+
+// parseListQuery parses the list parameters of a request, capping the page
+// size and keeping deleted rows to admins.
+func (app *application) parseListQuery(ctx context.Context, query url.Values, spec handlers.ListSpec) (*handlers.ListQuery, error) {
+	q, err := handlers.ParseListQuery(query, spec, app.config.maxPageSize)
+	if err != nil {
+		return nil, err
+	}
+	if q.IncludeDeleted && !contextIsAdmin(ctx) {
+		return nil, errAdminOnly
+	}
+	return q, nil
+}
+
+// parsePostListQuery is parseListQuery for lists of posts, which also take
+// tag, repeated for more tags, and tag_mode: any of the tags, the default,
+// or all of them. Lists of posts only show published posts, see
+// publishedOnly.
+func (app *application) parsePostListQuery(ctx context.Context, query url.Values, spec handlers.ListSpec) (*handlers.ListQuery, error) {
+	mode := query.Get("tag_mode")
+	if mode == "" {
+		mode = "any"
+	}
+	filter, ok := handlers.PostTagFilters[mode]
+	tags := query["tag"]
+	v := validator.Validator{}
+	v.CheckField(ok, "tag_mode", "must be any or all")
+	v.CheckField(len(tags) <= handlers.MaxPostTags, "tag", fmt.Sprintf("must not be given more than %d times", handlers.MaxPostTags))
+	if v.HasErrors() {
+		return nil, handlers.NewValidationError(v)
+	}
+	query = maps.Clone(query)
+	query.Del("tag")
+	query.Del("tag_mode")
+
+	q, err := app.parseListQuery(ctx, query, spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) > 0 {
+		q.Where(filter, handlers.NormalizeTags(tags))
+	}
+	return q, app.publishedOnly(ctx, query, q)
+}
+
+// publishedOnly keeps a list of posts to the published ones, unless an
+// admin asks for another status with status.
+func (app *application) publishedOnly(ctx context.Context, query url.Values, q *handlers.ListQuery) error {
+	status := query.Get("status")
+	if status == "" {
+		q.Where(handlers.PostStatusFilter, handlers.PostPublished)
+		return nil
+	}
+	v := validator.Validator{}
+	v.CheckField(validator.In(status, handlers.PostStatuses...), "status", "must be draft, scheduled, published or archived")
+	if v.HasErrors() {
+		return handlers.NewValidationError(v)
+	}
+	if status != handlers.PostPublished && !contextIsAdmin(ctx) {
+		return errStatusAdminOnly
+	}
+	return nil
+}
+
+// postVisible reports whether a post can be read by the request: published
+// posts by anyone, the others by admins only, the way publishedOnly keeps
+// them out of lists.
+func postVisible(ctx context.Context, p *handlers.Post) bool {
+	return p.Status == handlers.PostPublished || contextIsAdmin(ctx)
+}
+
+// includeDeleted reads include_deleted for lookups of a single row.
+func (app *application) includeDeleted(ctx context.Context, query url.Values) (bool, error) {
+	s := query.Get("include_deleted")
+	if s == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, handlers.NewHTTPError(http.StatusBadRequest, errors.New("include_deleted must be true or false"))
+	}
+	if include && !contextIsAdmin(ctx) {
+		return false, errAdminOnly
+	}
+	return include, nil
+}
+
+// checkIfMatch compares the If-Match of the request with the current ETag
+// of the row a handler is about to change. Call it in the same transaction
+// as the change, and a serializable one, so that nothing can sneak in
+// between. Only the versions are compared, reactions to a post since it was
+// read don't fail the change, see handlers.VersionOfETag.
+func (app *application) checkIfMatch(ctx context.Context, etag string) error {
+	ifMatch := contextIfMatch(ctx)
+	if ifMatch == "" {
+		if app.config.requireIfMatch {
+			return errIfMatchRequired
+		}
+		return nil
+	}
+	candidates := strings.Split(ifMatch, ",")
+	for i, candidate := range candidates {
+		candidates[i] = handlers.VersionOfETag(strings.TrimSpace(candidate))
+	}
+	if !etagMatches(strings.Join(candidates, ","), handlers.VersionOfETag(etag), false) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// parseBatch reads the items of a batch create and its atomic flag, true
+// unless the query says otherwise.
+func parseBatch[In any](app *application, ctx context.Context, body []byte) ([]*In, bool, error) {
+	var inputs []*In
+	err := json.Unmarshal(body, &inputs)
+	if err != nil {
+		return nil, false, handlers.NewHTTPError(http.StatusBadRequest, err)
+	}
+	if len(inputs) == 0 {
+		return nil, false, errEmptyBatch
+	}
+	if len(inputs) > app.config.maxBatchSize {
+		return nil, false, handlers.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Errorf("a batch can have at most %d items", app.config.maxBatchSize))
+	}
+
+	atomic := true
+	if s := contextQuery(ctx).Get("atomic"); s != "" {
+		atomic, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, false, handlers.NewHTTPError(http.StatusBadRequest, errors.New("atomic must be true or false"))
+		}
+	}
+	return inputs, atomic, nil
+}
+
+// reactionKinds is like plus the comma separated kinds of REACTION_KINDS,
+// trimmed and lowercased.
+func reactionKinds(s string) []string {
+	kinds := []string{"like"}
+	for _, kind := range strings.Split(s, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if kind != "" && !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"api/cmd/api/handlers"
+	"api/internal/response"
+)
+
+var (
+	errIdempotencyKeyInvalid  = handlers.NewHTTPError(http.StatusBadRequest, errors.New("the Idempotency-Key header must be at most 255 characters"))
+	errIdempotencyKeyReused   = handlers.NewHTTPError(http.StatusUnprocessableEntity, errors.New("the Idempotency-Key was used for a different request"))
+	errIdempotencyKeyInFlight = handlers.NewHTTPError(http.StatusConflict, errors.New("a request with the same Idempotency-Key is in progress"))
+)
+
+// replayedHeaders are the headers of a response that are saved with its
+// Idempotency-Key and sent again to retries. They are spelled the way
+// handleMutation sets them, ETag isn't in canonical form.
+var replayedHeaders = []string{"Content-Type", "ETag", "Link", "Location"}
+
+// serveIdempotent serves a mutation made with an Idempotency-Key. The first
+// request with a key runs, and its response is saved for the key's TTL.
+// Retries with the same key and request get the saved response, retries
+// with a different request a 422, and retries while the first one is still
+// running a 409. Server errors of requests that wrote nothing aren't
+// saved, the key is let go so that the request can be retried. Once the
+// request committed a write, its key is never let go: its response is
+// saved whatever it is, and if even that fails the key stays in progress
+// until it expires, rather than let a retry write again.
+//
+// The request holds its key for IDEMPOTENCY_LEASE. If its process dies
+// before it wrote anything, a retry claims the key once the lease has run
+// out and runs the request again. The writes of a request whose key was
+// claimed that way fail, so the two can't both write.
+//
+// The request runs to the end even if the client goes away, so that its
+// retry finds the response rather than a half done request.
+func (app *application) serveIdempotent(w http.ResponseWriter, r *http.Request, key string, body []byte, serve func(http.ResponseWriter, *http.Request)) {
+	if len(key) > 255 {
+		app.handlerError(w, r, errIdempotencyKeyInvalid)
+		return
+	}
+	hash := requestHash(r, body)
+
+	var saved, held *handlers.IdempotencyKey
+	err := app.store.BeginTx(r.Context(), &sql.TxOptions{}, func(repos *handlers.Repositories) error {
+		k, err := repos.Idempotency.Get(key)
+		if err != nil {
+			return err
+		}
+		if k != nil && k.ExpiresAt.Before(time.Now()) {
+			err = repos.Idempotency.Delete(key)
+			if err != nil {
+				return err
+			}
+			k = nil
+		}
+		lockedUntil := time.Now().Add(app.config.idempotencyLease)
+		if k == nil {
+			held, err = repos.Idempotency.Create(key, hash, lockedUntil, time.Now().Add(app.config.idempotencyKeyTTL))
+			return err
+		}
+
+		if k.RequestHash != hash {
+			return errIdempotencyKeyReused
+		}
+		if k.Claimable() {
+			held, err = repos.Idempotency.Claim(key, lockedUntil)
+			if err == nil && held == nil {
+				err = errIdempotencyKeyInFlight
+			}
+			return err
+		}
+		if k.InFlight() {
+			return errIdempotencyKeyInFlight
+		}
+		saved = k
+		return nil
+	})
+	// Another request created the key first.
+	if c, ok := handlers.AsConstraintError(err); ok && c.Kind == handlers.UniqueViolation {
+		err = errIdempotencyKeyInFlight
+	}
+	if err != nil {
+		app.handlerError(w, r, err)
+		return
+	}
+
+	if saved != nil {
+		for name, values := range saved.Headers {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(saved.Status)
+		w.Write(saved.Body)
+		return
+	}
+
+	base := context.WithoutCancel(r.Context())
+	ctx := handlers.ContextWithIdempotencyKey(base, key, held.LockedUntil)
+	completed := false
+	defer func() {
+		if !completed && !handlers.Committed(ctx) {
+			app.releaseIdempotencyKey(base, held)
+		}
+	}()
+
+	rw := response.NewRecordingResponseWriter(w)
+	serve(rw, r.WithContext(ctx))
+	if !handlers.Committed(ctx) && (rw.StatusCode == 0 || rw.StatusCode >= http.StatusInternalServerError) {
+		return
+	}
+	status := rw.StatusCode
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	headers := http.Header{}
+	for _, name := range replayedHeaders {
+		if values := w.Header()[name]; len(values) > 0 {
+			headers[name] = values
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		err = app.store.BeginTx(base, &sql.TxOptions{}, func(repos *handlers.Repositories) error {
+			return repos.Idempotency.Complete(key, held.LockedUntil, status, headers, rw.Body.Bytes())
+		})
+		if err == nil {
+			completed = true
+			return
+		}
+		if attempt >= completeRetries || errors.Is(err, handlers.ErrIdempotencyLeaseLost) {
+			app.reportServerError(r, err)
+			return
+		}
+		time.Sleep(time.Duration(attempt+1) * 100 * time.Millisecond)
+	}
+}
+
+// completeRetries is how many times serveIdempotent tries again to save a
+// response that it failed to save.
+const completeRetries = 3
+
+// releaseIdempotencyKey forgets a key whose request didn't complete, unless
+// a retry claimed it since.
+func (app *application) releaseIdempotencyKey(ctx context.Context, k *handlers.IdempotencyKey) {
+	err := app.store.BeginTx(ctx, &sql.TxOptions{}, func(repos *handlers.Repositories) error {
+		return repos.Idempotency.Release(k.Key, k.LockedUntil)
+	})
+	if err != nil {
+		app.logger.Error(err.Error(), "idempotency_key", k.Key)
+	}
+}
+
+// requestHash tells requests made with the same Idempotency-Key apart. It
+// covers the method, path and query as well as the body, so a key can't be
+// reused for another route either.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	_ "api/cmd/api/docs"
+	"api/cmd/api/handlers"
 	"api/cmd/api/utils"
 	"api/internal/env"
 	"api/internal/version"
@@ -28,8 +31,24 @@ func main() {
 }
 
 type config struct {
-	baseURL  string
-	httpPort int
+	baseURL                  string
+	httpPort                 int
+	db                       utils.Config
+	requireLatestSchema      bool
+	memoryStore              bool
+	maxPageSize              int
+	maxBatchSize             int
+	adminToken               string
+	requireIfMatch           bool
+	idempotencyKeyTTL        time.Duration
+	idempotencyLease         time.Duration
+	idempotencyPurgeInterval time.Duration
+	reactionKinds            []string
+	purge                    struct {
+		retention time.Duration
+		interval  time.Duration
+	}
+	publishInterval time.Duration
 }
 
 type application struct {
@@ -37,6 +56,7 @@ type application struct {
 	logger *slog.Logger
 	wg     sync.WaitGroup
 	db     *utils.DB
+	store  handlers.Store
 }
 
 func run(logger *slog.Logger) error {
@@ -44,6 +64,39 @@ func run(logger *slog.Logger) error {
 
 	cfg.baseURL = env.GetString("BASE_URL", "http://localhost:4444")
 	cfg.httpPort = env.GetInt("PORT", 4444)
+	cfg.db.MaxOpenConns = env.GetInt("DB_MAX_OPEN_CONNS", 20)
+	cfg.db.MaxIdleConns = env.GetInt("DB_MAX_IDLE_CONNS", 10)
+	cfg.db.ConnMaxLifetime = env.GetDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute)
+	cfg.db.ConnMaxIdleTime = env.GetDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute)
+	cfg.db.TxMaxRetries = env.GetInt("DB_TX_MAX_RETRIES", 5)
+	cfg.requireLatestSchema = env.GetBool("DB_REQUIRE_LATEST_SCHEMA", false)
+	// DATABASE_URL=memory:// keeps everything in memory, for demos without
+	// a database. Nothing is persisted across restarts.
+	cfg.memoryStore = env.GetString("DATABASE_URL", "") == "memory://"
+	cfg.maxPageSize = env.GetInt("MAX_PAGE_SIZE", 100)
+	cfg.maxBatchSize = env.GetInt("MAX_BATCH_SIZE", 1000)
+	cfg.adminToken = env.GetString("ADMIN_TOKEN", "")
+	// Makes clients send If-Match on every PUT and DELETE of a single
+	// resource, so that they can't overwrite changes they haven't seen.
+	cfg.requireIfMatch = env.GetBool("REQUIRE_IF_MATCH", false)
+	// How long the response to a request with an Idempotency-Key is kept to
+	// be replayed to its retries.
+	cfg.idempotencyKeyTTL = env.GetDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+	// How long a request holds its key. A retry can run the request again
+	// once it has passed, if the request died before it wrote anything, so
+	// it should be longer than the slowest request.
+	cfg.idempotencyLease = env.GetDuration("IDEMPOTENCY_LEASE", time.Minute)
+	// How often expired keys are deleted, apart from PURGE_INTERVAL, which
+	// is about soft deleted rows.
+	cfg.idempotencyPurgeInterval = env.GetDuration("IDEMPOTENCY_PURGE_INTERVAL", time.Hour)
+	// The kinds of reactions to posts besides like, comma separated.
+	cfg.reactionKinds = reactionKinds(env.GetString("REACTION_KINDS", "love,laugh,wow,sad,angry"))
+	// Soft deleted rows are hard deleted once they are older than the
+	// retention. 0 keeps them forever.
+	cfg.purge.retention = env.GetDuration("SOFT_DELETE_RETENTION", 30*24*time.Hour)
+	cfg.purge.interval = env.GetDuration("PURGE_INTERVAL", time.Hour)
+	// How often scheduled posts that are due get published.
+	cfg.publishInterval = env.GetDuration("PUBLISH_INTERVAL", time.Minute)
 
 	showVersion := flag.Bool("version", false, "display version and exit")
 
@@ -54,12 +107,60 @@ func run(logger *slog.Logger) error {
 		return nil
 	}
 
-	db := utils.NewDB()
+	err := checkInterval("PURGE_INTERVAL", cfg.purge.interval)
+	if err != nil {
+		return err
+	}
+	err = checkInterval("PUBLISH_INTERVAL", cfg.publishInterval)
+	if err != nil {
+		return err
+	}
+	err = checkInterval("IDEMPOTENCY_PURGE_INTERVAL", cfg.idempotencyPurgeInterval)
+	if err != nil {
+		return err
+	}
+	if cfg.idempotencyLease <= 0 {
+		return fmt.Errorf("IDEMPOTENCY_LEASE must be positive, got %s", cfg.idempotencyLease)
+	}
+
 	app := &application{
 		config: cfg,
 		logger: logger,
-		db:     &db,
+	}
+
+	db := &utils.DB{}
+	if cfg.memoryStore {
+		app.store = handlers.NewMemoryStore()
+	} else {
+		db, err = utils.NewDB(cfg.db)
+		if err != nil {
+			return err
+		}
+		app.store = handlers.NewSQLStore(db)
+	}
+	app.db = db
+
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		defer db.Close()
+		return app.migrate(args[1:])
+	}
+
+	if cfg.requireLatestSchema && !cfg.memoryStore {
+		err := app.checkSchema(context.Background())
+		if err != nil {
+			db.Close()
+			return err
+		}
 	}
 
 	return app.serveHTTP()
 }
+
+// checkInterval refuses an interval of a background task that isn't
+// positive, which time.NewTicker panics on.
+func checkInterval(name string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("%s must be positive, got %s", name, interval)
+	}
+	return nil
+}
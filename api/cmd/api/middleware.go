@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
 
 	"api/cmd/api/handlers"
 	"api/internal/response"
 
+	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"github.com/tomasen/realip"
 )
@@ -28,6 +32,23 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// rgxRequestID is what X-Request-Id may look like to be passed on as is.
+var rgxRequestID = regexp.MustCompile(`^[\w.:-]{1,128}$`)
+
+// requestID gives every request an id, the X-Request-Id sent by the client
+// or a proxy in front, or a new one. It is sent back in X-Request-Id, logged
+// and recorded in the audit log.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !rgxRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, contextSetRequestID(r, id))
+	})
+}
+
 func (app *application) logAccess(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		mw := response.NewMetricsResponseWriter(w)
@@ -41,15 +62,62 @@ func (app *application) logAccess(next http.Handler) http.Handler {
 		)
 
 		userAttrs := slog.Group("user", "ip", ip)
-		requestAttrs := slog.Group("request", "method", method, "url", url, "proto", proto)
+		requestAttrs := slog.Group("request", "id", contextRequestID(r.Context()), "method", method, "url", url, "proto", proto)
 		responseAttrs := slog.Group("repsonse", "status", mw.StatusCode, "size", mw.BytesCount)
 
 		app.logger.Info("access", userAttrs, requestAttrs, responseAttrs)
 	})
 }
 
+// authenticate marks requests carrying the admin token as admin. There are
+// no user accounts, the token only unlocks admin features such as
+// include_deleted.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || app.config.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(app.config.adminToken)) != 1 {
+			app.invalidAuthenticationToken(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, contextSetAdmin(r))
+	})
+}
+
+// identify takes the user making the request from X-User-Id. There are no
+// accounts, the header is trusted as it is. It only says which posts the
+// user reacted to, who reacts, and who made the writes in the audit log.
+func (app *application) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "X-User-Id")
+
+		header := r.Header.Get("X-User-Id")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id, err := uuid.Parse(header)
+		if err != nil {
+			app.errorMessage(w, r, http.StatusBadRequest, "X-User-Id must be a user ID", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(handlers.ContextWithViewer(r.Context(), id)))
+	})
+}
+
 func handleQuery[T any](app *application, handler func(context.Context, httprouter.Params, url.Values) (T, error)) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		r = contextSetAuditor(contextSetIfMatch(r))
 		ctx := r.Context()
 
 		done := make(chan struct{})
@@ -57,6 +125,9 @@ func handleQuery[T any](app *application, handler func(context.Context, httprout
 		var err error
 
 		q := r.URL.Query()
+		if _, ok := contextContentView(ctx); ok {
+			q.Del("content")
+		}
 
 		go func() {
 			result, err = handler(ctx, p, q)
@@ -69,15 +140,22 @@ func handleQuery[T any](app *application, handler func(context.Context, httprout
 
 		case <-done:
 			if err != nil {
-				if httpErr, ok := err.(*handlers.HTTPError); ok {
-					app.errorMessage(w, r, httpErr.Code, httpErr.Message.Error(), nil)
-				} else {
-					app.serverError(w, r, err)
-				}
+				app.handlerError(w, r, err)
 				return
 			}
 
-			err = response.JSON(w, http.StatusOK, result)
+			headers := linkHeader(r, result)
+			if etag, ok := etagOf(result); ok {
+				if r.Method == http.MethodGet && etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+					w.Header()["ETag"] = []string{etag}
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				headers = http.Header{"ETag": {etag}}
+			}
+
+			viewContent(ctx, result)
+			err = response.JSONWithHeaders(w, http.StatusOK, result, headers)
 			if err != nil {
 				app.serverError(w, r, err)
 				return
@@ -86,13 +164,128 @@ func handleQuery[T any](app *application, handler func(context.Context, httprout
 	}
 }
 
-func handleMutation[T any](app *application, handler func(context.Context, httprouter.Params, []byte) (*T, error)) httprouter.Handle {
+// pager is implemented by paginated results, see handlers.List.
+type pager interface {
+	Cursors() (next, prev *string)
+}
+
+// linkHeader advertises the neighbouring pages of a paginated result as an
+// RFC 8288 Link header. The links are relative to the request and keep its
+// other query parameters.
+func linkHeader(r *http.Request, result any) http.Header {
+	p, ok := result.(pager)
+	if !ok {
+		return nil
+	}
+
+	links := []string{}
+	link := func(cursor *string, rel string) {
+		if cursor == nil {
+			return
+		}
+		q := r.URL.Query()
+		q.Set("cursor", *cursor)
+		u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel))
+	}
+	next, prev := p.Cursors()
+	link(next, "next")
+	link(prev, "prev")
+
+	if len(links) == 0 {
+		return nil
+	}
+	return http.Header{"Link": {strings.Join(links, ", ")}}
+}
+
+// etagger is implemented by single resources that have a version, see
+// handlers.Post.ETag.
+type etagger interface {
+	ETag() string
+}
+
+func etagOf(result any) (string, bool) {
+	e, ok := result.(etagger)
+	if !ok {
+		return "", false
+	}
+	return e.ETag(), true
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists
+// etag. If-None-Match compares weakly, ignoring W/ prefixes, and If-Match
+// strongly, so a weak validator never matches it.
+func etagMatches(header, etag string, weak bool) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+			etag = strings.TrimPrefix(etag, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// statusCoder is implemented by results that pick their own status, see
+// handlers.BatchResult.StatusCode.
+type statusCoder interface {
+	StatusCode() int
+}
+
+func statusOf(result any) int {
+	s, ok := result.(statusCoder)
+	if !ok {
+		return http.StatusOK
+	}
+	return s.StatusCode()
+}
+
+// contentViewer is implemented by results that carry the content of posts,
+// see handlers.Post.ViewContent.
+type contentViewer interface {
+	ViewContent(view string)
+}
+
+// viewContent leaves out of the result the parts of the content of posts
+// the request didn't ask for, on the routes of withContentView. It runs
+// once the handler is done, so that what handlers read and write is the
+// whole post.
+func viewContent(ctx context.Context, result any) {
+	view, ok := contextContentView(ctx)
+	if !ok {
+		return
+	}
+	if v, ok := result.(contentViewer); ok {
+		v.ViewContent(view)
+	}
+}
+
+// withContentView lets the requests of a route that returns posts pick the
+// parts of their content with content, see contentView. Other routes leave
+// content to their handler, like any other parameter.
+func (app *application) withContentView(next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		ctx := r.Context()
+		view, err := contentView(r.URL.Query())
+		if err != nil {
+			app.handlerError(w, r, err)
+			return
+		}
+		next(w, contextSetContentView(r, view), p)
+	}
+}
 
-		done := make(chan struct{})
-		var result *T
-		var err error
+func handleMutation[T any](app *application, handler func(context.Context, httprouter.Params, []byte) (*T, error)) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		r = contextSetAuditor(contextSetQuery(contextSetContentType(contextSetIfMatch(r))))
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -101,33 +294,47 @@ func handleMutation[T any](app *application, handler func(context.Context, httpr
 		}
 		defer r.Body.Close()
 
-		go func() {
-			result, err = handler(ctx, p, body)
-			close(done)
-		}()
+		serve := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			done := make(chan struct{})
+			var result *T
+			var err error
 
-		select {
-		case <-ctx.Done():
-			return
+			go func() {
+				result, err = handler(ctx, p, body)
+				close(done)
+			}()
 
-		case <-done:
-			if err != nil {
-				if httpErr, ok := err.(*handlers.HTTPError); ok {
-					app.errorMessage(w, r, httpErr.Code, httpErr.Message.Error(), nil)
-				} else {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-done:
+				if err != nil {
+					app.handlerError(w, r, err)
+					return
+				}
+				if result == nil {
+					app.notFound(w, r)
+					return
+				}
+				var headers http.Header
+				if etag, ok := etagOf(result); ok {
+					headers = http.Header{"ETag": {etag}}
+				}
+				viewContent(ctx, result)
+				err = response.JSONWithHeaders(w, statusOf(result), result, headers)
+				if err != nil {
 					app.serverError(w, r, err)
+					return
 				}
-				return
-			}
-			if result == nil {
-				app.notFound(w, r)
-				return
-			}
-			err = response.JSON(w, http.StatusOK, result)
-			if err != nil {
-				app.serverError(w, r, err)
-				return
 			}
 		}
+
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			app.serveIdempotent(w, r, key, body, serve)
+			return
+		}
+		serve(w, r)
 	}
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"api/cmd/api/handlers"
+	"api/cmd/api/migrations"
+)
+
+const migrateUsage = "usage: api migrate up|down|status|goto <version>"
+
+// migrate runs the `migrate` subcommand against the configured database.
+func (app *application) migrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	conn, err := app.db.Open()
+	if err != nil {
+		return err
+	}
+	m, err := migrations.New(conn, string(app.db.Dialect()))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	var changed []migrations.Migration
+	switch args[0] {
+	case "up":
+		changed, err = m.Up(ctx)
+
+	case "down":
+		changed, err = m.Down(ctx)
+
+	case "goto":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("bad version %q: %w", args[1], parseErr)
+		}
+		changed, err = m.Goto(ctx, version)
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied() {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			name := s.Name
+			if name == "" {
+				name = "(missing from this build)"
+			}
+			fmt.Printf("%04d  %-40s  %s\n", s.Version, name, applied)
+		}
+		return nil
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	for _, mig := range changed {
+		app.logger.Info("migrated", "version", mig.Version, "name", mig.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		app.logger.Info("schema already up to date")
+	}
+	return app.backfillSlugs(ctx, m)
+}
+
+const (
+	// slugsVersion is the migration that gives posts slugs and users
+	// handles, see backfillSlugs.
+	slugsVersion = 15
+	// backfillRows is how many slugs backfillSlugs makes per transaction.
+	backfillRows = 500
+)
+
+// backfillSlugs makes the slugs and handles of the rows that migration 15
+// gave their id, which SQL can't transliterate. It has nothing to do once
+// every row has one, or while the migration isn't applied.
+func (app *application) backfillSlugs(ctx context.Context, m *migrations.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(statuses, func(s migrations.Status) bool { return s.Version == slugsVersion && s.Applied() }) {
+		return nil
+	}
+
+	var posts, users int
+	for {
+		var p, u int
+		err := app.db.BeginTx(ctx, nil, func(tx *sql.Tx) error {
+			var err error
+			p, u, err = handlers.SlugsBackfillTx(tx, backfillRows)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		posts, users = posts+p, users+u
+		if p == 0 && u == 0 {
+			break
+		}
+	}
+	if posts > 0 || users > 0 {
+		app.logger.Info("made slugs", "posts", posts, "users", users)
+	}
+	return nil
+}
+
+// checkSchema refuses to start the server when the database is missing
+// migrations this build depends on.
+func (app *application) checkSchema(ctx context.Context) error {
+	conn, err := app.db.Open()
+	if err != nil {
+		return err
+	}
+	m, err := migrations.New(conn, string(app.db.Dialect()))
+	if err != nil {
+		return err
+	}
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind, %d pending migrations, run `api migrate up`", len(pending))
+	}
+	return nil
+}
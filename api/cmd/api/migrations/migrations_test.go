@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	postgres, err := load("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(postgres) == 0 {
+		t.Fatal("No migrations embedded")
+	}
+	sqlite, err := load("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("Dialects out of sync: %d sqlite and %d postgres migrations", len(sqlite), len(postgres))
+	}
+	for i := range postgres {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("Dialects out of sync at %d_%s", postgres[i].Version, postgres[i].Name)
+		}
+	}
+
+	migrations := append(postgres, sqlite...)
+
+	for i, m := range migrations {
+		if i > 0 && i != len(postgres) && migrations[i-1].Version >= m.Version {
+			t.Errorf("Migrations out of order: %d before %d", migrations[i-1].Version, m.Version)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("Migration %d_%s is empty", m.Version, m.Name)
+		}
+		if strings.Contains(strings.ToUpper(m.Up), "DROP SCHEMA") {
+			t.Errorf("Migration %d_%s drops a schema", m.Version, m.Name)
+		}
+	}
+}
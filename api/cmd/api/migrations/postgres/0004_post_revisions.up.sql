@@ -0,0 +1,22 @@
+-- Every title and content a post has had. rev counts from 1 for each post,
+-- and user_id is the author of the post when the revision was made, kept
+-- without a foreign key since it is history.
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+
+    post_id uuid NOT NULL,
+    rev INTEGER NOT NULL,
+    user_id uuid NOT NULL,
+
+    title TEXT NOT NULL,
+    content TEXT NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    CONSTRAINT post_revisions_post_id_rev_key UNIQUE (post_id, rev)
+);
+
+-- Existing posts start their history from where they are now.
+INSERT INTO post_revisions (post_id, rev, user_id, title, content, created_at)
+SELECT id, 1, user_id, title, content, COALESCE(updated_at, created_at) FROM posts;
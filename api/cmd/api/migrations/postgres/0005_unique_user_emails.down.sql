@@ -0,0 +1,2 @@
+-- Emails stay normalized.
+DROP INDEX IF EXISTS users_email_key;
@@ -0,0 +1,27 @@
+-- Emails are stored trimmed and in lower case, the way the API writes them
+-- from now on.
+UPDATE users SET email = lower(trim(email)), version = version + 1
+WHERE email <> lower(trim(email));
+
+-- Live users that share an email have to be merged or deleted by hand
+-- first, the migration lists them and stops.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(email || ' (' || ids || ')', ', ' ORDER BY email) INTO duplicates
+    FROM (
+        SELECT email, string_agg(id::text, ', ' ORDER BY created_at) AS ids
+        FROM users
+        WHERE deleted_at IS NULL
+        GROUP BY email
+        HAVING count(*) > 1
+    ) AS d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'users share emails, merge or delete them before migrating: %', duplicates;
+    END IF;
+END $$;
+
+-- Soft deleted users give up their email, so restoring one can conflict.
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE deleted_at IS NULL;
@@ -0,0 +1,16 @@
+-- Requests made with an Idempotency-Key header, and the response to replay
+-- to their retries. status is 0 while the request is in flight. Rows are
+-- purged once they expire.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+
+    status INTEGER NOT NULL DEFAULT 0,
+    headers JSONB,
+    body BYTEA,
+
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
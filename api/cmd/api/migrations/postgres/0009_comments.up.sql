@@ -0,0 +1,28 @@
+-- Comments on posts. Replies point at their parent and at the top level
+-- comment of their thread, root_id, so that a thread is read without
+-- recursion. depth is 0 at the top level.
+CREATE TABLE IF NOT EXISTS comments (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+
+    post_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    parent_id uuid,
+    root_id uuid,
+    depth INTEGER NOT NULL DEFAULT 0,
+
+    content TEXT NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE,
+    version BIGINT NOT NULL DEFAULT 1,
+
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_parent FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE,
+    CONSTRAINT fk_root FOREIGN KEY (root_id) REFERENCES comments(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS comments_post_id_idx ON comments (post_id, depth, created_at);
+CREATE INDEX IF NOT EXISTS comments_root_id_idx ON comments (root_id, depth);
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
+CREATE INDEX IF NOT EXISTS comments_user_id_idx ON comments (user_id);
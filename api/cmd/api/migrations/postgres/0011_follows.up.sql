@@ -0,0 +1,20 @@
+-- Users following users. The primary key serves the users someone
+-- follows, which the home feed starts from, and the other index their
+-- followers.
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id uuid NOT NULL,
+    followee_id uuid NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (follower_id, followee_id),
+    CONSTRAINT fk_follower FOREIGN KEY (follower_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_followee FOREIGN KEY (followee_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT follows_self_check CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX IF NOT EXISTS follows_followee_id_idx ON follows (followee_id, created_at);
+
+-- The feed reads live posts newest first, either from each followed user
+-- or from all posts, whichever the planner finds cheaper.
+CREATE INDEX IF NOT EXISTS posts_user_id_created_at_idx ON posts (user_id, created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS posts_created_at_idx ON posts (created_at, id) WHERE deleted_at IS NULL;
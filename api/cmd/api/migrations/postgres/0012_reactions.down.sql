@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS reactions;
+DROP FUNCTION IF EXISTS post_reaction_counts_update();
+DROP TABLE IF EXISTS post_reaction_counts;
@@ -0,0 +1,44 @@
+-- Reactions of users to posts, one of each kind per user and post. Kinds
+-- are configured in the application.
+CREATE TABLE IF NOT EXISTS reactions (
+    post_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    kind TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (post_id, user_id, kind),
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT reactions_kind_check CHECK (kind <> '')
+);
+
+CREATE INDEX IF NOT EXISTS reactions_user_id_idx ON reactions (user_id, post_id);
+
+-- The number of reactions of each kind to a post, kept by the trigger in
+-- the transaction of the reaction, so that lists of posts read counts
+-- instead of counting.
+CREATE TABLE IF NOT EXISTS post_reaction_counts (
+    post_id uuid NOT NULL,
+    kind TEXT NOT NULL,
+    count INTEGER NOT NULL,
+
+    PRIMARY KEY (post_id, kind),
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    CONSTRAINT post_reaction_counts_count_check CHECK (count >= 0)
+);
+
+CREATE OR REPLACE FUNCTION post_reaction_counts_update() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO post_reaction_counts (post_id, kind, count) VALUES (NEW.post_id, NEW.kind, 1)
+        ON CONFLICT (post_id, kind) DO UPDATE SET count = post_reaction_counts.count + 1;
+        RETURN NEW;
+    END IF;
+    UPDATE post_reaction_counts SET count = count - 1 WHERE post_id = OLD.post_id AND kind = OLD.kind;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS post_reaction_counts_update ON reactions;
+CREATE TRIGGER post_reaction_counts_update AFTER INSERT OR DELETE ON reactions
+FOR EACH ROW EXECUTE FUNCTION post_reaction_counts_update();
@@ -0,0 +1,12 @@
+-- Posts are drafts, scheduled for publish_at, published, or archived. Only
+-- published ones are listed. Posts written before statuses were published
+-- when they were created.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published'
+    CONSTRAINT posts_status_check CHECK (status IN ('draft', 'scheduled', 'published', 'archived'));
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS publish_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS published_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE posts SET published_at = created_at WHERE status = 'published' AND published_at IS NULL;
+
+-- The scheduler looks up the scheduled posts that are due, and only those.
+CREATE INDEX IF NOT EXISTS posts_publish_at_idx ON posts (publish_at) WHERE status = 'scheduled';
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS user_handles;
+DROP TABLE IF EXISTS post_slugs;
+
+DROP INDEX IF EXISTS users_handle_key;
+ALTER TABLE users DROP COLUMN IF EXISTS handle;
+
+DROP INDEX IF EXISTS posts_slug_key;
+ALTER TABLE posts DROP COLUMN IF EXISTS slug;
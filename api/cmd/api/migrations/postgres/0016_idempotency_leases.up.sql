@@ -0,0 +1,11 @@
+-- A request holds its idempotency key until locked_until, and retries can
+-- claim the key once that has passed, so that a key doesn't stay in flight
+-- until it expires when its process dies. committed_at is set in the
+-- transaction of the first write of the request, and a key whose request
+-- wrote something is never claimed again. Keys in flight before keep their
+-- expiry as their lease.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+UPDATE idempotency_keys SET locked_until = expires_at WHERE locked_until IS NULL;
+ALTER TABLE idempotency_keys ALTER COLUMN locked_until SET NOT NULL;
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS committed_at TIMESTAMP WITH TIME ZONE;
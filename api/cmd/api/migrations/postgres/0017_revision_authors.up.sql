@@ -0,0 +1,5 @@
+-- Revisions keep who made the change, the user of X-User-Id, apart from
+-- user_id, the owner of the post. It is null when the request didn't say,
+-- and for the revisions recorded before. The header isn't checked against
+-- users, so there is no foreign key.
+ALTER TABLE post_revisions ADD COLUMN IF NOT EXISTS author_id uuid;
@@ -0,0 +1,24 @@
+-- ids are generated in Go, SQLite has no gen_random_uuid().
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT PRIMARY KEY,
+
+    name TEXT NOT NULL,
+    email TEXT NOT NULL,
+
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+    id TEXT PRIMARY KEY,
+
+    user_id TEXT NOT NULL,
+
+    title TEXT NOT NULL,
+    content TEXT NOT NULL,
+
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at TIMESTAMP,
+
+    CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
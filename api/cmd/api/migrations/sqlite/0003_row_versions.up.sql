@@ -0,0 +1,3 @@
+-- Bumped by every write, and served as the ETag of the row.
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE posts ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
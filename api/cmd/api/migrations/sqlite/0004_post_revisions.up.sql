@@ -0,0 +1,25 @@
+-- Every title and content a post has had. rev counts from 1 for each post,
+-- and user_id is the author of the post when the revision was made, kept
+-- without a foreign key since it is history.
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id TEXT PRIMARY KEY,
+
+    post_id TEXT NOT NULL,
+    rev INTEGER NOT NULL,
+    user_id TEXT NOT NULL,
+
+    title TEXT NOT NULL,
+    content TEXT NOT NULL,
+
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    CONSTRAINT post_revisions_post_id_rev_key UNIQUE (post_id, rev)
+);
+
+-- Existing posts start their history from where they are now. The ids are
+-- random UUIDs in the same text form as the ones made in Go.
+INSERT INTO post_revisions (id, post_id, rev, user_id, title, content, created_at)
+SELECT lower(substr(h, 1, 8) || '-' || substr(h, 9, 4) || '-' || substr(h, 13, 4) || '-' || substr(h, 17, 4) || '-' || substr(h, 21)),
+    id, 1, user_id, title, content, COALESCE(updated_at, created_at)
+FROM (SELECT hex(randomblob(16)) AS h, * FROM posts);
@@ -0,0 +1,34 @@
+-- Emails are stored trimmed and in lower case, the way the API writes them
+-- from now on.
+UPDATE users SET email = lower(trim(email)), version = version + 1
+WHERE email <> lower(trim(email));
+
+-- Live users that share an email have to be merged or deleted by hand
+-- first, the migration lists them and stops. SQLite can only raise errors
+-- from triggers, hence the scratch table.
+CREATE TEMP TABLE duplicate_emails (duplicates TEXT NOT NULL);
+
+CREATE TEMP TRIGGER duplicate_emails_found BEFORE INSERT ON duplicate_emails
+BEGIN
+    SELECT RAISE(ABORT, 'users share emails, merge or delete them before migrating: ' || NEW.duplicates);
+END;
+
+INSERT INTO duplicate_emails
+SELECT duplicates FROM (
+    SELECT group_concat(email || ' (' || ids || ')', ', ') AS duplicates
+    FROM (
+        SELECT email, group_concat(id, ', ') AS ids
+        FROM users
+        WHERE deleted_at IS NULL
+        GROUP BY email
+        HAVING count(*) > 1
+        ORDER BY email
+    )
+)
+WHERE duplicates IS NOT NULL;
+
+DROP TRIGGER duplicate_emails_found;
+DROP TABLE duplicate_emails;
+
+-- Soft deleted users give up their email, so restoring one can conflict.
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE deleted_at IS NULL;
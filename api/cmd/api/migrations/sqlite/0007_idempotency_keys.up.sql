@@ -0,0 +1,16 @@
+-- Requests made with an Idempotency-Key header, and the response to replay
+-- to their retries. status is 0 while the request is in flight. Rows are
+-- purged once they expire.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+
+    status INTEGER NOT NULL DEFAULT 0,
+    headers TEXT,
+    body BLOB,
+
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
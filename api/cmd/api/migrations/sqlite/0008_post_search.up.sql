@@ -0,0 +1,26 @@
+-- Full-text search over posts, in an FTS5 table that the triggers keep in
+-- step with posts. The porter tokenizer stems English words, like the
+-- english configuration of Postgres does.
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_search USING fts5(
+    post_id UNINDEXED,
+    title,
+    content,
+    tokenize = 'porter unicode61'
+);
+
+CREATE TRIGGER IF NOT EXISTS posts_search_insert AFTER INSERT ON posts
+BEGIN
+    INSERT INTO posts_search (post_id, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_search_update AFTER UPDATE OF title, content ON posts
+BEGIN
+    UPDATE posts_search SET title = new.title, content = new.content WHERE post_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_search_delete AFTER DELETE ON posts
+BEGIN
+    DELETE FROM posts_search WHERE post_id = old.id;
+END;
+
+INSERT INTO posts_search (post_id, title, content) SELECT id, title, content FROM posts;
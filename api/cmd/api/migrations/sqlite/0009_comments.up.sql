@@ -0,0 +1,28 @@
+-- Comments on posts. Replies point at their parent and at the top level
+-- comment of their thread, root_id, so that a thread is read without
+-- recursion. depth is 0 at the top level.
+CREATE TABLE IF NOT EXISTS comments (
+    id TEXT PRIMARY KEY,
+
+    post_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    parent_id TEXT,
+    root_id TEXT,
+    depth INTEGER NOT NULL DEFAULT 0,
+
+    content TEXT NOT NULL,
+
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    updated_at TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1,
+
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_parent FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE,
+    CONSTRAINT fk_root FOREIGN KEY (root_id) REFERENCES comments(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS comments_post_id_idx ON comments (post_id, depth, created_at);
+CREATE INDEX IF NOT EXISTS comments_root_id_idx ON comments (root_id, depth);
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
+CREATE INDEX IF NOT EXISTS comments_user_id_idx ON comments (user_id);
@@ -0,0 +1,21 @@
+-- Tags on posts. Slugs are normalized by the application: lowercase
+-- letters, digits and single dashes.
+CREATE TABLE IF NOT EXISTS tags (
+    id TEXT PRIMARY KEY,
+    slug TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    CONSTRAINT tags_slug_key UNIQUE (slug),
+    CONSTRAINT tags_slug_check CHECK (slug <> '')
+);
+
+CREATE TABLE IF NOT EXISTS post_tags (
+    post_id TEXT NOT NULL,
+    tag_id TEXT NOT NULL,
+
+    PRIMARY KEY (post_id, tag_id),
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    CONSTRAINT fk_tag FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS post_tags_tag_id_idx ON post_tags (tag_id, post_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS posts_created_at_idx;
+DROP INDEX IF EXISTS posts_user_id_created_at_idx;
+DROP TABLE IF EXISTS follows;
@@ -0,0 +1,39 @@
+-- Reactions of users to posts, one of each kind per user and post. Kinds
+-- are configured in the application.
+CREATE TABLE IF NOT EXISTS reactions (
+    post_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    PRIMARY KEY (post_id, user_id, kind),
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT reactions_kind_check CHECK (kind <> '')
+);
+
+CREATE INDEX IF NOT EXISTS reactions_user_id_idx ON reactions (user_id, post_id);
+
+-- The number of reactions of each kind to a post, kept by the triggers in
+-- the transaction of the reaction, so that lists of posts read counts
+-- instead of counting.
+CREATE TABLE IF NOT EXISTS post_reaction_counts (
+    post_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    count INTEGER NOT NULL,
+
+    PRIMARY KEY (post_id, kind),
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    CONSTRAINT post_reaction_counts_count_check CHECK (count >= 0)
+);
+
+CREATE TRIGGER IF NOT EXISTS post_reaction_counts_insert AFTER INSERT ON reactions
+BEGIN
+    INSERT INTO post_reaction_counts (post_id, kind, count) VALUES (new.post_id, new.kind, 1)
+    ON CONFLICT (post_id, kind) DO UPDATE SET count = count + 1;
+END;
+
+CREATE TRIGGER IF NOT EXISTS post_reaction_counts_delete AFTER DELETE ON reactions
+BEGIN
+    UPDATE post_reaction_counts SET count = count - 1 WHERE post_id = old.post_id AND kind = old.kind;
+END;
@@ -0,0 +1,4 @@
+ALTER TABLE post_revisions DROP COLUMN content_html;
+ALTER TABLE post_revisions DROP COLUMN content_format;
+
+ALTER TABLE posts DROP COLUMN content_format;
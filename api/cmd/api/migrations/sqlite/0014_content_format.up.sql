@@ -0,0 +1,9 @@
+-- Posts are written in plain text or Markdown.
+ALTER TABLE posts ADD COLUMN content_format TEXT NOT NULL DEFAULT 'plain'
+    CONSTRAINT posts_content_format_check CHECK (content_format IN ('plain', 'markdown'));
+
+-- Revisions keep the format of their content and its HTML, rendered once
+-- when the revision is recorded. Revisions recorded before there was HTML
+-- have none, and are rendered when read.
+ALTER TABLE post_revisions ADD COLUMN content_format TEXT NOT NULL DEFAULT 'plain';
+ALTER TABLE post_revisions ADD COLUMN content_html TEXT;
@@ -0,0 +1,36 @@
+-- Posts have a slug made from their title and users a handle made from
+-- their name, for URLs. Ids stay the canonical ones. The application makes
+-- slugs, transliterating what SQL can't, so rows written before get their
+-- id for now and `api migrate up` replaces it right after. SQLite can't
+-- add NOT NULL to a column, the application always sets them.
+ALTER TABLE posts ADD COLUMN slug TEXT;
+UPDATE posts SET slug = id WHERE slug IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS posts_slug_key ON posts (slug);
+
+ALTER TABLE users ADD COLUMN handle TEXT;
+UPDATE users SET handle = id WHERE handle IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_handle_key ON users (handle);
+
+-- Every slug a post ever had, the current one included, so that old links
+-- keep leading to it. A slug is never given to another post, until its post
+-- is purged.
+CREATE TABLE IF NOT EXISTS post_slugs (
+    slug TEXT PRIMARY KEY,
+    post_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS post_slugs_post_id_idx ON post_slugs (post_id);
+
+-- Every handle a user ever had, the same way.
+CREATE TABLE IF NOT EXISTS user_handles (
+    handle TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+    CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS user_handles_user_id_idx ON user_handles (user_id);
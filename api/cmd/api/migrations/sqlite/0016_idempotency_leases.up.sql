@@ -0,0 +1,11 @@
+-- A request holds its idempotency key until locked_until, and retries can
+-- claim the key once that has passed, so that a key doesn't stay in flight
+-- until it expires when its process dies. committed_at is set in the
+-- transaction of the first write of the request, and a key whose request
+-- wrote something is never claimed again. Keys in flight before keep their
+-- expiry as their lease. SQLite can't add NOT NULL to a column, the
+-- application always sets locked_until.
+ALTER TABLE idempotency_keys ADD COLUMN locked_until TIMESTAMP;
+UPDATE idempotency_keys SET locked_until = expires_at WHERE locked_until IS NULL;
+
+ALTER TABLE idempotency_keys ADD COLUMN committed_at TIMESTAMP;
@@ -12,20 +12,72 @@ func (app *application) routes() http.Handler {
 	mux.NotFound = http.HandlerFunc(app.notFound)
 	mux.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowed)
 
+	// httprouter can't tell /api/users/by-email/:email, /api/users/batch or
+	// /api/posts/search, among others, from the routes under /api/users/:id
+	// and /api/posts/:id, so routes with a fixed segment where the id goes get a
+	// router of their own, tried first.
+	lookups := httprouter.New()
+
 	mux.GET("/health", handleQuery(app, app.health))
 	mux.GET("/docs/*any", app.docs())
+	mux.GET("/debug/db", handleQuery(app, app.dbStats))
 
 	mux.GET("/api/users", handleQuery(app, app.usersGetAll))
 	mux.GET("/api/users/:id", handleQuery(app, app.usersGet))
+	lookups.GET("/api/users/by-email/:email", handleQuery(app, app.usersGetByEmail))
+	lookups.GET("/api/users/by-handle/:handle", handleQuery(app, app.usersGetByHandle))
 	mux.POST("/api/users", handleMutation(app, app.usersCreate))
+	lookups.POST("/api/users/batch", handleMutation(app, app.usersBatchCreate))
 	mux.DELETE("/api/users/:id", handleQuery(app, app.usersDelete))
 	mux.PUT("/api/users/:id", handleMutation(app, app.usersUpdate))
+	mux.PATCH("/api/users/:id", handleMutation(app, app.usersPatch))
+	mux.POST("/api/users/:id/restore", handleMutation(app, app.usersRestore))
+	mux.GET("/api/users/:id/posts", app.withContentView(handleQuery(app, app.usersPostsGetAll)))
+	mux.POST("/api/users/:id/posts", app.withContentView(handleMutation(app, app.usersPostsCreate)))
+	mux.POST("/api/users/:id/follow", handleMutation(app, app.usersFollow))
+	mux.DELETE("/api/users/:id/follow", handleQuery(app, app.usersUnfollow))
+	mux.GET("/api/users/:id/followers", handleQuery(app, app.usersFollowersGetAll))
+	mux.GET("/api/users/:id/following", handleQuery(app, app.usersFollowingGetAll))
+	mux.GET("/api/users/:id/feed", app.withContentView(handleQuery(app, app.usersFeedGetAll)))
+
+	mux.GET("/api/posts", app.withContentView(handleQuery(app, app.postsGetAll)))
+	mux.GET("/api/posts/:id", app.withContentView(handleQuery(app, app.postsGet)))
+	lookups.GET("/api/posts/by-slug/:slug", app.withContentView(handleQuery(app, app.postsGetBySlug)))
+	lookups.GET("/api/posts/search", app.withContentView(handleQuery(app, app.postsSearch)))
+	mux.POST("/api/posts", app.withContentView(handleMutation(app, app.postsCreate)))
+	lookups.POST("/api/posts/batch", app.withContentView(handleMutation(app, app.postsBatchCreate)))
+	mux.DELETE("/api/posts/:id", app.withContentView(handleQuery(app, app.postsDelete)))
+	mux.PUT("/api/posts/:id", app.withContentView(handleMutation(app, app.postsUpdate)))
+	mux.PATCH("/api/posts/:id", app.withContentView(handleMutation(app, app.postsPatch)))
+	mux.POST("/api/posts/:id/restore", app.withContentView(handleMutation(app, app.postsRestore)))
+	mux.GET("/api/posts/:id/revisions", handleQuery(app, app.postsRevisionsGetAll))
+	mux.GET("/api/posts/:id/revisions/:rev", handleQuery(app, app.postsRevisionsGet))
+	mux.GET("/api/posts/:id/revisions/:rev/diff", handleQuery(app, app.postsRevisionsDiff))
+	mux.POST("/api/posts/:id/revisions/:rev/revert", app.withContentView(handleMutation(app, app.postsRevisionsRevert)))
+	mux.GET("/api/posts/:id/comments", handleQuery(app, app.postsCommentsGetAll))
+	mux.POST("/api/posts/:id/comments", handleMutation(app, app.postsCommentsCreate))
+	mux.PUT("/api/posts/:id/reactions/:kind", app.withContentView(handleMutation(app, app.postsReactionsPut)))
+	mux.DELETE("/api/posts/:id/reactions/:kind", app.withContentView(handleQuery(app, app.postsReactionsDelete)))
+
+	mux.GET("/api/comments/:id", handleQuery(app, app.commentsGet))
+	mux.PUT("/api/comments/:id", handleMutation(app, app.commentsUpdate))
+	mux.DELETE("/api/comments/:id", handleQuery(app, app.commentsDelete))
 
-	mux.GET("/api/posts", handleQuery(app, app.postsGetAll))
-	mux.GET("/api/posts/:id", handleQuery(app, app.postsGet))
-	mux.POST("/api/posts", handleMutation(app, app.postsCreate))
-	mux.DELETE("/api/posts/:id", handleQuery(app, app.postsDelete))
-	mux.PUT("/api/posts/:id", handleMutation(app, app.postsUpdate))
+	mux.GET("/api/tags", handleQuery(app, app.tagsGetAll))
+	mux.GET("/api/tags/:slug/posts", app.withContentView(handleQuery(app, app.tagsPostsGetAll)))
+
+	mux.GET("/api/audit", handleQuery(app, app.auditGetAll))
+
+	return app.requestID(app.logAccess(app.recoverPanic(app.authenticate(app.identify(withLookups(lookups, mux))))))
+}
 
-	return app.logAccess(app.recoverPanic(mux))
+// withLookups serves the routes of lookups, and everything else with next.
+func withLookups(lookups *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle, params, _ := lookups.Lookup(r.Method, r.URL.Path); handle != nil {
+			handle(w, r, params)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
@@ -42,6 +42,10 @@ func (app *application) serveHTTP() error {
 		shutdownErrorChan <- srv.Shutdown(ctx)
 	}()
 
+	ctx, stopBackgroundTasks := context.WithCancel(context.Background())
+	defer stopBackgroundTasks()
+	app.startBackgroundTasks(ctx)
+
 	app.logger.Info("starting server", slog.Group("server", "addr", srv.Addr))
 
 	err := srv.ListenAndServe()
@@ -56,6 +60,14 @@ func (app *application) serveHTTP() error {
 
 	app.logger.Info("stopped server", slog.Group("server", "addr", srv.Addr))
 
+	stopBackgroundTasks()
 	app.wg.Wait()
+
+	err = app.db.Close()
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("closed database pool")
 	return nil
 }
@@ -3,127 +3,171 @@ package utils
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"os"
-	"testing"
+	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
-type IDB interface {
-	BeginTx(ctx context.Context, opts *sql.TxOptions, fn txFn) error
-	Open() (*sql.DB, error)
-}
+var ErrDBClosed = errors.New("database is closed")
 
-type DB struct{}
+// Dialect is the SQL flavour of the database behind a DB.
+type Dialect string
 
-func NewDB() DB {
-	return DB{}
-}
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
 
-func (db *DB) Open() (*sql.DB, error) {
-	return open("public")
-}
+const sqliteScheme = "sqlite://"
 
-type txFn func(tx *sql.Tx) error
+// Postgres error codes that mean the transaction lost a race with a
+// concurrent one and can be run again from the start.
+const (
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
 
-func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions, fn txFn) error {
-	return beginTx(db, ctx, opts, fn)
-}
+const (
+	defaultTxMaxRetries = 5
+	txRetryBaseDelay    = 10 * time.Millisecond
+	txRetryMaxDelay     = 500 * time.Millisecond
+)
 
-type TestDB struct {
-	Fixture
+type IDB interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions, fn txFn) error
+	Open() (*sql.DB, error)
+	Dialect() Dialect
 }
 
-type Fixture struct {
-	UserId1 uuid.UUID
-	UserId2 uuid.UUID
-	PostId1 uuid.UUID
-	PostId2 uuid.UUID
+// Config holds the connection pool settings for DB.
+type Config struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	TxMaxRetries    int
 }
 
-func TestNewDB(t *testing.T) TestDB {
-	// hardcode test creds because I want to run tests outside of docker through vscode
-	t.Setenv("POSTGRES_USER", "postgres")
-	t.Setenv("POSTGRES_PASSWORD", "posgres349")
-	t.Setenv("POSTGRES_DB_NAME", "postgres")
-	t.Setenv("POSTGRES_DB_HOST", "localhost")
-
-	userId1, _ := uuid.Parse("4a2b9c10-9daf-11ed-93ce-0242ac120001")
-	userId2, _ := uuid.Parse("4a2b9c10-9daf-11ed-93ce-0242ac120002")
-	postId1, _ := uuid.Parse("4a2b9c10-9daf-11ed-93ce-0242ac220001")
-	postId2, _ := uuid.Parse("4a2b9c10-9daf-11ed-93ce-0242ac220002")
-	f := Fixture{
-		UserId1: userId1,
-		UserId2: userId2,
-		PostId1: postId1,
-		PostId2: postId2,
-	}
-	return TestDB{
-		Fixture: f,
-	}
+// DB owns the long-lived connection pool shared by every request. The
+// pool is swapped out atomically on Close, while requests may still be
+// reading it.
+type DB struct {
+	conn       atomic.Pointer[sql.DB]
+	dialect    Dialect
+	maxRetries int
 }
 
-// Open clears the test db and opens a connection.
-func (db *TestDB) Open() (*sql.DB, error) {
-	conn, err := open("test")
-	if err != nil {
-		return nil, err
-	}
-	sql := `
-	DO $$ DECLARE
-		r RECORD;
-	BEGIN
-		FOR r IN (SELECT tablename FROM pg_tables WHERE schemaname = current_schema()) LOOP
-			EXECUTE 'TRUNCATE TABLE ' || quote_ident(r.tablename) || ' cascade';
-		END LOOP;
-	END $$;
-	`
-	_, err = conn.Exec(sql)
+// NewDB creates the connection pool for the public schema. The pool is
+// meant to be created once at startup and closed on shutdown.
+func NewDB(cfg Config) (*DB, error) {
+	conn, dialect, err := open("public")
 	if err != nil {
 		return nil, err
 	}
 
-	// Fixtures
-	_, err = conn.Query(`INSERT INTO users (id, name, email) VALUES ($1, 'user-1', 'email-1');`, db.Fixture.UserId1)
-	if err != nil {
-		return nil, err
-	}
-	_, err = conn.Query(`INSERT INTO users (id, name, email) VALUES ($1, 'user-2', 'email-2');`, db.Fixture.UserId2)
-	if err != nil {
-		return nil, err
-	}
-	_, err = conn.Query(`INSERT INTO posts (id, title, content, user_id) VALUES ($1, 'title-1', 'content-1', $2);`, db.Fixture.PostId1, db.Fixture.UserId1)
-	if err != nil {
-		return nil, err
-	}
-	_, err = conn.Query(`INSERT INTO posts (id,title, content, user_id) VALUES ($1, 'title-2', 'content-2', $2);`, db.Fixture.PostId2, db.Fixture.UserId2)
-	if err != nil {
-		return nil, err
+	conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	conn.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	conn.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	if dialect == SQLite {
+		// SQLite allows a single writer, so queue transactions in the pool
+		// instead of failing them with SQLITE_BUSY.
+		conn.SetMaxOpenConns(1)
 	}
 
-	s := ""
-	err = conn.QueryRow(`select current_schema;`).Scan(&s)
-	if err != nil {
-		return nil, err
+	db := &DB{dialect: dialect, maxRetries: cfg.TxMaxRetries}
+	db.conn.Store(conn)
+	return db, nil
+}
+
+func (db *DB) Dialect() Dialect {
+	return db.dialect
+}
+
+// Open returns the shared pool. It does not create a new one.
+func (db *DB) Open() (*sql.DB, error) {
+	conn := db.conn.Load()
+	if conn == nil {
+		return nil, ErrDBClosed
 	}
+	return conn, nil
+}
 
-	return conn, err
+// Close closes the pool, waiting for in-flight queries to finish. Only the
+// first call closes it.
+func (db *DB) Close() error {
+	conn := db.conn.Swap(nil)
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
 }
 
-func (db *TestDB) BeginTx(ctx context.Context, opts *sql.TxOptions, fn txFn) error {
-	return beginTx(db, ctx, opts, fn)
+// Stats returns the pool statistics, useful to watch for saturation.
+func (db *DB) Stats() sql.DBStats {
+	conn := db.conn.Load()
+	if conn == nil {
+		return sql.DBStats{}
+	}
+	return conn.Stats()
 }
 
-func beginTx(db IDB, ctx context.Context, opts *sql.TxOptions, fn txFn) error {
+type txFn func(tx *sql.Tx) error
+
+// BeginTx runs fn in a transaction on the shared pool. See beginTx for the
+// rollback and retry rules.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions, fn txFn) error {
 	conn, err := db.Open()
 	if err != nil {
 		return err
 	}
+	return beginTx(conn, ctx, opts, db.maxRetries, fn)
+}
+
+// beginTx runs fn in a transaction that is committed when fn returns nil and
+// rolled back when it returns an error or panics. Serialization failures and
+// deadlocks restart the whole transaction after a jittered backoff, up to
+// maxRetries times, so fn must not keep state between attempts other than
+// what it assigns on success.
+func beginTx(conn *sql.DB, ctx context.Context, opts *sql.TxOptions, maxRetries int, fn txFn) error {
+	for attempt := 0; ; attempt++ {
+		err := runTx(conn, ctx, opts, fn)
+		if err == nil || attempt >= maxRetries || !isRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(retryDelay(attempt)):
+		}
+	}
+}
+
+func runTx(conn *sql.DB, ctx context.Context, opts *sql.TxOptions, fn txFn) (err error) {
 	tx, err := conn.BeginTx(ctx, opts)
 	if err != nil {
 		return err
 	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
 	err = fn(tx)
 	if err != nil {
 		return err
@@ -131,10 +175,40 @@ func beginTx(db IDB, ctx context.Context, opts *sql.TxOptions, fn txFn) error {
 	return tx.Commit()
 }
 
-func open(schema string) (*sql.DB, error) {
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+	}
+	return false
+}
+
+// retryDelay grows exponentially with the attempt and picks a random point
+// in the upper half of the window so that colliding transactions spread out.
+func retryDelay(attempt int) time.Duration {
+	d := txRetryMaxDelay
+	if attempt < 16 {
+		d = min(txRetryBaseDelay<<attempt, txRetryMaxDelay)
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// open picks the driver from DATABASE_URL: sqlite:///path/to.db opens a
+// SQLite file, anything else goes to Postgres. The schema only applies to
+// Postgres, a SQLite file is its own schema.
+func open(schema string) (*sql.DB, Dialect, error) {
 	connStr := ""
 	// This will be set on prod by heroku.
 	if url, ok := os.LookupEnv("DATABASE_URL"); ok {
+		if path, ok := strings.CutPrefix(url, sqliteScheme); ok {
+			conn, err := openSQLite(path)
+			return conn, SQLite, err
+		}
 		connStr = url
 	} else {
 		connStr = fmt.Sprintf("user=%s password=%s dbname=%s host=%s sslmode=disable search_path=%s",
@@ -145,5 +219,21 @@ func open(schema string) (*sql.DB, error) {
 			schema,
 		)
 	}
-	return sql.Open("postgres", connStr)
+	conn, err := sql.Open("postgres", connStr)
+	return conn, Postgres, err
+}
+
+func openSQLite(path string) (*sql.DB, error) {
+	if path == "" {
+		return nil, errors.New("sqlite database url needs a file path, like sqlite:///data/trase.db")
+	}
+
+	// Foreign keys are off by default in SQLite and ON DELETE CASCADE depends
+	// on them. Times are stored as UTC text that sorts in time order.
+	dsn := "file:" + path +
+		"?_pragma=foreign_keys(1)" +
+		"&_pragma=journal_mode(WAL)" +
+		"&_pragma=busy_timeout(5000)" +
+		"&_time_format=sqlite"
+	return sql.Open("sqlite", dsn)
 }
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		description string
+		err         error
+		expected    bool
+	}{
+		{"Serialization failure", &pq.Error{Code: "40001"}, true},
+		{"Deadlock", &pq.Error{Code: "40P01"}, true},
+		{"Wrapped serialization failure", fmt.Errorf("insert: %w", &pq.Error{Code: "40001"}), true},
+		{"Foreign key violation", &pq.Error{Code: "23503"}, false},
+		{"Plain error", errors.New("boom"), false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			if isRetryable(tc.err) != tc.expected {
+				t.Errorf("isRetryable(%v) != %v", tc.err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 0; attempt < 100; attempt++ {
+		d := retryDelay(attempt)
+		if d <= 0 || d > txRetryMaxDelay {
+			t.Errorf("Delay out of range for attempt %d: %s", attempt, d)
+		}
+	}
+}
+
+func TestBeginTxRollback(t *testing.T) {
+	db := TestNewDB(t)
+
+	tests := []struct {
+		description string
+		fn          txFn
+	}{
+		{
+			description: "Rollback on error",
+			fn: func(tx *sql.Tx) error {
+				_, err := tx.Exec(`DELETE FROM posts`)
+				if err != nil {
+					return err
+				}
+				return errors.New("boom")
+			},
+		},
+		{
+			description: "Rollback on panic",
+			fn: func(tx *sql.Tx) error {
+				_, err := tx.Exec(`DELETE FROM posts`)
+				if err != nil {
+					return err
+				}
+				panic("boom")
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			conn, err := db.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			func() {
+				defer func() { recover() }()
+				err = db.BeginTx(context.Background(), nil, tc.fn)
+				if err == nil {
+					t.Error("Expected an error")
+				}
+			}()
+
+			count := 0
+			err = conn.QueryRow(`SELECT COUNT(*) FROM posts`).Scan(&count)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if count != 2 {
+				t.Errorf("Transaction was not rolled back, %d posts left", count)
+			}
+		})
+	}
+}
+
+func TestBeginTxRetry(t *testing.T) {
+	db := TestNewDB(t)
+
+	_, err := db.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := 0
+	err = db.BeginTx(context.Background(), nil, func(tx *sql.Tx) error {
+		attempts++
+		if attempts < 3 {
+			return &pq.Error{Code: "40001"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestDBClose(t *testing.T) {
+	t.Setenv("DATABASE_URL", sqliteScheme+t.TempDir()+"/close.db")
+	db, err := NewDB(Config{MaxOpenConns: 1, MaxIdleConns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests may still read the pool while it is closed, see go test -race.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				db.Stats()
+				db.Open()
+			}
+		}()
+	}
+	err = db.Close()
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Open()
+	if !errors.Is(err, ErrDBClosed) {
+		t.Errorf("Open after Close: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Second Close: %v", err)
+	}
+}
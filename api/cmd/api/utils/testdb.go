@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"api/cmd/api/migrations"
+
+	"github.com/google/uuid"
+)
+
+type TestDB struct {
+	Fixture
+	conn    *sql.DB
+	dialect Dialect
+}
+
+type Fixture struct {
+	UserId1 uuid.UUID
+	UserId2 uuid.UUID
+	PostId1 uuid.UUID
+	PostId2 uuid.UUID
+}
+
+// TestNewDB opens the test database and migrates it to the latest version.
+// Tests run against the Postgres test schema, or against SQLite when
+// TEST_DATABASE_URL is set to sqlite:// (a fresh file per test) or to
+// sqlite:///path/to.db.
+func TestNewDB(t *testing.T) TestDB {
+	// default to the docker compose creds because I want to run tests outside of docker through vscode
+	testEnv := map[string]string{
+		"POSTGRES_USER":     "postgres",
+		"POSTGRES_PASSWORD": "posgres349",
+		"POSTGRES_DB_NAME":  "postgres",
+		"POSTGRES_DB_HOST":  "localhost",
+	}
+	for key, value := range testEnv {
+		if _, ok := os.LookupEnv(key); !ok {
+			t.Setenv(key, value)
+		}
+	}
+	if path, ok := strings.CutPrefix(os.Getenv("TEST_DATABASE_URL"), sqliteScheme); ok {
+		if path == "" {
+			path = filepath.Join(t.TempDir(), "test.db")
+		}
+		t.Setenv("DATABASE_URL", sqliteScheme+path)
+	}
+
+	conn, dialect, err := open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if dialect == SQLite {
+		conn.SetMaxOpenConns(1)
+	} else {
+		_, err = conn.Exec(`CREATE SCHEMA IF NOT EXISTS test`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := migrations.New(conn, string(dialect))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userId1, _ := uuid.Parse("4a2b9c10-9daf-11ed-93ce-0242ac120001")
+	userId2, _ := uuid.Parse("4a2b9c10-9daf-11ed-93ce-0242ac120002")
+	postId1, _ := uuid.Parse("4a2b9c10-9daf-11ed-93ce-0242ac220001")
+	postId2, _ := uuid.Parse("4a2b9c10-9daf-11ed-93ce-0242ac220002")
+	f := Fixture{
+		UserId1: userId1,
+		UserId2: userId2,
+		PostId1: postId1,
+		PostId2: postId2,
+	}
+	return TestDB{
+		Fixture: f,
+		conn:    conn,
+		dialect: dialect,
+	}
+}
+
+func (db *TestDB) Dialect() Dialect {
+	return db.dialect
+}
+
+// Open clears the test db and returns the test pool.
+func (db *TestDB) Open() (*sql.DB, error) {
+	conn := db.conn
+	err := db.truncate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Fixtures, with explicit and increasing timestamps so that both
+	// backends order them the same way.
+	t := time.Now().UTC().Truncate(time.Microsecond)
+	_, err = conn.Exec(`INSERT INTO users (id, name, handle, email, created_at) VALUES ($1, 'user-1', 'user-1', 'email-1', $2);`, db.Fixture.UserId1, t)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Exec(`INSERT INTO users (id, name, handle, email, created_at) VALUES ($1, 'user-2', 'user-2', 'email-2', $2);`, db.Fixture.UserId2, t.Add(time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Exec(`INSERT INTO posts (id, title, slug, content, user_id, created_at) VALUES ($1, 'title-1', 'title-1', 'content-1', $2, $3);`, db.Fixture.PostId1, db.Fixture.UserId1, t.Add(2*time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Exec(`INSERT INTO posts (id, title, slug, content, user_id, created_at) VALUES ($1, 'title-2', 'title-2', 'content-2', $2, $3);`, db.Fixture.PostId2, db.Fixture.UserId2, t.Add(3*time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Exec(`INSERT INTO user_handles (handle, user_id) VALUES ('user-1', $1), ('user-2', $2);`, db.Fixture.UserId1, db.Fixture.UserId2)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Exec(`INSERT INTO post_slugs (slug, post_id) VALUES ('title-1', $1), ('title-2', $2);`, db.Fixture.PostId1, db.Fixture.PostId2)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, err
+}
+
+func (db *TestDB) truncate() error {
+	if db.dialect == Postgres {
+		_, err := db.conn.Exec(`
+		DO $$ DECLARE
+			r RECORD;
+		BEGIN
+			FOR r IN (SELECT tablename FROM pg_tables WHERE schemaname = current_schema() AND tablename <> 'schema_migrations') LOOP
+				EXECUTE 'TRUNCATE TABLE ' || quote_ident(r.tablename) || ' cascade';
+			END LOOP;
+		END $$;
+		`)
+		return err
+	}
+
+	// Virtual tables, like the FTS5 one behind post search, are emptied
+	// through their own DELETE. Their shadow tables are left alone.
+	rows, err := db.conn.Query(`SELECT name FROM pragma_table_list WHERE schema = 'main' AND type IN ('table', 'virtual') AND name NOT LIKE 'sqlite_%' AND name <> 'schema_migrations'`)
+	if err != nil {
+		return err
+	}
+	tables := []string{}
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	// Triggers can refuse the deletes, like the one keeping audit_log append
+	// only, so they are dropped and put back after.
+	triggers, err := db.dropTriggers()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, trigger := range triggers {
+			db.conn.Exec(trigger)
+		}
+	}()
+
+	// The pool has a single connection, so the pragma applies to the deletes.
+	_, err = db.conn.Exec(`PRAGMA foreign_keys = OFF`)
+	if err != nil {
+		return err
+	}
+	defer db.conn.Exec(`PRAGMA foreign_keys = ON`)
+	for _, table := range tables {
+		_, err := db.conn.Exec(`DELETE FROM "` + table + `"`)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropTriggers drops the SQLite triggers, returning the statements that
+// create them again.
+func (db *TestDB) dropTriggers() ([]string, error) {
+	rows, err := db.conn.Query(`SELECT name, sql FROM sqlite_master WHERE type = 'trigger'`)
+	if err != nil {
+		return nil, err
+	}
+	names, triggers := []string{}, []string{}
+	for rows.Next() {
+		var name, trigger string
+		err := rows.Scan(&name, &trigger)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+		triggers = append(triggers, trigger)
+	}
+	rows.Close()
+
+	for i, name := range names {
+		_, err := db.conn.Exec(`DROP TRIGGER "` + name + `"`)
+		if err != nil {
+			return triggers[:i], err
+		}
+	}
+	return triggers, nil
+}
+
+func (db *TestDB) BeginTx(ctx context.Context, opts *sql.TxOptions, fn txFn) error {
+	return beginTx(db.conn, ctx, opts, defaultTxMaxRetries, fn)
+}
@@ -0,0 +1,44 @@
+package response
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// RecordingResponseWriter passes the response on to the wrapped writer,
+// keeping a copy of its status and body.
+type RecordingResponseWriter struct {
+	StatusCode int
+	Body       bytes.Buffer
+	wrapped    http.ResponseWriter
+}
+
+func NewRecordingResponseWriter(w http.ResponseWriter) *RecordingResponseWriter {
+	return &RecordingResponseWriter{
+		wrapped: w,
+	}
+}
+
+func (rw *RecordingResponseWriter) Header() http.Header {
+	return rw.wrapped.Header()
+}
+
+func (rw *RecordingResponseWriter) WriteHeader(statusCode int) {
+	rw.wrapped.WriteHeader(statusCode)
+
+	if rw.StatusCode == 0 {
+		rw.StatusCode = statusCode
+	}
+}
+
+func (rw *RecordingResponseWriter) Write(b []byte) (int, error) {
+	if rw.StatusCode == 0 {
+		rw.StatusCode = http.StatusOK
+	}
+	rw.Body.Write(b)
+	return rw.wrapped.Write(b)
+}
+
+func (rw *RecordingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.wrapped
+}